@@ -4,6 +4,8 @@ package config
 import (
 	"fmt"
 	"time"
+
+	"github.com/hexagonal-chain/hexchain/pkg/consensus"
 )
 
 // Config represents the complete configuration for a Hexagonal Chain node
@@ -65,6 +67,7 @@ type HexChainConfig struct {
 	MaxNeighbors     int  `json:"maxneighbors"`     // Maximum number of neighbors (1-6)
 	MinNeighbors     int  `json:"minneighbors"`     // Minimum neighbors for finality
 	MeshOptimization bool `json:"meshoptimization"` // Enable dynamic mesh optimization
+	StrictPlacement  bool `json:"strictplacement"`  // Require parent slot d to hold the neighbor in direction d
 
 	// Block production settings
 	BlockTime          time.Duration `json:"blocktime"`       // Target block time
@@ -93,7 +96,9 @@ type ConsensusConfig struct {
 	// HexaProof specific settings
 	FinalizationTime time.Duration `json:"finalizationtime"` // Time to wait for finalization
 	SignatureTimeout time.Duration `json:"signaturetimeout"` // Timeout for neighbor signatures
-	ConflictResolver string        `json:"conflictresolver"` // Algorithm for conflict resolution
+	ConflictResolver string        `json:"conflictresolver"` // Algorithm for conflict resolution (see consensus.ConflictResolvers)
+	MaxParentDepth   uint64        `json:"maxparentdepth"`   // Maximum number distance between a block and its parents
+	MaxFutureDrift   time.Duration `json:"maxfuturedrift"`   // Maximum time a block may be ahead of the local clock
 
 	// Validator settings
 	ValidatorTimeout time.Duration `json:"validatortimeout"` // Validator response timeout
@@ -161,7 +166,9 @@ func DefaultConfig() *Config {
 			Algorithm:        "hexaproof",
 			FinalizationTime: 6 * time.Second,
 			SignatureTimeout: 1 * time.Second,
-			ConflictResolver: "weighted",
+			ConflictResolver: consensus.WeightedResolver,
+			MaxParentDepth:   10,
+			MaxFutureDrift:   15 * time.Second,
 			ValidatorTimeout: 2 * time.Second,
 			RequiredSigners:  3,
 		},
@@ -208,6 +215,8 @@ func TestnetConfig() *Config {
 	cfg.NetworkID = 1337
 	cfg.HexChain.BlockTime = 1 * time.Second // Faster blocks for testing
 	cfg.Consensus.FinalizationTime = 3 * time.Second
+	cfg.Consensus.MaxParentDepth = 32              // Tolerate deeper meshes from fast blocks
+	cfg.Consensus.MaxFutureDrift = 5 * time.Second // Tighter drift for faster blocks
 	return cfg
 }
 
@@ -218,6 +227,8 @@ func MainnetConfig() *Config {
 	cfg.HexChain.BlockTime = 12 * time.Second
 	cfg.Consensus.FinalizationTime = 12 * time.Second
 	cfg.HexChain.MinNeighbors = 4 // Higher security for mainnet
+	cfg.Consensus.MaxParentDepth = 10
+	cfg.Consensus.MaxFutureDrift = 15 * time.Second
 	return cfg
 }
 
@@ -238,6 +249,15 @@ func (c *Config) Validate() error {
 	if c.Consensus.RequiredSigners > c.HexChain.MaxNeighbors {
 		return fmt.Errorf("requiredsigners cannot exceed maxneighbors")
 	}
+	if !consensus.HasConflictResolver(c.Consensus.ConflictResolver) {
+		return fmt.Errorf("unknown conflictresolver %q (available: %v)", c.Consensus.ConflictResolver, consensus.ConflictResolvers())
+	}
+	if c.Consensus.MaxParentDepth < 1 {
+		return fmt.Errorf("maxparentdepth must be at least 1")
+	}
+	if c.Consensus.MaxFutureDrift <= 0 {
+		return fmt.Errorf("maxfuturedrift must be positive")
+	}
 
 	// Check mining settings
 	if c.Mining.Enabled && c.Mining.Threads < 1 {
@@ -261,3 +281,20 @@ func (c *Config) IsValidator() bool {
 func (c *Config) IsLightClient() bool {
 	return c.NodeType == "light"
 }
+
+// HexaProofConfig returns the consensus engine configuration derived from the
+// node configuration. Genesis declared parameters are set by the genesis spec.
+func (c *Config) HexaProofConfig() *consensus.HexaProofConfig {
+	cfg := consensus.DefaultHexaProofConfig()
+	cfg.MaxNeighbors = c.HexChain.MaxNeighbors
+	cfg.MinNeighbors = c.HexChain.MinNeighbors
+	cfg.StrictPlacement = c.HexChain.StrictPlacement
+	cfg.BlockTime = c.HexChain.BlockTime
+	cfg.FinalizationTime = c.Consensus.FinalizationTime
+	cfg.SignatureTimeout = c.Consensus.SignatureTimeout
+	cfg.ConflictResolver = c.Consensus.ConflictResolver
+	cfg.ValidatorTimeout = c.Consensus.ValidatorTimeout
+	cfg.MaxParentDepth = c.Consensus.MaxParentDepth
+	cfg.MaxFutureDrift = c.Consensus.MaxFutureDrift
+	return cfg
+}
@@ -0,0 +1,337 @@
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+var ErrFinalityConflict = errors.New("header conflicts with finalized blocks")
+
+var (
+	finalizedFrontierKey = []byte("hexaproof-finalized") // Database key of the finalized frontier
+	finalPrefix          = []byte("hexaproof-final-")    // Database key prefix marking finalized blocks
+)
+
+// endorsement tracks the validators that referenced a block that is not final yet
+type endorsement struct {
+	number  uint64
+	time    uint64
+	parents []common.Hash
+	signers map[common.Address]struct{}
+}
+
+// FinalityTracker finalizes blocks once they are referenced, directly or
+// transitively, by blocks sealed by a quorum of validators within the
+// FinalizationTime of the block. The quorum is two thirds of the validator set
+// plus one, but never less than MinNeighbors unless the set is smaller.
+//
+// Finalizing a block finalizes its whole past cone. The finalized frontier, the
+// final blocks not referenced by other final blocks, is persisted.
+type FinalityTracker struct {
+	engine *HexaProof
+	db     ethdb.Database // Database persisting finalized blocks, may be nil
+
+	pending  map[common.Hash]*endorsement // Endorsements of blocks awaiting finality
+	final    map[common.Hash]uint64       // Finalized blocks seen since startup, by number
+	frontier map[common.Hash]uint64       // Final blocks without final children, by number
+	lock     sync.RWMutex
+}
+
+// NewFinalityTracker creates a finality tracker, restoring the finalized
+// frontier from the database if one is given
+func NewFinalityTracker(engine *HexaProof, db ethdb.Database) *FinalityTracker {
+	ft := &FinalityTracker{
+		engine:   engine,
+		db:       db,
+		pending:  make(map[common.Hash]*endorsement),
+		final:    make(map[common.Hash]uint64),
+		frontier: make(map[common.Hash]uint64),
+	}
+	if db != nil {
+		if blob, err := db.Get(finalizedFrontierKey); err == nil {
+			var entries []frontierEntry
+			if err := rlp.DecodeBytes(blob, &entries); err != nil {
+				log.Error("Failed to decode finalized frontier", "err", err)
+			}
+			for _, entry := range entries {
+				ft.frontier[entry.Hash] = entry.Number
+				ft.final[entry.Hash] = entry.Number
+			}
+		}
+	}
+	return ft
+}
+
+// frontierEntry is the persisted form of a finalized frontier block
+type frontierEntry struct {
+	Hash   common.Hash
+	Number uint64
+}
+
+// quorum returns the number of distinct endorsing validators needed to
+// finalize a block given the size of the validator set
+func (ft *FinalityTracker) quorum(validators int) int {
+	quorum := validators*2/3 + 1
+	if min := ft.engine.config.MinNeighbors; quorum < min {
+		quorum = min
+	}
+	if quorum > validators {
+		quorum = validators
+	}
+	return quorum
+}
+
+// Add records the endorsements of a verified header for the blocks in its past
+// cone and returns the blocks that became final as a result
+func (ft *FinalityTracker) Add(chain consensus.ChainHeaderReader, header *types.Header) ([]common.Hash, error) {
+	hexHeader, err := hexcore.HexHeaderFromEth(header)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ft.engine.producer(hexHeader)
+	if err != nil {
+		return nil, err
+	}
+	window := uint64(ft.engine.config.FinalizationTime / time.Second)
+
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	// Endorse every block in the past cone that can still be finalized
+	var (
+		ready []common.Hash
+		seen  = make(map[common.Hash]bool)
+		queue = nonEmptyParents(hexHeader)
+	)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] || ft.isFinal(hash) {
+			continue
+		}
+		seen[hash] = true
+
+		record, err := ft.endorsement(chain, hash)
+		if err != nil {
+			return nil, err
+		}
+		// Blocks only get older towards the past, stop once out of the window
+		if record.time+window < header.Time {
+			continue
+		}
+		record.signers[signer] = struct{}{}
+
+		snap, err := ft.engine.snapshot(chain, hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(record.signers) >= ft.quorum(len(snap.Validators)) {
+			ready = append(ready, hash)
+		}
+		queue = append(queue, record.parents...)
+	}
+
+	// Finalize the endorsed blocks together with their past cones
+	var finalized []common.Hash
+	for _, hash := range ready {
+		if ft.isFinal(hash) {
+			continue
+		}
+		blocks, err := ft.finalize(chain, hash)
+		if err != nil {
+			return nil, err
+		}
+		finalized = append(finalized, blocks...)
+	}
+	ft.prune(header.Time, window)
+
+	if len(finalized) > 0 {
+		if err := ft.persist(finalized); err != nil {
+			return nil, err
+		}
+		log.Debug("Finalized hex blocks", "count", len(finalized), "frontier", len(ft.frontier))
+	}
+	return finalized, nil
+}
+
+// nonEmptyParents returns the occupied parent slots of a header
+func nonEmptyParents(header *hexcore.HexHeader) []common.Hash {
+	var parents []common.Hash
+	for _, parentHash := range header.ParentHashes {
+		if parentHash != (common.Hash{}) {
+			parents = append(parents, parentHash)
+		}
+	}
+	return parents
+}
+
+// endorsement returns the endorsement record of a block, creating it if needed
+func (ft *FinalityTracker) endorsement(chain consensus.ChainHeaderReader, hash common.Hash) (*endorsement, error) {
+	if record, ok := ft.pending[hash]; ok {
+		return record, nil
+	}
+	header := chain.GetHeaderByHash(hash)
+	if header == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	hexHeader, err := hexcore.HexHeaderFromEth(header)
+	if err != nil {
+		return nil, err
+	}
+	record := &endorsement{
+		number:  header.Number.Uint64(),
+		time:    header.Time,
+		parents: nonEmptyParents(hexHeader),
+		signers: make(map[common.Address]struct{}),
+	}
+	ft.pending[hash] = record
+	return record, nil
+}
+
+// finalize marks a block and its past cone final and updates the frontier
+func (ft *FinalityTracker) finalize(chain consensus.ChainHeaderReader, hash common.Hash) ([]common.Hash, error) {
+	var (
+		finalized []common.Hash
+		queue     = []common.Hash{hash}
+	)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if ft.isFinal(current) {
+			// Final ancestors referenced by a newly final block leave the frontier
+			delete(ft.frontier, current)
+			continue
+		}
+		record, err := ft.endorsement(chain, current)
+		if err != nil {
+			return nil, err
+		}
+		ft.final[current] = record.number
+		delete(ft.pending, current)
+		finalized = append(finalized, current)
+		queue = append(queue, record.parents...)
+	}
+	// Newly final ancestors are referenced by the block, only it joins the frontier
+	ft.frontier[hash] = ft.final[hash]
+	return finalized, nil
+}
+
+// prune drops the endorsements of blocks that fell out of the finality window
+func (ft *FinalityTracker) prune(now, window uint64) {
+	for hash, record := range ft.pending {
+		if record.time+window < now {
+			delete(ft.pending, hash)
+		}
+	}
+}
+
+// persist writes the newly finalized blocks and the frontier to the database
+func (ft *FinalityTracker) persist(finalized []common.Hash) error {
+	if ft.db == nil {
+		return nil
+	}
+	batch := ft.db.NewBatch()
+	for _, hash := range finalized {
+		if err := batch.Put(append(finalPrefix, hash[:]...), []byte{0x01}); err != nil {
+			return err
+		}
+	}
+	blob, err := rlp.EncodeToBytes(ft.frontierEntries())
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(finalizedFrontierKey, blob); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// frontierEntries returns the frontier ordered by number, then hash
+func (ft *FinalityTracker) frontierEntries() []frontierEntry {
+	entries := make([]frontierEntry, 0, len(ft.frontier))
+	for hash, number := range ft.frontier {
+		entries = append(entries, frontierEntry{Hash: hash, Number: number})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Number != entries[j].Number {
+			return entries[i].Number < entries[j].Number
+		}
+		return bytes.Compare(entries[i].Hash[:], entries[j].Hash[:]) < 0
+	})
+	return entries
+}
+
+// isFinal reports whether a block is final, consulting the database for blocks
+// finalized before startup. The caller must hold the lock.
+func (ft *FinalityTracker) isFinal(hash common.Hash) bool {
+	if _, ok := ft.final[hash]; ok {
+		return true
+	}
+	if ft.db != nil {
+		if ok, _ := ft.db.Has(append(finalPrefix, hash[:]...)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal reports whether a block is final
+func (ft *FinalityTracker) IsFinal(hash common.Hash) bool {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+
+	return ft.isFinal(hash)
+}
+
+// FinalizedTips returns the finalized frontier: the final blocks that no other
+// final block references, ordered by number, then hash
+func (ft *FinalityTracker) FinalizedTips() []common.Hash {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+
+	entries := ft.frontierEntries()
+	tips := make([]common.Hash, len(entries))
+	for i, entry := range entries {
+		tips[i] = entry.Hash
+	}
+	return tips
+}
+
+// CheckHeader rejects headers building on a block that can no longer become
+// final: a non-final parent at or below the lowest finalized frontier block
+// lies outside the finalized sub-mesh forever.
+func (ft *FinalityTracker) CheckHeader(chain consensus.ChainHeaderReader, header *hexcore.HexHeader) error {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+
+	if len(ft.frontier) == 0 {
+		return nil
+	}
+	floor := ft.frontierEntries()[0].Number
+	for _, parentHash := range nonEmptyParents(header) {
+		if ft.isFinal(parentHash) {
+			continue
+		}
+		parent := chain.GetHeaderByHash(parentHash)
+		if parent == nil {
+			return consensus.ErrUnknownAncestor
+		}
+		if parent.Number.Uint64() <= floor {
+			return fmt.Errorf("%w: parent %x at %d is below the finalized frontier at %d", ErrFinalityConflict, parentHash, parent.Number, floor)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+// Genesis declares the consensus critical parameters a HexaProof chain starts
+// from: the initial validator set, their stakes, the reward schedule and the
+// block placement and timing rules. The genesis header lists the validators and
+// commits to the other parameters in its vanity, so nodes configured
+// differently derive another genesis hash.
+type Genesis struct {
+	Timestamp  uint64                      `json:"timestamp"`
+	GasLimit   uint64                      `json:"gasLimit"`
+	Position   hexcore.HexCoordinate       `json:"position"`
+	Validators []common.Address            `json:"validators"`
+	Stakes     map[common.Address]*big.Int `json:"stakes,omitempty"`
+	Rewards    *RewardSchedule             `json:"rewards,omitempty"`
+
+	StrictPlacement bool   `json:"strictPlacement,omitempty"` // Whether parent slot d must hold the neighbor in HexDirection d
+	MaxParentDepth  uint64 `json:"maxParentDepth,omitempty"`  // Maximum number distance to a parent (0 selects the default)
+	MaxFutureDrift  uint64 `json:"maxFutureDrift,omitempty"`  // Seconds a block may be ahead of the local clock (0 selects the default)
+}
+
+// genesisParams are the parameters a genesis commits to beyond its validators.
+// Default chain rules are committed to as zero, so genesis hashes predating
+// them are unchanged.
+type genesisParams struct {
+	Stakes  []genesisStake
+	Rewards *RewardSchedule `rlp:"nil"`
+
+	StrictPlacement bool   `rlp:"optional"`
+	MaxParentDepth  uint64 `rlp:"optional"`
+	MaxFutureDrift  uint64 `rlp:"optional"` // Seconds
+}
+
+// genesisStake is the stake of a single validator
+type genesisStake struct {
+	Validator common.Address
+	Stake     *big.Int
+}
+
+// Validate checks the genesis specification for consistency
+func (g *Genesis) Validate() error {
+	if len(g.Validators) == 0 {
+		return errors.New("genesis declares no validators")
+	}
+	if err := validateStakes(g.Stakes); err != nil {
+		return err
+	}
+	if g.Rewards != nil {
+		return g.Rewards.Validate()
+	}
+	return nil
+}
+
+// Configure sets the genesis declared parameters on the engine configuration
+func (g *Genesis) Configure(config *HexaProofConfig) {
+	config.Stakes = g.Stakes
+	config.Rewards = g.Rewards
+	config.StrictPlacement = g.StrictPlacement
+	config.MaxParentDepth = g.MaxParentDepth
+	config.MaxFutureDrift = time.Duration(g.MaxFutureDrift) * time.Second
+}
+
+// ToHeader creates the genesis header, listing the validators in its extra data
+// and committing to the other parameters in its vanity
+func (g *Genesis) ToHeader() *hexcore.HexHeader {
+	config := new(HexaProofConfig)
+	g.Configure(config)
+	commitment := config.genesisCommitment()
+
+	extra := GenesisExtra(g.Validators)
+	copy(extra, commitment[:])
+	validators, _ := checkpointValidators(&hexcore.HexHeader{Extra: extra})
+
+	return &hexcore.HexHeader{
+		HexPosition: g.Position,
+		HexProof: hexcore.HexaProof{
+			Timestamp:    g.Timestamp,
+			ValidatorSet: validators,
+		},
+		Root:        types.EmptyRootHash,
+		TxHash:      types.EmptyTxsHash,
+		ReceiptHash: types.EmptyReceiptsHash,
+		Difficulty:  big.NewInt(1),
+		Number:      big.NewInt(0),
+		GasLimit:    g.GasLimit,
+		Time:        g.Timestamp,
+		Extra:       extra,
+	}
+}
+
+// validateStakes checks that no validator stake is negative
+func validateStakes(stakes map[common.Address]*big.Int) error {
+	for validator, stake := range stakes {
+		if stake == nil || stake.Sign() < 0 {
+			return fmt.Errorf("invalid stake of validator %x", validator)
+		}
+	}
+	return nil
+}
+
+// genesisCommitment returns the genesis vanity committing to the configured
+// stakes, reward schedule and chain rules, the zero hash if there are neither
+// stakes nor rewards and the rules are the default ones
+func (c *HexaProofConfig) genesisCommitment() common.Hash {
+	params := genesisParams{Rewards: c.Rewards, StrictPlacement: c.StrictPlacement}
+	if c.MaxParentDepth != maxParentDepth {
+		params.MaxParentDepth = c.MaxParentDepth
+	}
+	if c.MaxFutureDrift != maxFutureDrift {
+		params.MaxFutureDrift = uint64(c.MaxFutureDrift / time.Second)
+	}
+	if len(c.Stakes) == 0 && c.Rewards == nil && !params.StrictPlacement && params.MaxParentDepth == 0 && params.MaxFutureDrift == 0 {
+		return common.Hash{}
+	}
+	for validator, stake := range c.Stakes {
+		params.Stakes = append(params.Stakes, genesisStake{Validator: validator, Stake: stake})
+	}
+	sort.Slice(params.Stakes, func(i, j int) bool {
+		return bytes.Compare(params.Stakes[i].Validator[:], params.Stakes[j].Validator[:]) < 0
+	})
+	blob, _ := rlp.EncodeToBytes(&params)
+	return crypto.Keccak256Hash(blob)
+}
+
+// GenesisExtra returns the extra data of a genesis hex header authorizing the
+// given validators, with an empty vanity
+func GenesisExtra(validators []common.Address) []byte {
+	sorted := make([]common.Address, len(validators))
+	copy(sorted, validators)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+
+	extra := make([]byte, hexcore.ExtraVanity, hexcore.ExtraVanity+len(sorted)*common.AddressLength+hexcore.ExtraSeal)
+	for _, validator := range sorted {
+		extra = append(extra, validator[:]...)
+	}
+	return append(extra, make([]byte, hexcore.ExtraSeal)...)
+}
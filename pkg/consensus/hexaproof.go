@@ -2,17 +2,24 @@
 package consensus
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/big"
+	"math/rand"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/trie"
 	lru "github.com/hashicorp/golang-lru"
 
@@ -25,14 +32,64 @@ var (
 	ErrInvalidMeshTopology   = errors.New("invalid mesh topology")
 	ErrConflictingParents    = errors.New("conflicting parent states")
 	ErrNeighborTimeout       = errors.New("neighbor validation timeout")
+
+	ErrMissingVanity                   = errors.New("extra-data 32 byte vanity prefix missing")
+	ErrMissingSignature                = errors.New("extra-data 65 byte signature suffix missing")
+	ErrExtraValidators                 = errors.New("non-checkpoint block contains extra validator list")
+	ErrInvalidCheckpointValidators     = errors.New("invalid validator list on checkpoint block")
+	ErrMismatchingCheckpointValidators = errors.New("mismatching validator list on checkpoint block")
+	ErrMismatchingProofValidators      = errors.New("proof validator set does not match active validators")
+	ErrInvalidCheckpointBeneficiary    = errors.New("beneficiary in checkpoint block non-zero")
+	ErrInvalidCheckpointVote           = errors.New("vote nonce in checkpoint block non-zero")
+	ErrInvalidVote                     = errors.New("vote nonce not 0x00..0 or 0xff..f")
+	ErrInvalidVotingChain              = errors.New("invalid voting chain")
+	ErrUnauthorizedValidator           = errors.New("unauthorized validator")
+	ErrMissingSigner                   = errors.New("no signer authorized for sealing")
+	ErrMismatchingProducer             = errors.New("seal does not match the declared producer")
+	ErrUnknownBlock                    = errors.New("unknown block")
+	ErrInvalidDifficulty               = errors.New("difficulty does not match mesh weight")
+	ErrGenesisParamsMismatch           = errors.New("consensus parameters do not match genesis")
+
+	errVerificationAborted = errors.New("header verification aborted")
+)
+
+const (
+	epochLength = uint64(30000) // Default number of blocks after which to checkpoint and reset the pending votes
+
+	maxParentDepth = uint64(10)       // Default maximum number distance to a parent, preventing long-range references
+	maxFutureDrift = 15 * time.Second // Default maximum time a block may be ahead of the local clock
+
+	inmemorySnapshots  = 128  // Number of recent vote snapshots to keep in memory
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
 )
 
+var (
+	nonceAuthVote = hexutil.MustDecode("0xffffffffffffffff") // Magic nonce number to vote on adding a new validator
+	nonceDropVote = hexutil.MustDecode("0x0000000000000000") // Magic nonce number to vote on removing a validator
+
+	snapshotPrefix = []byte("hexaproof-") // Database key prefix of stored validator snapshots
+)
+
+// Ensure HexaProof satisfies the consensus engine interface
+var _ consensus.Engine = (*HexaProof)(nil)
+
 // HexaProof implements the hexagonal consensus mechanism
 type HexaProof struct {
 	config     *HexaProofConfig
 	db         consensus.ChainHeaderReader // Chain database for accessing blocks
-	validators map[common.Address]bool     // Current validator set
+	snapdb     ethdb.Database              // Database to store validator snapshots at checkpoints
+	recents    *lru.Cache                  // Snapshots of the validator set for recent blocks
+	signatures *lru.Cache                  // Producers recovered from recent block seals
 	sigCache   *lru.Cache                  // Signature verification cache
+	resolver   ConflictResolver            // Deterministic conflict resolution rule
+	reach      *hexcore.ReachabilityIndex  // Ancestry queries between parents
+	finality   *FinalityTracker            // Finality gadget rejecting conflicting headers, may be nil
+
+	signer    Signer                  // Validator key sealing locally produced blocks
+	proposals map[common.Address]bool // Current list of proposals we are pushing
+	lock      sync.RWMutex            // Protects the signer and proposals
+
+	now func() time.Time // Clock the timestamp rules and sealing are based on
 }
 
 // HexaProofConfig contains configuration for the HexaProof consensus
@@ -44,6 +101,13 @@ type HexaProofConfig struct {
 	SignatureTimeout time.Duration // Timeout for signature collection
 	ConflictResolver string        // Algorithm for resolving conflicts
 	ValidatorTimeout time.Duration // Timeout for validator responses
+	Epoch            uint64        // Number of blocks after which to checkpoint validators and reset votes
+
+	Stakes          map[common.Address]*big.Int // Validator stakes declared in genesis for weighted resolution (default 1 each)
+	Rewards         *RewardSchedule             // Block reward schedule declared in genesis (nil issues no rewards)
+	StrictPlacement bool                        // Whether parent slot d must hold the neighbor in HexDirection d, declared in genesis
+	MaxParentDepth  uint64                      // Maximum number distance between a block and its parents, declared in genesis (0 selects the default)
+	MaxFutureDrift  time.Duration               // Maximum time a block may be ahead of the local clock, declared in genesis in seconds (0 selects the default)
 }
 
 // DefaultHexaProofConfig returns default configuration
@@ -54,33 +118,319 @@ func DefaultHexaProofConfig() *HexaProofConfig {
 		BlockTime:        2 * time.Second,
 		FinalizationTime: 6 * time.Second,
 		SignatureTimeout: 1 * time.Second,
-		ConflictResolver: "weighted",
+		ConflictResolver: WeightedResolver,
 		ValidatorTimeout: 2 * time.Second,
+		Epoch:            epochLength,
+		MaxParentDepth:   maxParentDepth,
+		MaxFutureDrift:   maxFutureDrift,
 	}
 }
 
-// New creates a new HexaProof consensus engine
-func New(config *HexaProofConfig, db consensus.ChainHeaderReader) *HexaProof {
+// New creates a new HexaProof consensus engine. The genesis declared parameters
+// of the config are checked against the genesis the chain starts from.
+func New(config *HexaProofConfig, db consensus.ChainHeaderReader) (*HexaProof, error) {
 	if config == nil {
 		config = DefaultHexaProofConfig()
 	}
+	if config.ConflictResolver == "" {
+		config.ConflictResolver = WeightedResolver
+	}
+	if err := validateStakes(config.Stakes); err != nil {
+		return nil, err
+	}
+
+	if config.Epoch == 0 {
+		config.Epoch = epochLength
+	}
+	if config.MaxParentDepth == 0 {
+		config.MaxParentDepth = maxParentDepth
+	}
+	if config.MaxFutureDrift == 0 {
+		config.MaxFutureDrift = maxFutureDrift
+	}
 
-	// Initialize signature cache
+	// Initialize snapshot and signature caches
+	recents, _ := lru.New(inmemorySnapshots)
+	signatures, _ := lru.New(inmemorySignatures)
 	sigCache, _ := lru.New(4096)
 
-	return &HexaProof{
+	engine := &HexaProof{
 		config:     config,
 		db:         db,
-		validators: make(map[common.Address]bool),
+		recents:    recents,
+		signatures: signatures,
 		sigCache:   sigCache,
+		reach:      hexcore.NewReachabilityIndex(),
+		proposals:  make(map[common.Address]bool),
+		now:        time.Now,
+	}
+	engine.finality = NewFinalityTracker(engine, nil)
+
+	resolver, err := NewConflictResolver(config.ConflictResolver, engine)
+	if err != nil {
+		return nil, err
+	}
+	engine.resolver = resolver
+
+	return engine, nil
+}
+
+// ConflictResolver returns the conflict resolver configured for the engine, to
+// be shared by the state merger and fork choice
+func (h *HexaProof) ConflictResolver() ConflictResolver {
+	return h.resolver
+}
+
+// StateConflictResolver implements hexcore.ResolverEngine, handing the
+// configured resolver to the state merger and fork choice
+func (h *HexaProof) StateConflictResolver() hexcore.StateConflictResolver {
+	return h.resolver
+}
+
+// StrictPlacement implements hexcore.PlacementEngine, handing the configured
+// placement rule to the block validator
+func (h *HexaProof) StrictPlacement() bool {
+	return h.config.StrictPlacement
+}
+
+// StakeOf implements StakeReader, returning the stake genesis declares for a
+// validator. Validators without a declared stake weigh 1.
+func (h *HexaProof) StakeOf(validator common.Address) *big.Int {
+	if stake, ok := h.config.Stakes[validator]; ok {
+		return stake
+	}
+	return common.Big1
+}
+
+// SetDatabase sets the database validator snapshots are stored in at epoch
+// checkpoints and finalized blocks are persisted in, restoring the finalized
+// frontier. Without a database both are only kept in memory.
+func (h *HexaProof) SetDatabase(db ethdb.Database) {
+	h.snapdb = db
+	h.finality = NewFinalityTracker(h, db)
+}
+
+// SetFinalityTracker replaces the finality tracker consulted during header
+// verification. Headers conflicting with finalized blocks are rejected.
+func (h *HexaProof) SetFinalityTracker(ft *FinalityTracker) {
+	h.finality = ft
+}
+
+// FinalityTracker returns the finality tracker of the engine
+func (h *HexaProof) FinalityTracker() *FinalityTracker {
+	return h.finality
+}
+
+// TrackFinality implements hexcore.FinalityEngine, recording the endorsements
+// of a block stored by the chain
+func (h *HexaProof) TrackFinality(chain consensus.ChainHeaderReader, header *hexcore.HexHeader) ([]common.Hash, error) {
+	if h.finality == nil {
+		return nil, nil
+	}
+	return h.finality.Add(chain, header.ToEthHeader())
+}
+
+// SetClock replaces the wall clock the engine validates timestamps, prepares
+// headers and schedules seals with. It is meant for tests.
+func (h *HexaProof) SetClock(now func() time.Time) {
+	h.now = now
+}
+
+// Authorize injects the signer the engine seals new blocks with
+func (h *HexaProof) Authorize(signer Signer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.signer = signer
+}
+
+// Propose injects a new authorization proposal that the local validator will
+// vote for in the blocks it produces
+func (h *HexaProof) Propose(address common.Address, auth bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.proposals[address] = auth
+}
+
+// Discard drops a currently running proposal, stopping the local validator
+// from casting further votes (either for or against)
+func (h *HexaProof) Discard(address common.Address) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.proposals, address)
+}
+
+// Proposals returns the current proposals the local validator is pushing
+func (h *HexaProof) Proposals() map[common.Address]bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	proposals := make(map[common.Address]bool, len(h.proposals))
+	for address, auth := range h.proposals {
+		proposals[address] = auth
+	}
+	return proposals
+}
+
+// Validators returns the validators authorized to produce children of the
+// block with the given hex hash, following its primary parent lineage
+func (h *HexaProof) Validators(chain consensus.ChainHeaderReader, hash common.Hash) ([]common.Address, error) {
+	snap, err := h.snapshot(chain, hash)
+	if err != nil {
+		return nil, err
 	}
+	return snap.validators(), nil
 }
 
-// Author implements consensus.Engine, returning the header's validator
+// Author implements consensus.Engine, returning the validator that sealed the
+// header. The genesis block is not sealed, its coinbase stands in as producer.
 func (h *HexaProof) Author(header *types.Header) (common.Address, error) {
-	// For HexaProof, we need to extract the validator from the header
-	// This would typically be encoded in the Extra field or through signatures
-	return header.Coinbase, nil
+	if header.Number.Sign() == 0 {
+		return header.Coinbase, nil
+	}
+	hexHeader, err := h.convertToHexHeader(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return h.producer(hexHeader)
+}
+
+// producer returns the validator that sealed a hex header, like Author
+func (h *HexaProof) producer(header *hexcore.HexHeader) (common.Address, error) {
+	if header.Number.Sign() == 0 {
+		return header.Coinbase, nil
+	}
+	return h.ecrecover(header)
+}
+
+// ecrecover extracts the validator address from a sealed header
+func (h *HexaProof) ecrecover(header *hexcore.HexHeader) (common.Address, error) {
+	hash := header.Hash()
+	if address, known := h.signatures.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	if len(header.Extra) < hexcore.ExtraSeal {
+		return common.Address{}, ErrMissingSignature
+	}
+	signature := header.Extra[len(header.Extra)-hexcore.ExtraSeal:]
+
+	validator, err := hexcore.RecoverSigner(hexcore.SealHash(header), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	h.signatures.Add(hash, validator)
+	return validator, nil
+}
+
+// checkpointValidators parses the validator list out of a hex header's extra data
+func checkpointValidators(header *hexcore.HexHeader) ([]common.Address, error) {
+	if len(header.Extra) < hexcore.ExtraVanity {
+		return nil, ErrMissingVanity
+	}
+	if len(header.Extra) < hexcore.ExtraVanity+hexcore.ExtraSeal {
+		return nil, ErrMissingSignature
+	}
+	list := header.Extra[hexcore.ExtraVanity : len(header.Extra)-hexcore.ExtraSeal]
+	if len(list)%common.AddressLength != 0 {
+		return nil, ErrInvalidCheckpointValidators
+	}
+	validators := make([]common.Address, len(list)/common.AddressLength)
+	for i := range validators {
+		copy(validators[i][:], list[i*common.AddressLength:])
+	}
+	return validators, nil
+}
+
+// isCheckpoint reports whether a block crosses into a new epoch relative to its
+// primary parent. Parents may be several blocks back in the mesh, so the first
+// block of each lineage beyond an epoch boundary is its checkpoint.
+func (c *HexaProofConfig) isCheckpoint(parent, number uint64) bool {
+	return number == 0 || parent/c.Epoch != number/c.Epoch
+}
+
+// snapshot retrieves the validator snapshot at the block with the given hex
+// hash, replaying the votes along its primary parent lineage from the closest
+// known snapshot. Snapshots are cached and stored by hex hash.
+func (h *HexaProof) snapshot(chain consensus.ChainHeaderReader, hash common.Hash) (*Snapshot, error) {
+	var (
+		headers []*hexcore.HexHeader
+		snap    *Snapshot
+	)
+	for snap == nil {
+		// If an in-memory snapshot was found, use that
+		if s, ok := h.recents.Get(hash); ok {
+			snap = s.(*Snapshot)
+			break
+		}
+		// If an on-disk checkpoint snapshot can be found, use that
+		if h.snapdb != nil {
+			if s, err := loadSnapshot(h.config, h.snapdb, hash); err == nil {
+				log.Trace("Loaded validator snapshot from disk", "number", s.Number, "hash", hash)
+				snap = s
+				break
+			}
+		}
+		header := chain.GetHeaderByHash(hash)
+		if header == nil {
+			return nil, consensus.ErrUnknownAncestor
+		}
+		hexHeader, err := h.convertToHexHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		// The genesis block lists the initial validators
+		if hexHeader.Number.Sign() == 0 {
+			validators, err := checkpointValidators(hexHeader)
+			if err != nil {
+				return nil, err
+			}
+			// The stakes and rewards in use must be those genesis commits to
+			if have, want := common.BytesToHash(hexHeader.Extra[:hexcore.ExtraVanity]), h.config.genesisCommitment(); have != want {
+				return nil, fmt.Errorf("%w: genesis commits to %x, configured %x", ErrGenesisParamsMismatch, have, want)
+			}
+			// Later lookups find it in memory or on disk, so it is stored once
+			snap = newSnapshot(h.config, 0, hexHeader.Hash(), validators)
+			snap.Params = h.config.genesisCommitment()
+			if h.snapdb != nil {
+				if err := snap.store(h.snapdb); err != nil {
+					return nil, err
+				}
+				log.Info("Stored genesis validator snapshot", "hash", hash, "validators", len(validators))
+			}
+			break
+		}
+		headers = append(headers, hexHeader)
+		hash = hexHeader.PrimaryParent()
+	}
+	if len(headers) == 0 {
+		h.recents.Add(snap.Hash, snap)
+		return snap, nil
+	}
+
+	// Replay the lineage oldest first on top of the base snapshot
+	for i := 0; i < len(headers)/2; i++ {
+		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
+	}
+	parent := snap.Number
+	if len(headers) > 1 {
+		parent = headers[len(headers)-2].Number.Uint64()
+	}
+	snap, err := snap.apply(headers, h.producer)
+	if err != nil {
+		return nil, err
+	}
+	h.recents.Add(snap.Hash, snap)
+
+	// If we've reached a checkpoint, persist the snapshot
+	if h.snapdb != nil && h.config.isCheckpoint(parent, snap.Number) {
+		if err := snap.store(h.snapdb); err != nil {
+			return nil, err
+		}
+		log.Trace("Stored validator snapshot to disk", "number", snap.Number, "hash", snap.Hash)
+	}
+	return snap, nil
 }
 
 // VerifyHeader implements consensus.Engine
@@ -94,29 +444,70 @@ func (h *HexaProof) VerifyHeader(chain consensus.ChainHeaderReader, header *type
 	return h.verifyHexHeader(chain, hexHeader)
 }
 
-// VerifyHeaders implements consensus.Engine for batch verification
+// VerifyHeaders implements consensus.Engine, verifying a batch of headers on a
+// pool of workers. Parents may be part of the batch as long as they precede
+// their children; a header only waits for its own parents within the batch and
+// fails if any of them does. Results are delivered in batch order.
 func (h *HexaProof) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header) (chan<- struct{}, <-chan error) {
-	abort := make(chan struct{})
-	results := make(chan error, len(headers))
+	var (
+		abort   = make(chan struct{})
+		results = make(chan error, len(headers))
+		tasks   = make(chan int)
 
+		errs = make([]error, len(headers))
+		done = make([]chan struct{}, len(headers))
+	)
+	// Let headers resolve their parents within the batch, by either hash
+	batch := &batchHeaderReader{ChainHeaderReader: chain, headers: make(map[common.Hash]*types.Header, 2*len(headers))}
+	index := make(map[common.Hash]int, 2*len(headers))
+	for i, header := range headers {
+		batch.headers[header.Hash()], index[header.Hash()] = header, i
+		if hexHeader, err := h.convertToHexHeader(header); err == nil {
+			batch.headers[hexHeader.Hash()], index[hexHeader.Hash()] = header, i
+		}
+		done[i] = make(chan struct{})
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(headers) {
+		workers = len(headers)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range tasks {
+				errs[i] = h.verifyBatchHeader(batch, headers, index, i, errs, done, abort)
+				close(done[i])
+			}
+		}()
+	}
+	// Headers are handed out in order, so every awaited parent is already taken
+	go func() {
+		defer close(tasks)
+		for i := range headers {
+			select {
+			case tasks <- i:
+			case <-abort:
+				return
+			}
+		}
+	}()
 	go func() {
 		defer close(results)
-		for i, header := range headers {
+		for i := range headers {
 			select {
+			case <-done[i]:
 			case <-abort:
 				return
-			default:
-				err := h.VerifyHeader(chain, header)
-				select {
-				case results <- err:
-				case <-abort:
-					return
-				}
+			}
+			select {
+			case results <- errs[i]:
+			case <-abort:
+				return
+			}
 
-				// Log progress for large batches
-				if i > 0 && i%100 == 0 {
-					log.Info("Verified hexagonal headers", "count", i, "total", len(headers))
-				}
+			// Log progress for large batches
+			if i > 0 && i%100 == 0 {
+				log.Info("Verified hexagonal headers", "count", i, "total", len(headers))
 			}
 		}
 	}()
@@ -124,6 +515,54 @@ func (h *HexaProof) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*
 	return abort, results
 }
 
+// verifyBatchHeader verifies the i-th header of a batch once all its parents
+// within the batch have been verified
+func (h *HexaProof) verifyBatchHeader(chain consensus.ChainHeaderReader, headers []*types.Header, index map[common.Hash]int, i int, errs []error, done []chan struct{}, abort <-chan struct{}) error {
+	hexHeader, err := h.convertToHexHeader(headers[i])
+	if err != nil {
+		return fmt.Errorf("failed to convert to hex header: %v", err)
+	}
+	for _, parentHash := range hexHeader.ParentHashes {
+		j, ok := index[parentHash]
+		if parentHash == (common.Hash{}) || !ok {
+			continue
+		}
+		if j >= i {
+			return fmt.Errorf("%w: parent %x follows the header in the batch", consensus.ErrUnknownAncestor, parentHash)
+		}
+		select {
+		case <-done[j]:
+		case <-abort:
+			return errVerificationAborted
+		}
+		if errs[j] != nil {
+			return fmt.Errorf("%w: parent %x failed verification", consensus.ErrUnknownAncestor, parentHash)
+		}
+	}
+	return h.verifyHexHeader(chain, hexHeader)
+}
+
+// batchHeaderReader resolves headers from a verification batch before falling
+// back to the chain
+type batchHeaderReader struct {
+	consensus.ChainHeaderReader
+	headers map[common.Hash]*types.Header // Batch headers by Ethereum and hex hash
+}
+
+func (r *batchHeaderReader) GetHeaderByHash(hash common.Hash) *types.Header {
+	if header, ok := r.headers[hash]; ok {
+		return header
+	}
+	return r.ChainHeaderReader.GetHeaderByHash(hash)
+}
+
+func (r *batchHeaderReader) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header, ok := r.headers[hash]; ok && header.Number.Uint64() == number {
+		return header
+	}
+	return r.ChainHeaderReader.GetHeader(hash, number)
+}
+
 // verifyHexHeader performs hexagonal-specific header validation
 func (h *HexaProof) verifyHexHeader(chain consensus.ChainHeaderReader, header *hexcore.HexHeader) error {
 	// 1. Basic structure validation
@@ -136,22 +575,39 @@ func (h *HexaProof) verifyHexHeader(chain consensus.ChainHeaderReader, header *h
 		return err
 	}
 
-	// 3. Mesh topology validation
+	// 3. Finality validation
+	if h.finality != nil {
+		if err := h.finality.CheckHeader(chain, header); err != nil {
+			return err
+		}
+	}
+
+	// 4. Mesh topology validation
 	if err := h.validateMeshTopology(chain, header); err != nil {
 		return err
 	}
 
-	// 4. Neighbor count validation
+	// 5. Neighbor count validation
 	if err := h.validateNeighborCount(header); err != nil {
 		return err
 	}
 
-	// 5. Timestamp validation
+	// 6. Timestamp validation
 	if err := h.validateTimestamp(chain, header); err != nil {
 		return err
 	}
 
-	// 6. HexaProof validation
+	// 7. Mesh weight validation
+	if want := h.meshWeight(chain, header); header.Difficulty == nil || header.Difficulty.Cmp(want) != 0 {
+		return fmt.Errorf("%w: have %v, want %v", ErrInvalidDifficulty, header.Difficulty, want)
+	}
+
+	// 8. Validator set and seal validation
+	if err := h.validateSeal(chain, header); err != nil {
+		return err
+	}
+
+	// 9. HexaProof validation
 	if err := h.validateHexaProof(chain, header); err != nil {
 		return err
 	}
@@ -212,10 +668,8 @@ func (h *HexaProof) validateParents(chain consensus.ChainHeaderReader, header *h
 		}
 
 		// Parent should not be too old (prevent long-range attacks)
-		maxDepthDiff := uint64(10) // Configure this
-		if header.Number.Uint64()-parentHeader.Number.Uint64() > maxDepthDiff {
-			return fmt.Errorf("parent too old: depth difference %d > max %d",
-				header.Number.Uint64()-parentHeader.Number.Uint64(), maxDepthDiff)
+		if depth := header.Number.Uint64() - parentHeader.Number.Uint64(); depth > h.config.MaxParentDepth {
+			return fmt.Errorf("parent too old: depth difference %d > max %d", depth, h.config.MaxParentDepth)
 		}
 	}
 
@@ -224,43 +678,66 @@ func (h *HexaProof) validateParents(chain consensus.ChainHeaderReader, header *h
 
 // validateMeshTopology ensures the mesh structure is valid
 func (h *HexaProof) validateMeshTopology(chain consensus.ChainHeaderReader, header *hexcore.HexHeader) error {
-	// Check for circular references
+	// A block cannot reference itself
 	for _, parentHash := range header.ParentHashes {
-		if parentHash == (common.Hash{}) {
-			continue
-		}
-
-		// A block cannot reference itself
-		if parentHash == header.Hash() {
+		if parentHash != (common.Hash{}) && parentHash == header.Hash() {
 			return errors.New("block cannot reference itself")
 		}
+	}
 
-		// Check if any parent also references this block (circular dependency)
-		parentHeader := chain.GetHeaderByHash(parentHash)
-		if parentHeader != nil {
-			// Convert parent to hex header and check its parents
-			parentHexHeader, err := h.convertToHexHeader(parentHeader)
-			if err == nil {
-				for _, grandParentHash := range parentHexHeader.ParentHashes {
-					if grandParentHash == header.Hash() {
-						return errors.New("circular reference detected")
-					}
-				}
+	// Parents must be distinct and none may precede another
+	lookup := h.hexHeaderLookup(chain)
+	if err := h.reach.ValidateParents(lookup, header.ParentHashes); err != nil {
+		return err
+	}
+
+	// Strict placement ties every parent slot to the neighbor cell in its
+	// direction, loose placement accepts parents anywhere
+	if h.config.StrictPlacement {
+		for i, parentHash := range header.ParentHashes {
+			if parentHash == (common.Hash{}) {
+				continue
+			}
+			parent := lookup(parentHash)
+			if parent == nil {
+				return fmt.Errorf("unknown parent %x at position %d", parentHash, i)
+			}
+			if err := hexcore.CheckParentPlacement(header, hexcore.HexDirection(i), parent); err != nil {
+				return err
 			}
 		}
 	}
 
-	// Validate hexagonal coordinate constraints
-	neighbors := header.HexPosition.Neighbors()
-	validNeighborPositions := make(map[hexcore.HexCoordinate]bool)
-	for _, neighbor := range neighbors {
-		validNeighborPositions[neighbor] = true
-	}
+	return nil
+}
 
-	// Check that parent positions are valid neighbors (if we have position data)
-	// This is optional validation that can be enhanced later
+// hexHeaderLookup resolves the hex headers of blocks known to the chain
+func (h *HexaProof) hexHeaderLookup(chain consensus.ChainHeaderReader) hexcore.HeaderLookup {
+	return func(hash common.Hash) *hexcore.HexHeader {
+		header := chain.GetHeaderByHash(hash)
+		if header == nil {
+			return nil
+		}
+		hexHeader, err := h.convertToHexHeader(header)
+		if err != nil {
+			return nil
+		}
+		return hexHeader
+	}
+}
 
-	return nil
+// meshWeight computes the difficulty of a header from its parents in the chain
+func (h *HexaProof) meshWeight(chain consensus.ChainHeaderReader, header *hexcore.HexHeader) *big.Int {
+	var (
+		lookup  = h.hexHeaderLookup(chain)
+		parents [6]*hexcore.HexHeader
+	)
+	for i, parentHash := range header.ParentHashes {
+		if parentHash != (common.Hash{}) {
+			parents[i] = lookup(parentHash)
+		}
+	}
+	return new(big.Int).SetUint64(hexcore.MeshWeight(header, parents))
 }
 
 // validateNeighborCount checks neighbor count constraints
@@ -303,15 +780,98 @@ func (h *HexaProof) validateTimestamp(chain consensus.ChainHeaderReader, header
 	}
 
 	// Block timestamp should not be too far in the future
-	now := uint64(time.Now().Unix())
-	maxFuture := uint64(15) // seconds
-	if header.Time > now+maxFuture {
-		return fmt.Errorf("timestamp too far in future: %d > %d", header.Time, now+maxFuture)
+	if limit := uint64(h.now().Add(h.config.MaxFutureDrift).Unix()); header.Time > limit {
+		return fmt.Errorf("timestamp too far in future: %d > %d", header.Time, limit)
 	}
 
 	return nil
 }
 
+// validateSeal checks the vote and checkpoint fields of the header and that it
+// was sealed by a validator authorized on its primary parent lineage
+func (h *HexaProof) validateSeal(chain consensus.ChainHeaderReader, header *hexcore.HexHeader) error {
+	validators, err := checkpointValidators(header)
+	if err != nil {
+		return err
+	}
+	if header.Number.Uint64() == 0 {
+		return nil
+	}
+
+	parent := chain.GetHeaderByHash(header.PrimaryParent())
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	snap, err := h.snapshot(chain, header.PrimaryParent())
+	if err != nil {
+		return err
+	}
+	active := snap.validators()
+
+	// Checkpoint blocks list the active validators and carry no votes
+	if h.config.isCheckpoint(parent.Number.Uint64(), header.Number.Uint64()) {
+		if header.Coinbase != (common.Address{}) {
+			return ErrInvalidCheckpointBeneficiary
+		}
+		if !bytes.Equal(header.Nonce[:], nonceDropVote) {
+			return ErrInvalidCheckpointVote
+		}
+		if !equalValidators(validators, active) {
+			return ErrMismatchingCheckpointValidators
+		}
+	} else {
+		if len(validators) != 0 {
+			return ErrExtraValidators
+		}
+		if !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+			return ErrInvalidVote
+		}
+	}
+	if !equalValidators(header.HexProof.ValidatorSet, active) {
+		return ErrMismatchingProofValidators
+	}
+
+	// Resolve the producer and check it against the declared one and the
+	// validator set
+	validator, err := h.producer(header)
+	if err != nil {
+		return err
+	}
+	if validator != header.HexProof.Producer {
+		return fmt.Errorf("%w: sealed by %x, declared %x", ErrMismatchingProducer, validator, header.HexProof.Producer)
+	}
+	if _, ok := snap.Validators[validator]; !ok {
+		return fmt.Errorf("%w: %x", ErrUnauthorizedValidator, validator)
+	}
+	return nil
+}
+
+// activeValidators returns the validators authorized to produce the header by
+// the snapshot at its primary parent, or those listed in genesis
+func (h *HexaProof) activeValidators(chain consensus.ChainHeaderReader, header *hexcore.HexHeader) ([]common.Address, error) {
+	if header.Number.Sign() == 0 {
+		return checkpointValidators(header)
+	}
+	parent := chain.GetHeaderByHash(header.PrimaryParent())
+	if parent == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	return h.Validators(chain, header.PrimaryParent())
+}
+
+// equalValidators reports whether two validator lists are identical
+func equalValidators(a, b []common.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // validateHexaProof validates the consensus proof
 func (h *HexaProof) validateHexaProof(chain consensus.ChainHeaderReader, header *hexcore.HexHeader) error {
 	proof := &header.HexProof
@@ -336,60 +896,60 @@ func (h *HexaProof) validateHexaProof(chain consensus.ChainHeaderReader, header
 		}
 	}
 
-	// Validate signature count matches neighbor count
-	validSignatures := 0
-	for _, sig := range proof.NeighborSignatures {
-		if len(sig) > 0 {
-			validSignatures++
+	// Verify each neighbor signature against the producer of its parent
+	parentSigner := func(hash common.Hash) (common.Address, error) {
+		parent := chain.GetHeaderByHash(hash)
+		if parent == nil {
+			return common.Address{}, consensus.ErrUnknownAncestor
 		}
+		return h.Author(parent)
 	}
-
-	// We expect at least one signature per neighbor
-	if validSignatures < int(header.NeighborCount) {
-		return fmt.Errorf("insufficient signatures: got %d, need %d",
-			validSignatures, header.NeighborCount)
+	recoverSigner := func(dir hexcore.HexDirection) (common.Address, error) {
+		return h.recoverNeighborSigner(header, dir)
+	}
+	active, err := h.activeValidators(chain, header)
+	if err != nil {
+		return err
+	}
+	if err := hexcore.VerifyNeighborSignatures(header, active, parentSigner, recoverSigner); err != nil {
+		return err
 	}
 
-	// TODO: Validate actual cryptographic signatures
 	// TODO: Validate state proof against chain state
 	// TODO: Validate mesh proof consistency
 
 	return nil
 }
 
+// cachedSigner is a recovered neighbor signature kept in the signature cache
+type cachedSigner struct {
+	sig    []byte
+	signer common.Address
+}
+
+// recoverNeighborSigner recovers the signer of a neighbor slot, consulting the
+// signature cache before running the elliptic curve recovery
+func (h *HexaProof) recoverNeighborSigner(header *hexcore.HexHeader, dir hexcore.HexDirection) (common.Address, error) {
+	digest := hexcore.NeighborSigningHash(header, dir)
+	sig := header.HexProof.NeighborSignatures[dir]
+
+	if cached, ok := h.sigCache.Get(digest); ok {
+		if entry := cached.(cachedSigner); bytes.Equal(entry.sig, sig) {
+			return entry.signer, nil
+		}
+	}
+	signer, err := hexcore.RecoverSigner(digest, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	h.sigCache.Add(digest, cachedSigner{sig: common.CopyBytes(sig), signer: signer})
+	return signer, nil
+}
+
 // convertToHexHeader converts a standard Ethereum header to hexagonal format
+// by decoding the hexagonal fields packed into its Extra field
 func (h *HexaProof) convertToHexHeader(header *types.Header) (*hexcore.HexHeader, error) {
-	// For now, we'll assume headers already contain hex data in Extra field
-	// In a real implementation, this would be more sophisticated
-
-	hexHeader := &hexcore.HexHeader{
-		// Copy standard fields
-		Coinbase:        header.Coinbase,
-		Root:            header.Root,
-		TxHash:          header.TxHash,
-		ReceiptHash:     header.ReceiptHash,
-		Bloom:           header.Bloom,
-		Difficulty:      header.Difficulty,
-		Number:          header.Number,
-		GasLimit:        header.GasLimit,
-		GasUsed:         header.GasUsed,
-		Time:            header.Time,
-		Extra:           header.Extra,
-		MixDigest:       header.MixDigest,
-		Nonce:           header.Nonce,
-		BaseFee:         header.BaseFee,
-		WithdrawalsHash: header.WithdrawalsHash,
-		BlobGasUsed:     header.BlobGasUsed,
-		ExcessBlobGas:   header.ExcessBlobGas,
-	}
-
-	// Set default hexagonal fields (would be parsed from Extra in real implementation)
-	hexHeader.ParentHashes[0] = header.ParentHash          // Use first parent as primary
-	hexHeader.NeighborCount = 1                            // Default to single parent
-	hexHeader.HexPosition = hexcore.NewHexCoordinate(0, 0) // Default position
-	hexHeader.MeshRoot = header.Root                       // Use state root as mesh root for now
-
-	return hexHeader, nil
+	return hexcore.HexHeaderFromEth(header)
 }
 
 // VerifyUncles implements consensus.Engine - no uncles in hexagonal chain
@@ -401,65 +961,231 @@ func (h *HexaProof) VerifyUncles(chain consensus.ChainReader, block *types.Block
 	return nil
 }
 
-// Prepare implements consensus.Engine
+// Prepare implements consensus.Engine, filling in the vote, checkpoint and
+// validator set fields of a hex header packed by ToEthHeader
 func (h *HexaProof) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
-	// Set up header for hexagonal mining
-	header.Difficulty = h.CalcDifficulty(chain, header.Time, nil)
+	hexHeader, err := h.convertToHexHeader(header)
+	if err != nil {
+		return err
+	}
+	parent := chain.GetHeaderByHash(hexHeader.PrimaryParent())
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	snap, err := h.snapshot(chain, hexHeader.PrimaryParent())
+	if err != nil {
+		return err
+	}
+	checkpoint := h.config.isCheckpoint(parent.Number.Uint64(), header.Number.Uint64())
+
+	// Cast a random pending vote unless this is a checkpoint
+	hexHeader.Coinbase = common.Address{}
+	hexHeader.Nonce = types.BlockNonce{}
+	if !checkpoint {
+		h.lock.RLock()
+		addresses := make([]common.Address, 0, len(h.proposals))
+		for address, authorize := range h.proposals {
+			if snap.validVote(address, authorize) {
+				addresses = append(addresses, address)
+			}
+		}
+		if len(addresses) > 0 {
+			hexHeader.Coinbase = addresses[rand.Intn(len(addresses))]
+			if h.proposals[hexHeader.Coinbase] {
+				copy(hexHeader.Nonce[:], nonceAuthVote)
+			}
+		}
+		h.lock.RUnlock()
+	}
+
+	// Lay out the extra data, listing the validators at checkpoints
+	if len(hexHeader.Extra) < hexcore.ExtraVanity {
+		hexHeader.Extra = append(hexHeader.Extra, bytes.Repeat([]byte{0x00}, hexcore.ExtraVanity-len(hexHeader.Extra))...)
+	}
+	hexHeader.Extra = hexHeader.Extra[:hexcore.ExtraVanity]
+	active := snap.validators()
+	if checkpoint {
+		for _, validator := range active {
+			hexHeader.Extra = append(hexHeader.Extra, validator[:]...)
+		}
+	}
+	hexHeader.Extra = append(hexHeader.Extra, make([]byte, hexcore.ExtraSeal)...)
+	hexHeader.HexProof.ValidatorSet = active
+
+	// Declare the local validator as producer, so rewards can be paid before
+	// the block is sealed
+	h.lock.RLock()
+	if h.signer != nil {
+		hexHeader.HexProof.Producer = h.signer.Address()
+	}
+	h.lock.RUnlock()
+
+	// Schedule the block one block time after its latest parent
+	var parentTime uint64
+	for _, parentHash := range hexHeader.ParentHashes {
+		if parentHash == (common.Hash{}) {
+			continue
+		}
+		if p := chain.GetHeaderByHash(parentHash); p != nil && p.Time > parentTime {
+			parentTime = p.Time
+		}
+	}
+	period := uint64(h.config.BlockTime / time.Second)
+	if period == 0 {
+		period = 1
+	}
+	hexHeader.Time = parentTime + period
+	if now := uint64(h.now().Unix()); hexHeader.Time < now {
+		hexHeader.Time = now
+	}
+
+	// Weigh the block by how well it knits the mesh
+	hexHeader.Difficulty = h.meshWeight(chain, hexHeader)
+
+	*header = *hexHeader.ToEthHeader()
 	return nil
 }
 
-// Finalize implements consensus.Engine
-func (h *HexaProof) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, body *types.Body) {
-	// No block rewards in hexagonal chain for now
-	// State modifications would go here
+// Finalize implements consensus.Engine, paying out the block rewards
+func (h *HexaProof) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state vm.StateDB, body *types.Body) {
+	h.accumulateRewards(chain, header, state)
 }
 
 // FinalizeAndAssemble implements consensus.Engine
 func (h *HexaProof) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, body *types.Body, receipts []*types.Receipt) (*types.Block, error) {
 	// Finalize the block
 	h.Finalize(chain, header, state, body)
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
 
 	// Assemble and return the final block
 	return types.NewBlock(header, body, receipts, trie.NewStackTrie(nil)), nil
 }
 
-// Seal implements consensus.Engine
+// Seal implements consensus.Engine, signing the block with the authorized
+// signer once the slot of its hex cell has come
 func (h *HexaProof) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
-	// HexaProof sealing logic would go here
-	// For now, we'll just return the block as-is
-	select {
-	case results <- block:
-	case <-stop:
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	if header.Number.Sign() == 0 {
+		return ErrUnknownBlock
 	}
+	hexHeader, err := h.convertToHexHeader(header)
+	if err != nil {
+		return err
+	}
+	if len(hexHeader.Extra) < hexcore.ExtraVanity+hexcore.ExtraSeal {
+		return ErrMissingSignature
+	}
+
+	h.lock.RLock()
+	signer := h.signer
+	h.lock.RUnlock()
+	if signer == nil {
+		return ErrMissingSigner
+	}
+
+	// Bail out if we're unauthorized to sign a block
+	snap, err := h.snapshot(chain, hexHeader.PrimaryParent())
+	if err != nil {
+		return err
+	}
+	if _, authorized := snap.Validators[signer.Address()]; !authorized {
+		return fmt.Errorf("%w: %x", ErrUnauthorizedValidator, signer.Address())
+	}
+	if hexHeader.HexProof.Producer != signer.Address() {
+		return fmt.Errorf("%w: signer %x, declared %x", ErrMismatchingProducer, signer.Address(), hexHeader.HexProof.Producer)
+	}
+
+	// Sign all the things
+	sig, err := signer.SignHash(hexcore.SealHash(hexHeader))
+	if err != nil {
+		return err
+	}
+	copy(hexHeader.Extra[len(hexHeader.Extra)-hexcore.ExtraSeal:], sig)
+	sealed := block.WithSeal(hexHeader.ToEthHeader())
+
+	// Wait until the slot of the hex cell opens for this validator
+	delay := time.Unix(int64(header.Time), 0).Sub(h.now()) + h.slotOffset(snap.validators(), hexHeader, signer.Address())
+	log.Trace("Waiting for slot to sign and propagate", "number", header.Number, "delay", common.PrettyDuration(delay))
+
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		select {
+		case results <- sealed:
+		default:
+			log.Warn("Sealing result is not read by miner", "sealhash", hexcore.SealHash(hexHeader))
+		}
+	}()
+
 	return nil
 }
 
-// SealHash implements consensus.Engine
+// cellSlot returns the slot of a hex cell within its neighborhood. The colouring
+// (q + 3r) mod 7 gives every cell and its six neighbors distinct slots.
+func cellSlot(pos hexcore.HexCoordinate) uint64 {
+	slot := (pos.Q + 3*pos.R) % 7
+	if slot < 0 {
+		slot += 7
+	}
+	return uint64(slot)
+}
+
+// slotOffset returns how far into its slot a validator seals a header. Hex cells
+// are assigned to validators in rotation: the assigned validator seals at the
+// start of the slot and the others back off in rotation order, spread evenly
+// over the block time.
+func (h *HexaProof) slotOffset(validators []common.Address, header *hexcore.HexHeader, validator common.Address) time.Duration {
+	n := uint64(len(validators))
+	if n == 0 {
+		return 0
+	}
+	turn := (cellSlot(header.HexPosition) + header.Number.Uint64()) % n
+	for i, v := range validators {
+		if v == validator {
+			distance := (uint64(i) + n - turn) % n
+			return time.Duration(distance) * h.config.BlockTime / time.Duration(n)
+		}
+	}
+	return 0
+}
+
+// SealHash implements consensus.Engine, returning the hash the producer seal
+// signs. It covers every hexagonal header field except the seal itself and the
+// neighbor signatures, see hexcore.SealHash.
 func (h *HexaProof) SealHash(header *types.Header) common.Hash {
-	return crypto.Keccak256Hash(
-		header.ParentHash.Bytes(),
-		header.UncleHash.Bytes(),
-		header.Coinbase.Bytes(),
-		header.Root.Bytes(),
-		header.TxHash.Bytes(),
-		header.ReceiptHash.Bytes(),
-		header.Bloom.Bytes(),
-		header.Difficulty.Bytes(),
-		header.Number.Bytes(),
-		common.BigToHash(big.NewInt(int64(header.GasLimit))).Bytes(),
-		common.BigToHash(big.NewInt(int64(header.GasUsed))).Bytes(),
-		common.BigToHash(big.NewInt(int64(header.Time))).Bytes(),
-		header.Extra,
-		header.MixDigest.Bytes(),
-		header.Nonce[:],
-	)
+	hexHeader, err := h.convertToHexHeader(header)
+	if err != nil {
+		// Headers carrying no hexagonal data cannot be sealed, keep them distinct
+		return header.Hash()
+	}
+	return hexcore.SealHash(hexHeader)
 }
 
-// CalcDifficulty implements consensus.Engine
+// CalcDifficulty implements consensus.Engine, returning the mesh weight of a
+// block extending parent alone. Prepare weighs blocks by all their parents.
 func (h *HexaProof) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
-	// Simplified difficulty calculation for HexaProof
-	// In a real implementation, this would consider mesh topology
-	return big.NewInt(1)
+	hexParent, err := h.convertToHexHeader(parent)
+	if err != nil {
+		return big.NewInt(1)
+	}
+	child := &hexcore.HexHeader{
+		Number:        new(big.Int).Add(parent.Number, common.Big1),
+		Time:          time,
+		NeighborCount: 1,
+	}
+	child.ParentHashes[hexcore.HexEast] = hexParent.Hash()
+	return new(big.Int).SetUint64(hexcore.MeshWeight(child, [6]*hexcore.HexHeader{hexParent}))
+}
+
+// APIs implements consensus.Engine, HexaProof exposes no RPC APIs yet
+func (h *HexaProof) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return nil
 }
 
 // Close implements consensus.Engine
@@ -0,0 +1,1007 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+func TestConflictResolvers(t *testing.T) {
+	var (
+		small = common.HexToAddress("0x01")
+		large = common.HexToAddress("0x02")
+	)
+	candidates := []hexcore.ConflictCandidate{
+		{Direction: hexcore.HexWest, Hash: common.HexToHash("0x03"), Header: &hexcore.HexHeader{Time: 10}, Producer: small},
+		{Direction: hexcore.HexNorthEast, Hash: common.HexToHash("0x02"), Header: &hexcore.HexHeader{Time: 30}, Producer: large},
+		{Direction: hexcore.HexSouthEast, Hash: common.HexToHash("0x01"), Header: &hexcore.HexHeader{Time: 20}, Producer: small},
+	}
+
+	engine := newTestEngine(t, &HexaProofConfig{
+		ConflictResolver: WeightedResolver,
+		Stakes:           map[common.Address]*big.Int{small: big.NewInt(1), large: big.NewInt(5)},
+	})
+
+	tests := []struct {
+		name   string
+		winner int
+	}{
+		{WeightedResolver, 1},
+		{LowestHashResolver, 2},
+		{EarliestResolver, 0},
+		{DirectionResolver, 1},
+	}
+	for _, tt := range tests {
+		resolver, err := NewConflictResolver(tt.name, engine)
+		if err != nil {
+			t.Fatalf("%s: failed to create resolver: %v", tt.name, err)
+		}
+		winner, err := resolver.Resolve(candidates)
+		if err != nil {
+			t.Fatalf("%s: resolve failed: %v", tt.name, err)
+		}
+		if winner != tt.winner {
+			t.Errorf("%s: got winner %d, want %d", tt.name, winner, tt.winner)
+		}
+	}
+
+	if engine.ConflictResolver().Name() != WeightedResolver {
+		t.Errorf("engine resolver: got %s, want %s", engine.ConflictResolver().Name(), WeightedResolver)
+	}
+	if _, err := NewConflictResolver("unknown", engine); err == nil {
+		t.Error("expected error for unknown resolver")
+	}
+	if _, err := New(&HexaProofConfig{ConflictResolver: "unknown"}, nil); !errors.Is(err, ErrUnknownResolver) {
+		t.Errorf("engine with unknown resolver: got %v, want %v", err, ErrUnknownResolver)
+	}
+}
+
+// newTestEngine creates an engine, failing the test on invalid configuration
+func newTestEngine(t *testing.T, config *HexaProofConfig) *HexaProof {
+	engine, err := New(config, nil)
+	if err != nil {
+		t.Fatalf("failed to create engine: %v", err)
+	}
+	return engine
+}
+
+func TestGenesisCommitment(t *testing.T) {
+	validator := common.HexToAddress("0x01")
+	spec := &Genesis{
+		Validators: []common.Address{validator},
+		Stakes:     map[common.Address]*big.Int{validator: big.NewInt(5)},
+	}
+	genesis := spec.ToHeader().ToEthHeader()
+	chain := newTestChainReader(genesis)
+
+	// Engines configured from the genesis spec accept it
+	db := rawdb.NewMemoryDatabase()
+	config := &HexaProofConfig{ConflictResolver: WeightedResolver}
+	spec.Configure(config)
+	engine := newTestEngine(t, config)
+	engine.SetDatabase(db)
+	if _, err := engine.Validators(chain, genesis.Hash()); err != nil {
+		t.Fatalf("genesis rejected: %v", err)
+	}
+	// Engines with other stakes refuse it, even with its snapshot on disk
+	config = &HexaProofConfig{
+		ConflictResolver: WeightedResolver,
+		Stakes:           map[common.Address]*big.Int{validator: big.NewInt(6)},
+	}
+	engine = newTestEngine(t, config)
+	engine.SetDatabase(db)
+	if _, err := engine.Validators(chain, genesis.Hash()); !errors.Is(err, ErrGenesisParamsMismatch) {
+		t.Errorf("mismatching stakes: got %v, want %v", err, ErrGenesisParamsMismatch)
+	}
+	// And a genesis declaring them has another hash
+	spec.Stakes = config.Stakes
+	if spec.ToHeader().ToEthHeader().Hash() == genesis.Hash() {
+		t.Error("genesis hash does not commit to the stakes")
+	}
+	// The reward schedule is committed to as well
+	config = &HexaProofConfig{
+		ConflictResolver: WeightedResolver,
+		Stakes:           map[common.Address]*big.Int{validator: big.NewInt(5)},
+		Rewards:          &RewardSchedule{BurnBaseFee: true},
+	}
+	if _, err := newTestEngine(t, config).Validators(chain, genesis.Hash()); !errors.Is(err, ErrGenesisParamsMismatch) {
+		t.Errorf("mismatching rewards: got %v, want %v", err, ErrGenesisParamsMismatch)
+	}
+
+	// So are the placement and timing rules, which default to the zero commitment
+	for name, modify := range map[string]func(*Genesis){
+		"strict placement": func(g *Genesis) { g.StrictPlacement = true },
+		"parent depth":     func(g *Genesis) { g.MaxParentDepth = 4 },
+		"future drift":     func(g *Genesis) { g.MaxFutureDrift = 30 },
+	} {
+		ruled := &Genesis{Validators: []common.Address{validator}}
+		if !bytes.Equal(ruled.ToHeader().Extra[:hexcore.ExtraVanity], make([]byte, hexcore.ExtraVanity)) {
+			t.Fatalf("%s: default rules committed to", name)
+		}
+		modify(ruled)
+		header := ruled.ToHeader().ToEthHeader()
+		config := &HexaProofConfig{ConflictResolver: WeightedResolver}
+		ruled.Configure(config)
+		if _, err := newTestEngine(t, config).Validators(newTestChainReader(header), header.Hash()); err != nil {
+			t.Errorf("%s: genesis rejected: %v", name, err)
+		}
+		if _, err := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver}).Validators(newTestChainReader(header), header.Hash()); !errors.Is(err, ErrGenesisParamsMismatch) {
+			t.Errorf("%s: default engine: got %v, want %v", name, err, ErrGenesisParamsMismatch)
+		}
+	}
+	strict := &Genesis{Validators: []common.Address{validator}, StrictPlacement: true}
+	config = &HexaProofConfig{ConflictResolver: WeightedResolver}
+	strict.Configure(config)
+	if !newTestEngine(t, config).StrictPlacement() {
+		t.Error("placement rule of the genesis not adopted")
+	}
+}
+
+// testChainReader serves headers from memory by their hex or Ethereum hash,
+// like HexChain
+type testChainReader struct {
+	consensus.ChainHeaderReader
+	headers map[common.Hash]*types.Header
+}
+
+func newTestChainReader(headers ...*types.Header) *testChainReader {
+	r := &testChainReader{headers: make(map[common.Hash]*types.Header)}
+	for _, header := range headers {
+		r.add(header)
+	}
+	return r
+}
+
+func (r *testChainReader) add(header *types.Header) {
+	r.headers[header.Hash()] = header
+	if hexHeader, err := hexcore.HexHeaderFromEth(header); err == nil {
+		r.headers[hexHeader.Hash()] = header
+	}
+}
+
+func (r *testChainReader) GetHeaderByHash(hash common.Hash) *types.Header { return r.headers[hash] }
+
+// hexHash returns the hex hash of a header carrying hexagonal fields
+func hexHash(header *types.Header) common.Hash {
+	hexHeader, err := hexcore.HexHeaderFromEth(header)
+	if err != nil {
+		panic(err)
+	}
+	return hexHeader.Hash()
+}
+
+// produce prepares a child of parent through the engine, seals it with key and
+// stores it in the chain
+func produce(t *testing.T, engine *HexaProof, chain *testChainReader, parent *types.Header, key *ecdsa.PrivateKey, modify func(*hexcore.HexHeader)) *hexcore.HexHeader {
+	hexHeader := sealChild(t, engine, chain, parent, key, modify)
+	chain.add(hexHeader.ToEthHeader())
+	return hexHeader
+}
+
+// sealChild prepares a child of parent through the engine and seals it with key
+func sealChild(t *testing.T, engine *HexaProof, chain consensus.ChainHeaderReader, parent *types.Header, key *ecdsa.PrivateKey, modify func(*hexcore.HexHeader)) *hexcore.HexHeader {
+	skeleton := &hexcore.HexHeader{
+		ParentHashes:  [6]common.Hash{hexHash(parent)},
+		NeighborCount: 1,
+		Number:        new(big.Int).Add(parent.Number, common.Big1),
+		Time:          parent.Time + 1,
+		Difficulty:    common.Big1,
+	}
+	header := skeleton.ToEthHeader()
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("failed to prepare block %d: %v", header.Number, err)
+	}
+	hexHeader, err := hexcore.HexHeaderFromEth(header)
+	if err != nil {
+		t.Fatalf("failed to decode prepared header: %v", err)
+	}
+	hexHeader.HexProof.Producer = crypto.PubkeyToAddress(key.PublicKey)
+	if modify != nil {
+		modify(hexHeader)
+	}
+	sig, err := crypto.Sign(hexcore.SealHash(hexHeader).Bytes(), key)
+	if err != nil {
+		t.Fatalf("failed to seal block %d: %v", header.Number, err)
+	}
+	copy(hexHeader.Extra[len(hexHeader.Extra)-hexcore.ExtraSeal:], sig)
+	return hexHeader
+}
+
+func TestValidatorVoting(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	addrs := make([]common.Address, 4)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	a, b, c, d := addrs[0], addrs[1], addrs[2], addrs[3]
+
+	db := rawdb.NewMemoryDatabase()
+	engine := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver, Epoch: 4})
+	engine.SetDatabase(db)
+
+	genesis := (&hexcore.HexHeader{
+		Number:     common.Big0,
+		Difficulty: common.Big1,
+		Extra:      GenesisExtra([]common.Address{a, b}),
+	}).ToEthHeader()
+	chain := newTestChainReader(genesis)
+
+	// The genesis snapshot is stored once and then served from memory
+	if _, err := engine.Validators(chain, hexHash(genesis)); err != nil {
+		t.Fatalf("failed to retrieve genesis validators: %v", err)
+	}
+	if _, err := loadSnapshot(engine.config, db, hexHash(genesis)); err != nil {
+		t.Fatalf("genesis snapshot not stored: %v", err)
+	}
+	db.Delete(append(snapshotPrefix, hexHash(genesis).Bytes()...))
+	if _, err := engine.Validators(chain, hexHash(genesis)); err != nil {
+		t.Fatalf("failed to retrieve cached genesis validators: %v", err)
+	}
+	if ok, _ := db.Has(append(snapshotPrefix, hexHash(genesis).Bytes()...)); ok {
+		t.Error("genesis snapshot stored again")
+	}
+
+	// Both genesis validators vote C in
+	engine.Propose(c, true)
+	block1 := produce(t, engine, chain, genesis, keys[0], nil)
+	if block1.Coinbase != c {
+		t.Fatalf("vote target: got %x, want %x", block1.Coinbase, c)
+	}
+	if err := engine.validateSeal(chain, block1); err != nil {
+		t.Fatalf("block 1 rejected: %v", err)
+	}
+	block2 := produce(t, engine, chain, block1.ToEthHeader(), keys[1], nil)
+	if err := engine.validateSeal(chain, block2); err != nil {
+		t.Fatalf("block 2 rejected: %v", err)
+	}
+	engine.Discard(c)
+
+	validators, err := engine.Validators(chain, block2.Hash())
+	if err != nil {
+		t.Fatalf("failed to retrieve validators: %v", err)
+	}
+	if !equalValidators(validators, newSnapshot(nil, 0, common.Hash{}, []common.Address{a, b, c}).validators()) {
+		t.Fatalf("validators after vote: got %x", validators)
+	}
+
+	// The new validator may produce, outsiders may not
+	block3 := produce(t, engine, chain, block2.ToEthHeader(), keys[2], nil)
+	if err := engine.validateSeal(chain, block3); err != nil {
+		t.Fatalf("block 3 by new validator rejected: %v", err)
+	}
+	if author, err := engine.Author(block3.ToEthHeader()); err != nil || author != c {
+		t.Errorf("author: got %x (%v), want %x", author, err, c)
+	}
+	outsider := produce(t, engine, chain, block2.ToEthHeader(), keys[3], nil)
+	if err := engine.validateSeal(chain, outsider); !errors.Is(err, ErrUnauthorizedValidator) {
+		t.Errorf("outsider block: got %v, want %v", err, ErrUnauthorizedValidator)
+	}
+	impostor := produce(t, engine, chain, block2.ToEthHeader(), keys[2], func(h *hexcore.HexHeader) {
+		h.HexProof.Producer = a
+	})
+	if err := engine.validateSeal(chain, impostor); !errors.Is(err, ErrMismatchingProducer) {
+		t.Errorf("block declaring another producer: got %v, want %v", err, ErrMismatchingProducer)
+	}
+
+	// Checkpoints must list the active validators
+	block4 := produce(t, engine, chain, block3.ToEthHeader(), keys[0], nil)
+	if err := engine.validateSeal(chain, block4); err != nil {
+		t.Fatalf("checkpoint block rejected: %v", err)
+	}
+	forged := produce(t, engine, chain, block3.ToEthHeader(), keys[0], func(h *hexcore.HexHeader) {
+		h.Extra = GenesisExtra([]common.Address{a, d})
+	})
+	if err := engine.validateSeal(chain, forged); !errors.Is(err, ErrMismatchingCheckpointValidators) {
+		t.Errorf("forged checkpoint: got %v, want %v", err, ErrMismatchingCheckpointValidators)
+	}
+
+	// Checkpoint snapshots are persisted
+	if _, err := engine.Validators(chain, block4.Hash()); err != nil {
+		t.Fatalf("failed to retrieve checkpoint validators: %v", err)
+	}
+	if _, err := loadSnapshot(engine.config, db, block4.Hash()); err != nil {
+		t.Errorf("checkpoint snapshot not stored: %v", err)
+	}
+}
+
+// Snapshots follow the hex parent hashes of blocks stored in a HexChain
+func TestSnapshotHexChain(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	validator := crypto.PubkeyToAddress(key.PublicKey)
+
+	db := rawdb.NewMemoryDatabase()
+	genesis := &hexcore.HexHeader{
+		Number:     common.Big0,
+		Difficulty: common.Big1,
+		Extra:      GenesisExtra([]common.Address{validator}),
+		Root:       types.EmptyRootHash,
+	}
+	chain, err := hexcore.NewHexChain(db, params.TestChainConfig, hexcore.NewHexBlock(genesis, nil, nil), nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	engine := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver, Epoch: 2})
+	engine.SetDatabase(db)
+
+	blocks := []*hexcore.HexHeader{chain.Genesis()}
+	for i := 1; i <= 3; i++ {
+		parent := blocks[len(blocks)-1]
+		header := sealChild(t, engine, chain, parent.ToEthHeader(), key, func(h *hexcore.HexHeader) {
+			h.Root = types.EmptyRootHash
+		})
+		if header.PrimaryParent() != parent.Hash() {
+			t.Fatalf("block %d: parent %x is not the hex hash %x", i, header.PrimaryParent(), parent.Hash())
+		}
+		if err := engine.validateSeal(chain, header); err != nil {
+			t.Fatalf("block %d rejected: %v", i, err)
+		}
+		statedb, err := chain.GetState(parent.Hash())
+		if err != nil {
+			t.Fatalf("failed to open parent state: %v", err)
+		}
+		if err := chain.WriteBlock(hexcore.NewHexBlock(header, nil, nil), nil, statedb); err != nil {
+			t.Fatalf("failed to write block %d: %v", i, err)
+		}
+		blocks = append(blocks, header)
+	}
+	for i, block := range blocks {
+		validators, err := engine.Validators(chain, block.Hash())
+		if err != nil {
+			t.Fatalf("block %d: failed to retrieve validators: %v", i, err)
+		}
+		if len(validators) != 1 || validators[0] != validator {
+			t.Errorf("block %d: validators %x, want %x", i, validators, validator)
+		}
+	}
+	// The checkpoint is stored under its hex hash and a fresh engine starts
+	// from it
+	snap, err := loadSnapshot(engine.config, db, blocks[2].Hash())
+	if err != nil {
+		t.Fatalf("checkpoint snapshot not stored: %v", err)
+	}
+	if snap.Number != 2 || snap.Hash != blocks[2].Hash() {
+		t.Errorf("checkpoint snapshot: got %d %x, want 2 %x", snap.Number, snap.Hash, blocks[2].Hash())
+	}
+	fresh := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver, Epoch: 2})
+	fresh.SetDatabase(db)
+	snap, err = fresh.snapshot(chain, blocks[3].Hash())
+	if err != nil {
+		t.Fatalf("fresh engine: failed to retrieve snapshot: %v", err)
+	}
+	if snap.Hash != blocks[3].Hash() {
+		t.Errorf("fresh snapshot hash: got %x, want %x", snap.Hash, blocks[3].Hash())
+	}
+}
+
+func TestSeal(t *testing.T) {
+	var (
+		key, _      = crypto.GenerateKey()
+		outsider, _ = crypto.GenerateKey()
+		validator   = crypto.PubkeyToAddress(key.PublicKey)
+	)
+	engine := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver, BlockTime: 10 * time.Millisecond})
+
+	genesis := (&hexcore.HexHeader{
+		Number:     common.Big0,
+		Difficulty: common.Big1,
+		Extra:      GenesisExtra([]common.Address{validator}),
+	}).ToEthHeader()
+	chain := newTestChainReader(genesis)
+
+	header := (&hexcore.HexHeader{
+		ParentHashes:  [6]common.Hash{hexHash(genesis)},
+		NeighborCount: 1,
+		Number:        common.Big1,
+		Difficulty:    common.Big1,
+		HexProof:      hexcore.HexaProof{Producer: validator},
+	}).ToEthHeader()
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("failed to prepare block: %v", err)
+	}
+	block := types.NewBlockWithHeader(header)
+
+	if err := engine.Seal(chain, block, make(chan *types.Block, 1), nil); !errors.Is(err, ErrMissingSigner) {
+		t.Fatalf("seal without signer: got %v, want %v", err, ErrMissingSigner)
+	}
+	engine.Authorize(NewKeySigner(outsider))
+	if err := engine.Seal(chain, block, make(chan *types.Block, 1), nil); !errors.Is(err, ErrUnauthorizedValidator) {
+		t.Fatalf("seal by outsider: got %v, want %v", err, ErrUnauthorizedValidator)
+	}
+
+	// Validators only seal blocks declaring them as producer
+	engine.Authorize(NewKeySigner(key))
+	declared, _ := hexcore.HexHeaderFromEth(header)
+	declared.HexProof.Producer = crypto.PubkeyToAddress(outsider.PublicKey)
+	if err := engine.Seal(chain, types.NewBlockWithHeader(declared.ToEthHeader()), make(chan *types.Block, 1), nil); !errors.Is(err, ErrMismatchingProducer) {
+		t.Fatalf("seal of foreign block: got %v, want %v", err, ErrMismatchingProducer)
+	}
+
+	// An authorized validator seals a block its author can be recovered from
+	results := make(chan *types.Block, 1)
+	if err := engine.Seal(chain, block, results, make(chan struct{})); err != nil {
+		t.Fatalf("failed to seal block: %v", err)
+	}
+	select {
+	case sealed := <-results:
+		if author, err := engine.Author(sealed.Header()); err != nil || author != validator {
+			t.Errorf("author: got %x (%v), want %x", author, err, validator)
+		}
+		hexHeader, err := hexcore.HexHeaderFromEth(sealed.Header())
+		if err != nil {
+			t.Fatalf("failed to decode sealed header: %v", err)
+		}
+		if err := engine.validateSeal(chain, hexHeader); err != nil {
+			t.Errorf("sealed block rejected: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sealing timed out")
+	}
+
+	// Sealing waits for the slot and honors stop
+	hexHeader, _ := hexcore.HexHeaderFromEth(header)
+	hexHeader.Time = uint64(time.Now().Add(time.Hour).Unix())
+	stop := make(chan struct{})
+	if err := engine.Seal(chain, types.NewBlockWithHeader(hexHeader.ToEthHeader()), results, stop); err != nil {
+		t.Fatalf("failed to seal future block: %v", err)
+	}
+	close(stop)
+	select {
+	case <-results:
+		t.Error("future block sealed before its slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// sealHashTestHeader returns a fully populated header for the seal hash vectors
+func sealHashTestHeader() *hexcore.HexHeader {
+	baseFee := big.NewInt(params.InitialBaseFee)
+	header := &hexcore.HexHeader{
+		NeighborCount: 3,
+		HexPosition:   hexcore.NewHexCoordinate(-2, 5),
+		MeshRoot:      common.HexToHash("0x4d657368526f6f74"),
+		HexProof: hexcore.HexaProof{
+			StateProof:   []byte{0x01, 0x02},
+			MeshProof:    []byte{0x03},
+			Timestamp:    1700000000,
+			ValidatorSet: []common.Address{common.HexToAddress("0x1111"), common.HexToAddress("0x2222")},
+		},
+		Coinbase:    common.HexToAddress("0xc0ffee"),
+		Root:        common.HexToHash("0x01"),
+		TxHash:      types.EmptyTxsHash,
+		ReceiptHash: types.EmptyReceiptsHash,
+		Difficulty:  big.NewInt(7),
+		Number:      big.NewInt(42),
+		GasLimit:    30000000,
+		GasUsed:     21000,
+		Time:        1700000000,
+		Extra:       append(bytes.Repeat([]byte{0xaa}, hexcore.ExtraVanity), make([]byte, hexcore.ExtraSeal)...),
+		BaseFee:     baseFee,
+	}
+	header.ParentHashes[hexcore.HexEast] = common.HexToHash("0xe1")
+	header.ParentHashes[hexcore.HexWest] = common.HexToHash("0xe2")
+	header.ParentHashes[hexcore.HexSouthEast] = common.HexToHash("0xe3")
+	return header
+}
+
+func TestSealHash(t *testing.T) {
+	engine := newTestEngine(t, nil)
+
+	vectors := []struct {
+		name   string
+		header *hexcore.HexHeader
+		want   common.Hash
+	}{
+		{"genesis", &hexcore.HexHeader{
+			Number:     common.Big0,
+			Difficulty: common.Big1,
+			Extra:      GenesisExtra([]common.Address{common.HexToAddress("0x1111")}),
+		}, common.HexToHash("0x8251dec5a8670eab43063b72f0e07192a8186b06cba0bbef8252724a5bb59455")},
+		{"full", sealHashTestHeader(), common.HexToHash("0x159634cc3d67e817ccc5afddc9022e803c1c4bf3aa106c8e61cbbccfff0a2254")},
+	}
+	for _, tt := range vectors {
+		if got := engine.SealHash(tt.header.ToEthHeader()); got != tt.want {
+			t.Errorf("%s: seal hash: got %x, want %x", tt.name, got, tt.want)
+		}
+		if got := hexcore.SealHash(tt.header); got != tt.want {
+			t.Errorf("%s: core seal hash: got %x, want %x", tt.name, got, tt.want)
+		}
+	}
+	want := vectors[1].want
+
+	// The seal and the neighbor signatures are not covered
+	excluded := map[string]func(*hexcore.HexHeader){
+		"seal": func(h *hexcore.HexHeader) {
+			copy(h.Extra[len(h.Extra)-hexcore.ExtraSeal:], bytes.Repeat([]byte{0xff}, hexcore.ExtraSeal))
+		},
+		"neighbor signatures": func(h *hexcore.HexHeader) {
+			h.HexProof.NeighborSignatures[hexcore.HexEast] = bytes.Repeat([]byte{0x01}, 65)
+		},
+	}
+	for name, modify := range excluded {
+		header := sealHashTestHeader()
+		modify(header)
+		if got := engine.SealHash(header.ToEthHeader()); got != want {
+			t.Errorf("%s: seal hash changed to %x", name, got)
+		}
+	}
+
+	// Every other hexagonal field is covered
+	covered := map[string]func(*hexcore.HexHeader){
+		"secondary parent": func(h *hexcore.HexHeader) { h.ParentHashes[hexcore.HexWest] = common.HexToHash("0xe4") },
+		"last parent":      func(h *hexcore.HexHeader) { h.ParentHashes[hexcore.HexSouthEast] = common.HexToHash("0xe5") },
+		"hex position":     func(h *hexcore.HexHeader) { h.HexPosition = hexcore.NewHexCoordinate(-2, 6) },
+		"mesh root":        func(h *hexcore.HexHeader) { h.MeshRoot = common.HexToHash("0x01") },
+		"state proof":      func(h *hexcore.HexHeader) { h.HexProof.StateProof = nil },
+		"proof timestamp":  func(h *hexcore.HexHeader) { h.HexProof.Timestamp++ },
+		"validator set":    func(h *hexcore.HexHeader) { h.HexProof.ValidatorSet = h.HexProof.ValidatorSet[:1] },
+		"vanity":           func(h *hexcore.HexHeader) { h.Extra[0] = 0xbb },
+	}
+	for name, modify := range covered {
+		header := sealHashTestHeader()
+		modify(header)
+		if got := engine.SealHash(header.ToEthHeader()); got == want {
+			t.Errorf("%s: seal hash unchanged", name)
+		}
+	}
+}
+
+func TestRewardScheduleValidate(t *testing.T) {
+	huge := new(big.Int).Lsh(common.Big1, 256)
+	tests := []struct {
+		name     string
+		schedule RewardSchedule
+		valid    bool
+	}{
+		{"empty", RewardSchedule{BurnBaseFee: true}, true},
+		{"max block reward", RewardSchedule{BlockReward: new(big.Int).Sub(huge, common.Big1), BurnBaseFee: true}, true},
+		{"negative block reward", RewardSchedule{BlockReward: big.NewInt(-1), BurnBaseFee: true}, false},
+		{"oversized block reward", RewardSchedule{BlockReward: huge, BurnBaseFee: true}, false},
+		{"negative neighbor reward", RewardSchedule{NeighborReward: big.NewInt(-1), DirectionWeights: [6]uint64{1}}, false},
+		{"oversized neighbor reward", RewardSchedule{NeighborReward: huge, DirectionWeights: [6]uint64{1}}, false},
+		{"pool without weights", RewardSchedule{NeighborReward: common.Big1}, false},
+	}
+	for _, tt := range tests {
+		err := tt.schedule.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: rejected: %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidRewardSchedule) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrInvalidRewardSchedule)
+		}
+	}
+}
+
+func TestFinalizeRewards(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	addrs := make([]common.Address, 3)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	spec := &Genesis{
+		Validators: addrs,
+		Rewards: &RewardSchedule{
+			BlockReward:      big.NewInt(10),
+			NeighborReward:   big.NewInt(60),
+			DirectionWeights: [6]uint64{1, 1, 1, 2, 1, 0},
+		},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("invalid genesis: %v", err)
+	}
+	config := &HexaProofConfig{ConflictResolver: WeightedResolver}
+	spec.Configure(config)
+	engine := newTestEngine(t, config)
+
+	genesis := spec.ToHeader().ToEthHeader()
+	chain := newTestChainReader(genesis)
+
+	east := produce(t, engine, chain, genesis, keys[0], nil).ToEthHeader()
+	west := produce(t, engine, chain, genesis, keys[1], nil).ToEthHeader()
+	child := func(eastKey, westKey *ecdsa.PrivateKey) *hexcore.HexHeader {
+		return produce(t, engine, chain, east, keys[2], func(h *hexcore.HexHeader) {
+			h.ParentHashes[hexcore.HexWest] = hexHash(west)
+			h.NeighborCount = 2
+			h.BaseFee = big.NewInt(7)
+			h.GasUsed = 3
+			h.HexProof.NeighborSignatures[hexcore.HexEast] = signNeighbor(t, h, hexcore.HexEast, eastKey)
+			h.HexProof.NeighborSignatures[hexcore.HexWest] = signNeighbor(t, h, hexcore.HexWest, westKey)
+		})
+	}
+	block := child(keys[0], keys[1])
+
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabaseForTesting())
+	engine.Finalize(chain, block.ToEthHeader(), statedb, &types.Body{})
+
+	// The neighbor pool of 60 + 7*3 is split 1:2 out of 6 between East and West
+	want := map[common.Address]uint64{addrs[2]: 10, addrs[0]: 13, addrs[1]: 27}
+	for addr, balance := range want {
+		if got := statedb.GetBalance(addr).Uint64(); got != balance {
+			t.Errorf("balance of %x: got %d, want %d", addr, got, balance)
+		}
+	}
+
+	// Burning the base fee leaves only the neighbor reward to share
+	config.Rewards.BurnBaseFee = true
+	statedb, _ = state.New(types.EmptyRootHash, state.NewDatabaseForTesting())
+	engine.Finalize(chain, block.ToEthHeader(), statedb, &types.Body{})
+	if got := statedb.GetBalance(addrs[1]).Uint64(); got != 20 {
+		t.Errorf("west balance with burned base fee: got %d, want 20", got)
+	}
+
+	// Endorsements not signed by the parent producer earn nothing
+	forged := child(keys[0], keys[0])
+	statedb, _ = state.New(types.EmptyRootHash, state.NewDatabaseForTesting())
+	engine.Finalize(chain, forged.ToEthHeader(), statedb, &types.Body{})
+	want = map[common.Address]uint64{addrs[2]: 10, addrs[0]: 10, addrs[1]: 0}
+	for addr, balance := range want {
+		if got := statedb.GetBalance(addr).Uint64(); got != balance {
+			t.Errorf("forged endorsement, balance of %x: got %d, want %d", addr, got, balance)
+		}
+	}
+}
+
+// A block produced and rewarded by one node passes full verification on another
+func TestProduceAndVerify(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	validator := crypto.PubkeyToAddress(key.PublicKey)
+
+	spec := &Genesis{
+		Validators: []common.Address{validator},
+		GasLimit:   params.GenesisGasLimit,
+		Rewards: &RewardSchedule{
+			BlockReward:      big.NewInt(10),
+			NeighborReward:   big.NewInt(6),
+			DirectionWeights: [6]uint64{1, 1, 1, 1, 1, 1},
+			BurnBaseFee:      true,
+		},
+	}
+	genesis := spec.ToHeader()
+	genesis.Coinbase = validator
+	chain, err := hexcore.NewHexChain(rawdb.NewMemoryDatabase(), params.TestChainConfig, hexcore.NewHexBlock(genesis, nil, nil), nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	newEngine := func() *HexaProof {
+		config := &HexaProofConfig{ConflictResolver: WeightedResolver, MaxNeighbors: 6}
+		spec.Configure(config)
+		return newTestEngine(t, config)
+	}
+	producer, verifier := newEngine(), newEngine()
+	producer.Authorize(NewKeySigner(key))
+
+	// Produce a block on genesis: prepare, endorse, finalize and seal
+	statedb, err := chain.GetState(genesis.Hash())
+	if err != nil {
+		t.Fatalf("failed to open parent state: %v", err)
+	}
+	header := (&hexcore.HexHeader{
+		ParentHashes:  [6]common.Hash{genesis.Hash()},
+		NeighborCount: 1,
+		HexPosition:   genesis.HexPosition.Neighbors()[hexcore.HexWest],
+		TxHash:        types.EmptyTxsHash,
+		ReceiptHash:   types.EmptyReceiptsHash,
+		Number:        common.Big1,
+		GasLimit:      genesis.GasLimit,
+		BaseFee:       big.NewInt(params.InitialBaseFee),
+	}).ToEthHeader()
+	if err := producer.Prepare(chain, header); err != nil {
+		t.Fatalf("failed to prepare block: %v", err)
+	}
+	hexHeader, err := hexcore.HexHeaderFromEth(header)
+	if err != nil {
+		t.Fatalf("failed to decode prepared header: %v", err)
+	}
+	hexHeader.HexProof.Timestamp = hexHeader.Time
+	hexHeader.HexProof.NeighborSignatures[hexcore.HexEast] = signNeighbor(t, hexHeader, hexcore.HexEast, key)
+	header = hexHeader.ToEthHeader()
+
+	block, err := producer.FinalizeAndAssemble(chain, header, statedb, &types.Body{}, nil)
+	if err != nil {
+		t.Fatalf("failed to assemble block: %v", err)
+	}
+	// The block reward and the East share of the neighbor pool
+	if got := statedb.GetBalance(validator).Uint64(); got != 11 {
+		t.Errorf("producer balance: got %d, want 11", got)
+	}
+	results := make(chan *types.Block, 1)
+	if err := producer.Seal(chain, block, results, make(chan struct{})); err != nil {
+		t.Fatalf("failed to seal block: %v", err)
+	}
+	var sealed *types.Block
+	select {
+	case sealed = <-results:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sealing timed out")
+	}
+
+	// Another node verifies the block and arrives at the same state
+	hexHeader, err = hexcore.HexHeaderFromEth(sealed.Header())
+	if err != nil {
+		t.Fatalf("failed to decode sealed header: %v", err)
+	}
+	if err := hexcore.NewHexBlockValidator(params.TestChainConfig, chain, verifier).ValidateHexBlock(hexcore.NewHexBlock(hexHeader, nil, nil)); err != nil {
+		t.Fatalf("produced block rejected: %v", err)
+	}
+}
+
+// signNeighbor endorses a header from the parent slot in the given direction
+func signNeighbor(t *testing.T, header *hexcore.HexHeader, dir hexcore.HexDirection, key *ecdsa.PrivateKey) []byte {
+	sig, err := crypto.Sign(hexcore.NeighborSigningHash(header, dir).Bytes(), key)
+	if err != nil {
+		t.Fatalf("failed to sign %v slot: %v", dir, err)
+	}
+	return sig
+}
+
+func TestFinalityTracker(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	addrs := make([]common.Address, 4)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	db := rawdb.NewMemoryDatabase()
+	engine := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver, MinNeighbors: 3, FinalizationTime: time.Hour})
+	tracker := NewFinalityTracker(engine, db)
+	engine.SetFinalityTracker(tracker)
+
+	genesis := (&hexcore.HexHeader{
+		Number:     common.Big0,
+		Difficulty: common.Big1,
+		Extra:      GenesisExtra(addrs),
+	}).ToEthHeader()
+	chain := newTestChainReader(genesis)
+
+	// Every block endorses its past cone, three of four validators finalize
+	var blocks []*types.Header
+	parent := genesis
+	for i, key := range keys {
+		block := produce(t, engine, chain, parent, key, nil).ToEthHeader()
+		if _, err := tracker.Add(chain, block); err != nil {
+			t.Fatalf("failed to track block %d: %v", i+1, err)
+		}
+		blocks, parent = append(blocks, block), block
+	}
+	for hash, want := range map[common.Hash]bool{
+		hexHash(genesis):   true,
+		hexHash(blocks[0]): true,
+		hexHash(blocks[1]): false,
+		hexHash(blocks[3]): false,
+	} {
+		if got := tracker.IsFinal(hash); got != want {
+			t.Errorf("finality of %x: got %v, want %v", hash, got, want)
+		}
+	}
+	if tips := tracker.FinalizedTips(); len(tips) != 1 || tips[0] != hexHash(blocks[0]) {
+		t.Errorf("finalized tips: got %x, want %x", tips, hexHash(blocks[0]))
+	}
+
+	// Blocks may not build on a sibling of the finalized frontier
+	sibling := produce(t, engine, chain, genesis, keys[1], nil).ToEthHeader()
+	if err := tracker.CheckHeader(chain, produce(t, engine, chain, sibling, keys[2], nil)); !errors.Is(err, ErrFinalityConflict) {
+		t.Errorf("conflicting header: got %v, want %v", err, ErrFinalityConflict)
+	}
+	if err := tracker.CheckHeader(chain, produce(t, engine, chain, blocks[3], keys[0], nil)); err != nil {
+		t.Errorf("header extending the frontier rejected: %v", err)
+	}
+
+	// The finalized frontier survives a restart
+	restored := NewFinalityTracker(engine, db)
+	if tips := restored.FinalizedTips(); len(tips) != 1 || tips[0] != hexHash(blocks[0]) {
+		t.Errorf("restored finalized tips: got %x, want %x", tips, hexHash(blocks[0]))
+	}
+	if !restored.IsFinal(hexHash(genesis)) {
+		t.Errorf("genesis not final after restart")
+	}
+}
+
+func TestFinalityHexChain(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	addrs := make([]common.Address, 4)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	db := rawdb.NewMemoryDatabase()
+	engine := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver, MinNeighbors: 3, FinalizationTime: time.Hour})
+	engine.SetDatabase(db)
+
+	genesis := &hexcore.HexHeader{
+		Number:     common.Big0,
+		Difficulty: common.Big1,
+		Extra:      GenesisExtra(addrs),
+		Root:       types.EmptyRootHash,
+	}
+	chain, err := hexcore.NewHexChain(db, params.TestChainConfig, hexcore.NewHexBlock(genesis, nil, nil), engine)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	// Storing the blocks through the chain feeds the finality tracker
+	blocks := []*hexcore.HexHeader{chain.Genesis()}
+	for i, key := range keys {
+		parent := blocks[len(blocks)-1]
+		header := sealChild(t, engine, chain, parent.ToEthHeader(), key, func(h *hexcore.HexHeader) {
+			h.Root = types.EmptyRootHash
+		})
+		statedb, err := chain.GetState(parent.Hash())
+		if err != nil {
+			t.Fatalf("failed to open parent state: %v", err)
+		}
+		if err := chain.WriteBlock(hexcore.NewHexBlock(header, nil, nil), nil, statedb); err != nil {
+			t.Fatalf("failed to write block %d: %v", i+1, err)
+		}
+		blocks = append(blocks, header)
+	}
+	tracker := engine.FinalityTracker()
+	for i, want := range []bool{true, true, false, false, false} {
+		if got := tracker.IsFinal(blocks[i].Hash()); got != want {
+			t.Errorf("finality of block %d: got %v, want %v", i, got, want)
+		}
+	}
+	if tips := tracker.FinalizedTips(); len(tips) != 1 || tips[0] != blocks[1].Hash() {
+		t.Errorf("finalized tips: got %x, want %x", tips, blocks[1].Hash())
+	}
+	// A restarted engine restores the frontier from the chain database
+	restarted := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver, MinNeighbors: 3, FinalizationTime: time.Hour})
+	restarted.SetDatabase(db)
+	if !restarted.FinalityTracker().IsFinal(blocks[1].Hash()) {
+		t.Errorf("finalized block not restored")
+	}
+}
+
+func TestStrictPlacement(t *testing.T) {
+	engine := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver, StrictPlacement: true})
+	genesis := (&hexcore.HexHeader{
+		Number:     common.Big0,
+		Difficulty: common.Big1,
+		Extra:      GenesisExtra(nil),
+	}).ToEthHeader()
+	chain := newTestChainReader(genesis)
+
+	// Genesis sits west of (1,0), so it belongs in the West slot
+	header := &hexcore.HexHeader{HexPosition: hexcore.NewHexCoordinate(1, 0), NeighborCount: 1, Number: common.Big1}
+	header.ParentHashes[hexcore.HexWest] = hexHash(genesis)
+	if err := engine.validateMeshTopology(chain, header); err != nil {
+		t.Errorf("placed parent rejected: %v", err)
+	}
+	header.ParentHashes[hexcore.HexWest], header.ParentHashes[hexcore.HexEast] = common.Hash{}, hexHash(genesis)
+	if err := engine.validateMeshTopology(chain, header); !errors.Is(err, hexcore.ErrMisplacedParent) {
+		t.Errorf("swapped parent: got %v, want %v", err, hexcore.ErrMisplacedParent)
+	}
+}
+
+func TestTimingLimits(t *testing.T) {
+	engine := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver, MaxParentDepth: 4, MaxFutureDrift: 10 * time.Second})
+	engine.SetClock(func() time.Time { return time.Unix(1000, 0) })
+
+	parent := &types.Header{Number: big.NewInt(6), Time: 900}
+	chain := newTestChainReader(parent)
+
+	tests := []struct {
+		number, time uint64
+		ok           bool
+	}{
+		{10, 1010, true},  // At the depth and drift limits
+		{11, 1000, false}, // Parent too deep
+		{10, 1011, false}, // Too far ahead of the clock
+		{10, 900, false},  // Not after the parent
+	}
+	for i, tt := range tests {
+		header := &hexcore.HexHeader{Number: new(big.Int).SetUint64(tt.number), Time: tt.time, NeighborCount: 1}
+		header.ParentHashes[0] = parent.Hash()
+
+		err := engine.validateParents(chain, header)
+		if err == nil {
+			err = engine.validateTimestamp(chain, header)
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("test %d: number %d at %d: got %v, want ok %v", i, tt.number, tt.time, err, tt.ok)
+		}
+	}
+}
+
+func TestVerifyHeaders(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	addrs := make([]common.Address, 3)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	engine := newTestEngine(t, &HexaProofConfig{ConflictResolver: WeightedResolver, MaxNeighbors: 6})
+
+	genesis := (&hexcore.HexHeader{
+		Coinbase:   addrs[0],
+		Number:     common.Big0,
+		Difficulty: common.Big1,
+		Time:       uint64(time.Now().Unix()) - 100,
+		Extra:      GenesisExtra(addrs),
+	}).ToEthHeader()
+	chain := newTestChainReader(genesis)
+
+	// endorse references the extra parents, reweighs the block and signs every
+	// slot with the keys of the parent producers
+	endorse := func(extra []*types.Header, parentKeys ...*ecdsa.PrivateKey) func(*hexcore.HexHeader) {
+		return func(h *hexcore.HexHeader) {
+			for i, parent := range extra {
+				h.ParentHashes[i+1] = hexHash(parent)
+				h.NeighborCount++
+			}
+			h.Difficulty = engine.meshWeight(chain, h)
+			h.HexProof.Timestamp = h.Time
+			for i, key := range parentKeys {
+				sig, _ := crypto.Sign(hexcore.NeighborSigningHash(h, hexcore.HexDirection(i)).Bytes(), key)
+				h.HexProof.NeighborSignatures[i] = sig
+			}
+		}
+	}
+	b1 := produce(t, engine, chain, genesis, keys[1], endorse(nil, keys[0])).ToEthHeader()
+	b2 := produce(t, engine, chain, b1, keys[2], endorse(nil, keys[1])).ToEthHeader()
+	side := produce(t, engine, chain, genesis, keys[2], endorse(nil, keys[0])).ToEthHeader()
+	merge := produce(t, engine, chain, b2, keys[0], endorse([]*types.Header{side}, keys[2], keys[2])).ToEthHeader()
+	unsigned := produce(t, engine, chain, genesis, keys[1], endorse(nil)).ToEthHeader()
+	orphan := produce(t, engine, chain, unsigned, keys[2], endorse(nil, keys[1])).ToEthHeader()
+
+	verify := func(headers ...*types.Header) []error {
+		fresh := newTestChainReader(genesis)
+		_, results := engine.VerifyHeaders(fresh, headers)
+
+		var errs []error
+		for err := range results {
+			errs = append(errs, err)
+		}
+		if len(errs) != len(headers) {
+			t.Fatalf("results: got %d, want %d", len(errs), len(headers))
+		}
+		return errs
+	}
+	// Parents within the batch are resolved and results keep batch order
+	for i, err := range verify(b1, side, b2, merge) {
+		if err != nil {
+			t.Errorf("header %d rejected: %v", i, err)
+		}
+	}
+	// Failures propagate to descendants only
+	errs := verify(unsigned, b1, orphan)
+	if !errors.Is(errs[0], hexcore.ErrInvalidProof) || errs[1] != nil || !errors.Is(errs[2], consensus.ErrUnknownAncestor) {
+		t.Errorf("failing parent: got %v", errs)
+	}
+	// Parents must precede their children
+	if errs := verify(b2, b1); !errors.Is(errs[0], consensus.ErrUnknownAncestor) || errs[1] != nil {
+		t.Errorf("reversed batch: got %v", errs)
+	}
+
+	// Difficulties carry the mesh weight: 1 + 2 neighbors + 1 recent, the
+	// parents share a cell and close no triangle
+	if merge.Difficulty.Uint64() != 4 {
+		t.Errorf("merge difficulty: got %v, want 4", merge.Difficulty)
+	}
+	if diff := engine.CalcDifficulty(chain, b2.Time+1, b2); diff.Uint64() != 3 {
+		t.Errorf("single parent difficulty: got %v, want 3", diff)
+	}
+	heavy := types.CopyHeader(merge)
+	heavy.Difficulty = big.NewInt(5)
+	if errs := verify(b1, side, b2, heavy); !errors.Is(errs[3], ErrInvalidDifficulty) {
+		t.Errorf("overweight header: got %v, want %v", errs[3], ErrInvalidDifficulty)
+	}
+
+	// Aborting stops the delivery of results
+	abort, results := engine.VerifyHeaders(chain, []*types.Header{b1, b2, side, merge})
+	close(abort)
+	for range results {
+	}
+}
@@ -0,0 +1,191 @@
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+// Built-in conflict resolver names, as used by HexaProofConfig.ConflictResolver
+const (
+	WeightedResolver   = "weighted"    // Highest producer stake wins
+	LowestHashResolver = "lowest-hash" // Lowest block hash wins
+	EarliestResolver   = "earliest"    // Earliest block timestamp wins
+	DirectionResolver  = "direction"   // Lowest parent slot (HexDirection) wins
+)
+
+var (
+	ErrNoCandidates    = errors.New("no conflict candidates")
+	ErrUnknownResolver = errors.New("unknown conflict resolver")
+)
+
+// ConflictResolver deterministically picks the winner among competing blocks.
+// The same resolver settles conflicting parent state writes during merging
+// and ties during fork choice, so every node reaches the same outcome.
+type ConflictResolver interface {
+	hexcore.StateConflictResolver
+
+	// Name returns the registry name of the resolver
+	Name() string
+}
+
+// StakeReader provides the stake backing a validator
+type StakeReader interface {
+	StakeOf(validator common.Address) *big.Int
+}
+
+// ConflictResolverFactory creates a conflict resolver. Resolvers that do not
+// weigh validators ignore the stake reader.
+type ConflictResolverFactory func(stakes StakeReader) ConflictResolver
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = map[string]ConflictResolverFactory{
+		WeightedResolver: func(stakes StakeReader) ConflictResolver {
+			return &weightedResolver{stakes: stakes}
+		},
+		LowestHashResolver: func(StakeReader) ConflictResolver {
+			return lowestHashResolver{}
+		},
+		EarliestResolver: func(StakeReader) ConflictResolver {
+			return earliestResolver{}
+		},
+		DirectionResolver: func(StakeReader) ConflictResolver {
+			return directionResolver{}
+		},
+	}
+)
+
+// RegisterConflictResolver makes a conflict resolver available by name,
+// replacing any resolver previously registered under the same name
+func RegisterConflictResolver(name string, factory ConflictResolverFactory) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+
+	resolvers[name] = factory
+}
+
+// NewConflictResolver creates the conflict resolver registered under name
+func NewConflictResolver(name string, stakes StakeReader) (ConflictResolver, error) {
+	resolversMu.RLock()
+	factory, ok := resolvers[name]
+	resolversMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownResolver, name)
+	}
+	return factory(stakes), nil
+}
+
+// HasConflictResolver reports whether a conflict resolver is registered under name
+func HasConflictResolver(name string) bool {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+
+	_, ok := resolvers[name]
+	return ok
+}
+
+// ConflictResolvers returns the sorted names of all registered resolvers
+func ConflictResolvers() []string {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+
+	names := make([]string, 0, len(resolvers))
+	for name := range resolvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pickBest returns the index of the best candidate according to better, which
+// returns a positive value if a ranks above b, a negative value if it ranks
+// below and zero on a tie. Remaining ties go to the lowest block hash.
+func pickBest(candidates []hexcore.ConflictCandidate, better func(a, b hexcore.ConflictCandidate) int) (int, error) {
+	if len(candidates) == 0 {
+		return 0, ErrNoCandidates
+	}
+	winner := 0
+	for i := 1; i < len(candidates); i++ {
+		cmp := better(candidates[i], candidates[winner])
+		if cmp > 0 || (cmp == 0 && bytes.Compare(candidates[i].Hash[:], candidates[winner].Hash[:]) < 0) {
+			winner = i
+		}
+	}
+	return winner, nil
+}
+
+// weightedResolver favors the candidate whose producer has the highest stake
+type weightedResolver struct {
+	stakes StakeReader
+}
+
+func (r *weightedResolver) Name() string { return WeightedResolver }
+
+func (r *weightedResolver) Resolve(candidates []hexcore.ConflictCandidate) (int, error) {
+	return pickBest(candidates, func(a, b hexcore.ConflictCandidate) int {
+		return r.stakeOf(a.Producer).Cmp(r.stakeOf(b.Producer))
+	})
+}
+
+func (r *weightedResolver) stakeOf(validator common.Address) *big.Int {
+	if r.stakes == nil {
+		return common.Big1
+	}
+	if stake := r.stakes.StakeOf(validator); stake != nil {
+		return stake
+	}
+	return common.Big0
+}
+
+// lowestHashResolver favors the candidate with the lowest block hash
+type lowestHashResolver struct{}
+
+func (lowestHashResolver) Name() string { return LowestHashResolver }
+
+func (lowestHashResolver) Resolve(candidates []hexcore.ConflictCandidate) (int, error) {
+	return pickBest(candidates, func(a, b hexcore.ConflictCandidate) int { return 0 })
+}
+
+// earliestResolver favors the candidate with the earliest block timestamp
+type earliestResolver struct{}
+
+func (earliestResolver) Name() string { return EarliestResolver }
+
+func (earliestResolver) Resolve(candidates []hexcore.ConflictCandidate) (int, error) {
+	return pickBest(candidates, func(a, b hexcore.ConflictCandidate) int {
+		switch {
+		case a.Header.Time < b.Header.Time:
+			return 1
+		case a.Header.Time > b.Header.Time:
+			return -1
+		}
+		return 0
+	})
+}
+
+// directionResolver favors the candidate in the lowest parent slot, following
+// the HexDirection order East, NorthEast, NorthWest, West, SouthWest, SouthEast
+type directionResolver struct{}
+
+func (directionResolver) Name() string { return DirectionResolver }
+
+func (directionResolver) Resolve(candidates []hexcore.ConflictCandidate) (int, error) {
+	return pickBest(candidates, func(a, b hexcore.ConflictCandidate) int {
+		switch {
+		case a.Direction < b.Direction:
+			return 1
+		case a.Direction > b.Direction:
+			return -1
+		}
+		return 0
+	})
+}
@@ -0,0 +1,139 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/holiman/uint256"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+var ErrInvalidRewardSchedule = errors.New("invalid reward schedule")
+
+// RewardSchedule defines the issuance of a HexaProof chain. It is declared in
+// genesis and must be identical on every node.
+//
+// The producer of a block receives BlockReward. The neighbor pool, made of
+// NeighborReward plus the block's base fee unless it is burned, is split over
+// the six parent slots by DirectionWeights; the producer of each parent that
+// endorsed the block with a valid neighbor signature receives its slot's share. Pool
+// shares of empty slots are not issued. Priority fees are paid to the producer
+// during transaction execution.
+type RewardSchedule struct {
+	BlockReward      *big.Int  `json:"blockReward"`      // Base reward of the block producer (wei)
+	NeighborReward   *big.Int  `json:"neighborReward"`   // Reward shared among endorsing parent producers (wei)
+	DirectionWeights [6]uint64 `json:"directionWeights"` // Share of the neighbor pool per HexDirection slot
+	BurnBaseFee      bool      `json:"burnBaseFee"`      // Whether the base fee is burned instead of shared with neighbors
+}
+
+// Validate checks the schedule for consistency
+func (s *RewardSchedule) Validate() error {
+	if s.BlockReward != nil && s.BlockReward.Sign() < 0 {
+		return fmt.Errorf("%w: negative block reward", ErrInvalidRewardSchedule)
+	}
+	if s.BlockReward != nil && s.BlockReward.BitLen() > 256 {
+		return fmt.Errorf("%w: block reward exceeds 256 bits", ErrInvalidRewardSchedule)
+	}
+	if s.NeighborReward != nil && s.NeighborReward.Sign() < 0 {
+		return fmt.Errorf("%w: negative neighbor reward", ErrInvalidRewardSchedule)
+	}
+	if s.NeighborReward != nil && s.NeighborReward.BitLen() > 256 {
+		return fmt.Errorf("%w: neighbor reward exceeds 256 bits", ErrInvalidRewardSchedule)
+	}
+	if s.totalWeight() == 0 && ((s.NeighborReward != nil && s.NeighborReward.Sign() > 0) || !s.BurnBaseFee) {
+		return fmt.Errorf("%w: neighbor pool without direction weights", ErrInvalidRewardSchedule)
+	}
+	return nil
+}
+
+// totalWeight returns the sum of all direction weights
+func (s *RewardSchedule) totalWeight() uint64 {
+	var total uint64
+	for _, weight := range s.DirectionWeights {
+		total += weight
+	}
+	return total
+}
+
+// neighborShare returns the share of the neighbor pool paid for a parent slot
+func (s *RewardSchedule) neighborShare(pool *big.Int, dir hexcore.HexDirection) *big.Int {
+	total := s.totalWeight()
+	if total == 0 {
+		return new(big.Int)
+	}
+	share := new(big.Int).Mul(pool, new(big.Int).SetUint64(s.DirectionWeights[dir]))
+	return share.Div(share, new(big.Int).SetUint64(total))
+}
+
+// accumulateRewards credits the producer and the endorsing parent producers of
+// the block according to the reward schedule
+func (h *HexaProof) accumulateRewards(chain consensus.ChainHeaderReader, header *types.Header, state vm.StateDB) {
+	schedule := h.config.Rewards
+	if schedule == nil {
+		return
+	}
+	hexHeader, err := h.convertToHexHeader(header)
+	if err != nil {
+		log.Error("Failed to decode header for rewards", "number", header.Number, "err", err)
+		return
+	}
+
+	// Reward the declared block producer, the seal is checked against it and
+	// does not exist yet when the producer finalizes its own block
+	if schedule.BlockReward != nil && schedule.BlockReward.Sign() > 0 {
+		state.AddBalance(hexHeader.HexProof.Producer, uint256.MustFromBig(schedule.BlockReward), tracing.BalanceIncreaseRewardMineBlock)
+	}
+
+	// Share the neighbor pool among the producers of the endorsing parents
+	pool := new(big.Int)
+	if schedule.NeighborReward != nil {
+		pool.Set(schedule.NeighborReward)
+	}
+	if !schedule.BurnBaseFee && header.BaseFee != nil {
+		pool.Add(pool, new(big.Int).Mul(header.BaseFee, new(big.Int).SetUint64(header.GasUsed)))
+	}
+	if pool.Sign() == 0 {
+		return
+	}
+	for i, parentHash := range hexHeader.ParentHashes {
+		if parentHash == (common.Hash{}) || len(hexHeader.HexProof.NeighborSignatures[i]) == 0 {
+			continue
+		}
+		share := schedule.neighborShare(pool, hexcore.HexDirection(i))
+		if share.Sign() == 0 {
+			continue
+		}
+		parent := chain.GetHeaderByHash(parentHash)
+		if parent == nil {
+			log.Error("Missing parent for neighbor reward", "number", header.Number, "parent", parentHash)
+			continue
+		}
+		neighbor, err := h.Author(parent)
+		if err != nil {
+			log.Error("Failed to resolve parent producer for rewards", "parent", parentHash, "err", err)
+			continue
+		}
+		// Only the parent producer's own endorsement earns the share
+		signer, err := h.recoverNeighborSigner(hexHeader, hexcore.HexDirection(i))
+		if err != nil || signer != neighbor {
+			log.Debug("Invalid neighbor endorsement, withholding reward", "number", header.Number, "slot", hexcore.HexDirection(i), "signer", signer, "producer", neighbor, "err", err)
+			continue
+		}
+		// Shares include the base fees, which are not bounded by the schedule
+		amount, overflow := uint256.FromBig(share)
+		if overflow {
+			log.Error("Neighbor reward overflows 256 bits", "number", header.Number, "slot", hexcore.HexDirection(i), "share", share)
+			continue
+		}
+		// The mesh replaces uncles, so endorsements are accounted as uncle rewards
+		state.AddBalance(neighbor, amount, tracing.BalanceIncreaseRewardMineUncle)
+	}
+}
@@ -0,0 +1,54 @@
+package consensus
+
+import (
+	"crypto/ecdsa"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Signer seals blocks on behalf of a validator
+type Signer interface {
+	// Address returns the validator address the signer produces signatures for
+	Address() common.Address
+
+	// SignHash returns a 65 byte [R || S || V] secp256k1 signature of the hash
+	SignHash(hash common.Hash) ([]byte, error)
+}
+
+// keySigner signs with an in-memory private key
+type keySigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+// NewKeySigner creates a signer backed by an in-memory private key
+func NewKeySigner(key *ecdsa.PrivateKey) Signer {
+	return &keySigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+func (s *keySigner) Address() common.Address { return s.address }
+
+func (s *keySigner) SignHash(hash common.Hash) ([]byte, error) {
+	return crypto.Sign(hash[:], s.key)
+}
+
+// keystoreSigner signs with an unlocked keystore account
+type keystoreSigner struct {
+	ks      *keystore.KeyStore
+	account accounts.Account
+}
+
+// NewKeystoreSigner creates a signer backed by a keystore account. The account
+// must be unlocked before sealing.
+func NewKeystoreSigner(ks *keystore.KeyStore, account accounts.Account) Signer {
+	return &keystoreSigner{ks: ks, account: account}
+}
+
+func (s *keystoreSigner) Address() common.Address { return s.account.Address }
+
+func (s *keystoreSigner) SignHash(hash common.Hash) ([]byte, error) {
+	return s.ks.SignHash(s.account, hash[:])
+}
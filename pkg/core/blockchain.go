@@ -0,0 +1,461 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/triedb"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	headerCacheLimit = 512 // Number of recent headers kept in memory
+	blockCacheLimit  = 256 // Number of recent blocks kept in memory
+)
+
+var (
+	ErrNoGenesis       = errors.New("genesis not found in chain")
+	ErrGenesisMismatch = errors.New("genesis mismatch")
+	ErrMissingState    = errors.New("missing state")
+)
+
+// FinalityEngine is implemented by consensus engines finalizing blocks, which
+// the chain hands every block it stores
+type FinalityEngine interface {
+	// TrackFinality records the endorsements of a stored header and returns the
+	// blocks that became final as a result
+	TrackFinality(chain consensus.ChainHeaderReader, header *HexHeader) ([]common.Hash, error)
+}
+
+// Ensure HexChain satisfies the interfaces of the validator and the engine
+var (
+	_ HexBlockChain               = (*HexChain)(nil)
+	_ consensus.ChainHeaderReader = (*HexChain)(nil)
+)
+
+// OpenDatabase opens a LevelDB database at the given path for the chain store,
+// or an in-memory database if the path is empty
+func OpenDatabase(file string, cache, handles int, readonly bool) (ethdb.Database, error) {
+	if file == "" {
+		return rawdb.NewMemoryDatabase(), nil
+	}
+	kvdb, err := leveldb.New(file, cache, handles, "hexchain/db/chaindata/", readonly)
+	if err != nil {
+		return nil, err
+	}
+	return rawdb.NewDatabase(kvdb), nil
+}
+
+// HexChain is a HexBlockChain persisted in a key-value database. Blocks are
+// keyed by their hex hash; lookups by hash also accept the hash of the block's
+// Ethereum header, which is how the consensus engine addresses blocks.
+//
+// The head is the head of the fork choice over all stored blocks, and the total
+// weight of a block is its fork choice score. Lookups by number follow the
+// primary parent lineage of the head, which may skip numbers.
+type HexChain struct {
+	config *params.ChainConfig
+	db     ethdb.Database
+	triedb *triedb.Database
+	sdb    state.Database
+
+	genesis    *HexHeader
+	head       *HexHeader
+	forkChoice *ForkChoice      // Head rule over all stored blocks
+	engine     consensus.Engine // Engine settling fork choice ties and tracking finality, may be nil
+
+	headerCache *lru.Cache // Recent headers, by requested hash
+	blockCache  *lru.Cache // Recent blocks, by requested hash
+
+	mu sync.RWMutex // Protects the head and serializes writes
+}
+
+// NewHexChain opens the chain stored in db. An empty database is initialized
+// with the genesis block, whose state must already be committed to db. A
+// stored chain must have been created from the same genesis, if one is given.
+// The engine settles fork choice ties between equally heavy blocks and, if it
+// is a FinalityEngine, tracks the finality of stored blocks. It may be nil.
+func NewHexChain(db ethdb.Database, config *params.ChainConfig, genesis *HexBlock, engine consensus.Engine) (*HexChain, error) {
+	tdb := triedb.NewDatabase(db, &triedb.Config{Preimages: true})
+	headerCache, _ := lru.New(headerCacheLimit)
+	blockCache, _ := lru.New(blockCacheLimit)
+
+	c := &HexChain{
+		config:      config,
+		db:          db,
+		triedb:      tdb,
+		sdb:         state.NewDatabase(tdb, nil),
+		headerCache: headerCache,
+		blockCache:  blockCache,
+		engine:      engine,
+	}
+	stored := readHexCanonicalHash(db, 0)
+	switch {
+	case stored == (common.Hash{}) && genesis == nil:
+		return nil, ErrNoGenesis
+
+	case stored == (common.Hash{}):
+		header := genesis.Header()
+		if header.Number.Sign() != 0 {
+			return nil, fmt.Errorf("genesis block has number %d", header.Number)
+		}
+		if !c.hasState(header.Root) {
+			return nil, fmt.Errorf("%w: genesis root %x", ErrMissingState, header.Root)
+		}
+		batch := db.NewBatch()
+		writeHexHeader(batch, header)
+		writeHexBody(batch, genesis.Hash(), 0, genesis.Body())
+		writeHexWeight(batch, genesis.Hash(), 0, new(big.Int).SetUint64(MeshWeight(header, [6]*HexHeader{})))
+		writeHexCanonicalHash(batch, genesis.Hash(), 0)
+		writeHexHeadHash(batch, genesis.Hash())
+		if err := batch.Write(); err != nil {
+			return nil, err
+		}
+		log.Info("Wrote hex genesis block", "hash", genesis.Hash())
+
+	case genesis != nil && stored != genesis.Hash():
+		return nil, fmt.Errorf("%w: have %x, new %x", ErrGenesisMismatch, stored, genesis.Hash())
+	}
+
+	if c.genesis = c.GetHexHeader(readHexCanonicalHash(db, 0)); c.genesis == nil {
+		return nil, ErrNoGenesis
+	}
+	if c.head = c.GetHexHeader(readHexHeadHash(db)); c.head == nil {
+		return nil, errors.New("head block not found in chain")
+	}
+	c.forkChoice = NewForkChoice(c.genesis, engine, nil)
+	if err := c.loadForkChoice(); err != nil {
+		return nil, err
+	}
+	if head := c.forkChoice.HeadHeader(); head.Hash() != c.head.Hash() {
+		batch := db.NewBatch()
+		c.writeHead(batch, head)
+		if err := batch.Write(); err != nil {
+			return nil, err
+		}
+		c.head = head
+	}
+	log.Info("Loaded hex chain", "head", c.head.Hash(), "number", c.head.Number)
+	return c, nil
+}
+
+// loadForkChoice adds the stored blocks to the fork choice. Headers are keyed
+// by number first, so parents are added before their children.
+func (c *HexChain) loadForkChoice() error {
+	it := c.db.NewIterator(hexHeaderPrefix, nil)
+	defer it.Release()
+
+	for it.Next() {
+		if len(it.Key()) != len(hexHeaderPrefix)+8+common.HashLength {
+			continue
+		}
+		header := new(HexHeader)
+		if err := rlp.DecodeBytes(it.Value(), header); err != nil {
+			return fmt.Errorf("invalid stored hex header: %v", err)
+		}
+		if header.Number.Sign() == 0 {
+			continue
+		}
+		if err := c.forkChoice.Add(header); err != nil {
+			return fmt.Errorf("failed to load block %x into fork choice: %w", header.Hash(), err)
+		}
+	}
+	return it.Error()
+}
+
+// ForkChoice returns the fork choice selecting the head of the chain
+func (c *HexChain) ForkChoice() *ForkChoice {
+	return c.forkChoice
+}
+
+// Config returns the chain configuration
+func (c *HexChain) Config() *params.ChainConfig {
+	return c.config
+}
+
+// StateDatabase returns the state database blocks are executed on
+func (c *HexChain) StateDatabase() state.Database {
+	return c.sdb
+}
+
+// Genesis returns the genesis header
+func (c *HexChain) Genesis() *HexHeader {
+	return c.genesis
+}
+
+// CurrentHexHeader returns the header of the heaviest known block
+func (c *HexChain) CurrentHexHeader() *HexHeader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.head
+}
+
+// CurrentHeader returns the Ethereum header of the heaviest known block
+func (c *HexChain) CurrentHeader() *types.Header {
+	return c.CurrentHexHeader().ToEthHeader()
+}
+
+// GetHexHeader retrieves a header by its hex or Ethereum hash
+func (c *HexChain) GetHexHeader(hash common.Hash) *HexHeader {
+	if header, ok := c.headerCache.Get(hash); ok {
+		return header.(*HexHeader)
+	}
+	hexHash, number, ok := readHexBlockNumber(c.db, hash)
+	if !ok {
+		return nil
+	}
+	header := readHexHeader(c.db, hexHash, number)
+	if header == nil {
+		return nil
+	}
+	c.headerCache.Add(hash, header)
+	return header
+}
+
+// GetHexBlock retrieves a block by its hex or Ethereum hash
+func (c *HexChain) GetHexBlock(hash common.Hash) *HexBlock {
+	if block, ok := c.blockCache.Get(hash); ok {
+		return block.(*HexBlock)
+	}
+	header := c.GetHexHeader(hash)
+	if header == nil {
+		return nil
+	}
+	body := readHexBody(c.db, header.Hash(), header.Number.Uint64())
+	if body == nil {
+		return nil
+	}
+	block := NewHexBlockWithBody(header, body)
+	c.blockCache.Add(hash, block)
+	return block
+}
+
+// HasHexBlock reports whether the header and body of a block are stored
+func (c *HexChain) HasHexBlock(hash common.Hash) bool {
+	if c.blockCache.Contains(hash) {
+		return true
+	}
+	hexHash, number, ok := readHexBlockNumber(c.db, hash)
+	if !ok {
+		return false
+	}
+	has, _ := c.db.Has(hexBodyKey(number, hexHash))
+	return has
+}
+
+// GetHeader retrieves the Ethereum header of a block by hash and number
+func (c *HexChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	header := c.GetHexHeader(hash)
+	if header == nil || header.Number.Uint64() != number {
+		return nil
+	}
+	return header.ToEthHeader()
+}
+
+// GetHeaderByHash retrieves the Ethereum header of a block by hash
+func (c *HexChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	header := c.GetHexHeader(hash)
+	if header == nil {
+		return nil
+	}
+	return header.ToEthHeader()
+}
+
+// GetHeaderByNumber retrieves the Ethereum header at a number on the primary
+// lineage of the head
+func (c *HexChain) GetHeaderByNumber(number uint64) *types.Header {
+	hash := readHexCanonicalHash(c.db, number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return c.GetHeader(hash, number)
+}
+
+// GetBlock retrieves the Ethereum block of a block by hash and number
+func (c *HexChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	block := c.GetHexBlock(hash)
+	if block == nil || block.Number().Uint64() != number {
+		return nil
+	}
+	return block.ToEthBlock()
+}
+
+// GetBlockByHash retrieves the Ethereum block of a block by hash
+func (c *HexChain) GetBlockByHash(hash common.Hash) *types.Block {
+	block := c.GetHexBlock(hash)
+	if block == nil {
+		return nil
+	}
+	return block.ToEthBlock()
+}
+
+// GetReceipts retrieves the receipts of a block. Only the consensus fields of
+// the receipts are stored.
+func (c *HexChain) GetReceipts(hash common.Hash) types.Receipts {
+	header := c.GetHexHeader(hash)
+	if header == nil {
+		return nil
+	}
+	return readHexReceipts(c.db, header.Hash(), header.Number.Uint64())
+}
+
+// GetTotalWeight retrieves the total weight of a block
+func (c *HexChain) GetTotalWeight(hash common.Hash) *big.Int {
+	header := c.GetHexHeader(hash)
+	if header == nil {
+		return nil
+	}
+	return readHexWeight(c.db, header.Hash(), header.Number.Uint64())
+}
+
+// HasBlockAndState reports whether a block and its post-state are stored
+func (c *HexChain) HasBlockAndState(hash common.Hash, number uint64) bool {
+	header := c.GetHexHeader(hash)
+	if header == nil || header.Number.Uint64() != number {
+		return false
+	}
+	return c.HasHexBlock(hash) && c.hasState(header.Root)
+}
+
+// hasState reports whether the state with the given root is available
+func (c *HexChain) hasState(root common.Hash) bool {
+	_, err := c.sdb.OpenTrie(root)
+	return err == nil
+}
+
+// GetState opens the post-state of a block
+func (c *HexChain) GetState(hash common.Hash) (*state.StateDB, error) {
+	header := c.GetHexHeader(hash)
+	if header == nil {
+		return nil, fmt.Errorf("%w: %x", ErrParentNotFound, hash)
+	}
+	return state.New(header.Root, c.sdb)
+}
+
+// GetStateByNumber opens the post-state of the block at a number on the
+// primary lineage of the head
+func (c *HexChain) GetStateByNumber(number uint64) (*state.StateDB, error) {
+	hash := readHexCanonicalHash(c.db, number)
+	if hash == (common.Hash{}) {
+		return nil, fmt.Errorf("no block at number %d", number)
+	}
+	return c.GetState(hash)
+}
+
+// WriteBlock stores a processed block with its receipts and commits its
+// post-state, which must match the root of the header. All parents must be
+// stored before. The block joins the fork choice and the head follows it, and
+// the finality engine records its endorsements.
+func (c *HexChain) WriteBlock(block *HexBlock, receipts types.Receipts, statedb *state.StateDB) error {
+	if err := c.writeBlock(block, receipts, statedb); err != nil {
+		return err
+	}
+	if engine, ok := c.engine.(FinalityEngine); ok {
+		finalized, err := engine.TrackFinality(c, block.Header())
+		if err != nil {
+			log.Warn("Failed to track hex block finality", "hash", block.Hash(), "err", err)
+		} else if len(finalized) > 0 {
+			log.Debug("Hex block finalized blocks", "hash", block.Hash(), "finalized", len(finalized))
+		}
+	}
+	return nil
+}
+
+// writeBlock stores a block and moves the head, see WriteBlock
+func (c *HexChain) writeBlock(block *HexBlock, receipts types.Receipts, statedb *state.StateDB) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		header = block.Header()
+		hash   = block.Hash()
+		number = header.Number.Uint64()
+	)
+	if c.HasHexBlock(hash) {
+		return ErrKnownBlock
+	}
+	for _, parentHash := range header.ParentHashes {
+		if parentHash != (common.Hash{}) && !c.HasHexBlock(parentHash) {
+			return fmt.Errorf("%w: %x", ErrParentNotFound, parentHash)
+		}
+	}
+
+	// Commit the post-state first, a stored block always has its state
+	root, err := statedb.Commit(number, c.config.IsEIP158(header.Number), c.config.IsCancun(header.Number, header.Time))
+	if err != nil {
+		return err
+	}
+	if root != header.Root {
+		return fmt.Errorf("%w: state root mismatch: got %x, want %x", ErrInvalidHexBlock, root, header.Root)
+	}
+	if err := c.triedb.Commit(root, false); err != nil {
+		return err
+	}
+
+	if err := c.forkChoice.Add(header); err != nil {
+		return err
+	}
+	score, _ := c.forkChoice.Score(hash)
+
+	batch := c.db.NewBatch()
+	writeHexHeader(batch, header)
+	writeHexBody(batch, hash, number, block.Body())
+	writeHexReceipts(batch, hash, number, receipts)
+	writeHexWeight(batch, hash, number, new(big.Int).SetUint64(score))
+
+	head := c.forkChoice.HeadHeader()
+	reorg := head.Hash() != c.head.Hash()
+	if reorg {
+		c.writeHead(batch, head)
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if reorg {
+		c.head = head
+	}
+	return nil
+}
+
+// writeHead moves the head to the header, reindexing the numbers along its
+// primary parent lineage down to the first block already indexed
+func (c *HexChain) writeHead(batch ethdb.KeyValueWriter, header *HexHeader) {
+	for n := header.Number.Uint64() + 1; n <= c.head.Number.Uint64(); n++ {
+		deleteHexCanonicalHash(batch, n)
+	}
+	for current := header; current != nil; {
+		hash, number := current.Hash(), current.Number.Uint64()
+		if hasHexCanonicalHash(c.db, hash, number) {
+			break
+		}
+		writeHexCanonicalHash(batch, hash, number)
+
+		if number == 0 {
+			break
+		}
+		parent := c.GetHexHeader(current.PrimaryParent())
+		if parent == nil {
+			log.Error("Missing primary parent while indexing head", "hash", hash, "parent", current.PrimaryParent())
+			break
+		}
+		// Primary parents may skip numbers, clear the skipped entries
+		for n := parent.Number.Uint64() + 1; n < number; n++ {
+			deleteHexCanonicalHash(batch, n)
+		}
+		current = parent
+	}
+	writeHexHeadHash(batch, header.Hash())
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// writeMeshBlock executes the modifications on the primary parent's state and
+// writes the resulting block
+func writeMeshBlock(t *testing.T, chain *HexChain, header *HexHeader, receipts types.Receipts, modify func(*state.StateDB)) *HexBlock {
+	statedb, err := chain.GetState(header.PrimaryParent())
+	if err != nil {
+		t.Fatalf("failed to open parent state: %v", err)
+	}
+	if modify != nil {
+		modify(statedb)
+	}
+	header.Root = statedb.IntermediateRoot(true)
+	block := NewHexBlock(header, nil, nil)
+	if err := chain.WriteBlock(block, receipts, statedb); err != nil {
+		t.Fatalf("failed to write block %d: %v", header.Number, err)
+	}
+	return block
+}
+
+func TestHexChain(t *testing.T) {
+	var (
+		alice  = common.HexToAddress("0xa1")
+		config = params.TestChainConfig
+		db     = rawdb.NewMemoryDatabase()
+	)
+	genesis := meshHeader(0)
+	genesis.Root = types.EmptyRootHash
+
+	chain, err := NewHexChain(db, config, NewHexBlock(genesis, nil, nil), nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	receipts := types.Receipts{{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: 21000, Logs: []*types.Log{}}}
+	block1 := writeMeshBlock(t, chain, meshHeader(1, genesis), receipts, func(s *state.StateDB) {
+		s.SetBalance(alice, uint256.NewInt(100), tracing.BalanceChangeUnspecified)
+	})
+	// The parents of the merge block sit in adjacent cells of its ring
+	ring := NewHexCoordinate(0, 0).Neighbors()
+	header2 := meshHeader(2, block1.Header())
+	header2.HexPosition = ring[HexNorthEast]
+	block2 := writeMeshBlock(t, chain, header2, nil, nil)
+	sideHeader := meshHeader(1, genesis)
+	sideHeader.HexPosition = ring[HexEast]
+	side := writeMeshBlock(t, chain, sideHeader, nil, nil)
+	if head := chain.CurrentHexHeader().Hash(); head != block2.Hash() {
+		t.Fatalf("head before merge: got %x, want %x", head, block2.Hash())
+	}
+
+	// The merge block is heaviest and its primary lineage skips number 2
+	merge := writeMeshBlock(t, chain, meshHeader(3, side.Header(), block2.Header()), nil, nil)
+	if head := chain.CurrentHexHeader().Hash(); head != merge.Hash() {
+		t.Fatalf("head after merge: got %x, want %x", head, merge.Hash())
+	}
+	// The merge block weighs its whole past cone: 1 + 3 + 3 + 3 + 5
+	if weight := chain.GetTotalWeight(merge.Hash()); weight == nil || weight.Uint64() != 15 {
+		t.Errorf("merge weight: got %v, want 15", weight)
+	}
+	for number, want := range map[uint64]common.Hash{0: genesis.Hash(), 1: side.Hash(), 2: {}, 3: merge.Hash()} {
+		var got common.Hash
+		if header := chain.GetHeaderByNumber(number); header != nil {
+			got = chain.GetHexHeader(header.Hash()).Hash()
+		}
+		if got != want {
+			t.Errorf("block at number %d: got %x, want %x", number, got, want)
+		}
+	}
+
+	// Blocks are reachable by their Ethereum header hash as well
+	ethHash := block1.Header().ToEthHeader().Hash()
+	if header := chain.GetHeaderByHash(ethHash); header == nil || header.Hash() != ethHash {
+		t.Errorf("lookup by Ethereum hash failed: %v", header)
+	}
+	if !chain.HasHexBlock(ethHash) || chain.GetHexBlock(ethHash).Hash() != block1.Hash() {
+		t.Errorf("block lookup by Ethereum hash failed")
+	}
+
+	// Known blocks and orphans are rejected
+	statedb, _ := chain.GetState(block1.Hash())
+	if err := chain.WriteBlock(block1, nil, statedb); !errors.Is(err, ErrKnownBlock) {
+		t.Errorf("known block: got %v, want %v", err, ErrKnownBlock)
+	}
+	orphan := meshHeader(2, meshHeader(1))
+	if err := chain.WriteBlock(NewHexBlock(orphan, nil, nil), nil, statedb); !errors.Is(err, ErrParentNotFound) {
+		t.Errorf("orphan block: got %v, want %v", err, ErrParentNotFound)
+	}
+
+	// Everything survives reopening the database
+	chain, err = NewHexChain(db, config, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen chain: %v", err)
+	}
+	if head := chain.CurrentHexHeader().Hash(); head != merge.Hash() {
+		t.Errorf("reopened head: got %x, want %x", head, merge.Hash())
+	}
+	if !chain.HasBlockAndState(block1.Hash(), 1) {
+		t.Errorf("block 1 or its state missing after reopening")
+	}
+	statedb, err = chain.GetState(block2.Hash())
+	if err != nil {
+		t.Fatalf("failed to open state of block 2: %v", err)
+	}
+	if balance := statedb.GetBalance(alice); balance.Uint64() != 100 {
+		t.Errorf("balance after reopening: got %d, want 100", balance)
+	}
+	if stored := chain.GetReceipts(block1.Hash()); len(stored) != 1 || stored[0].CumulativeGasUsed != 21000 {
+		t.Errorf("receipts after reopening: got %v", stored)
+	}
+
+	// A different genesis is refused
+	other := meshHeader(0)
+	other.Time = 42
+	if _, err := NewHexChain(db, config, NewHexBlock(other, nil, nil), nil); !errors.Is(err, ErrGenesisMismatch) {
+		t.Errorf("foreign genesis: got %v, want %v", err, ErrGenesisMismatch)
+	}
+}
+
+// The head is the fork choice head: a merge of two siblings outweighs a longer
+// single lineage, and the head is recomputed when the chain is reopened
+func TestHexChainForkChoice(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	genesis := meshHeader(0)
+	genesis.Root = types.EmptyRootHash
+
+	chain, err := NewHexChain(db, params.TestChainConfig, NewHexBlock(genesis, nil, nil), nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	ring := NewHexCoordinate(0, 0).Neighbors()
+	at := func(header *HexHeader, pos HexCoordinate) *HexHeader {
+		header.HexPosition = pos
+		return header
+	}
+	parent := genesis
+	for i := uint64(1); i <= 3; i++ {
+		parent = writeMeshBlock(t, chain, at(meshHeader(i, parent), ring[HexWest]), nil, nil).Header()
+	}
+	lineage := parent
+	if head := chain.CurrentHexHeader().Hash(); head != lineage.Hash() {
+		t.Fatalf("head: got %x, want tip of the lineage %x", head, lineage.Hash())
+	}
+	east := writeMeshBlock(t, chain, at(meshHeader(1, genesis), ring[HexEast]), nil, nil)
+	northEast := writeMeshBlock(t, chain, at(meshHeader(1, genesis), ring[HexNorthEast]), nil, nil)
+	merge := writeMeshBlock(t, chain, meshHeader(2, east.Header(), northEast.Header()), nil, nil)
+
+	// 1 + 3 + 3 + 6 outweighs 1 + 3 + 3 + 3
+	if head := chain.CurrentHexHeader().Hash(); head != merge.Hash() {
+		t.Fatalf("head: got %x, want merge block %x", head, merge.Hash())
+	}
+	if head := chain.ForkChoice().Head(); head != merge.Hash() {
+		t.Errorf("fork choice head: got %x, want %x", head, merge.Hash())
+	}
+	if weight := chain.GetTotalWeight(merge.Hash()); weight == nil || weight.Uint64() != 13 {
+		t.Errorf("merge weight: got %v, want 13", weight)
+	}
+	if header := chain.GetHeaderByNumber(3); header != nil {
+		t.Errorf("number 3 still indexed after the reorg")
+	}
+
+	// A reopened chain rebuilds the fork choice from the stored blocks
+	chain, err = NewHexChain(db, params.TestChainConfig, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen chain: %v", err)
+	}
+	if head := chain.ForkChoice().Head(); head != merge.Hash() {
+		t.Errorf("reopened fork choice head: got %x, want %x", head, merge.Hash())
+	}
+	if tips := chain.ForkChoice().Tips(); !sameHashes(tips, []common.Hash{merge.Hash(), lineage.Hash()}) {
+		t.Errorf("reopened tips: got %x", tips)
+	}
+}
+
+// sameHashes reports whether two hash lists hold the same hashes in any order
+func sameHashes(a, b []common.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[common.Hash]bool, len(a))
+	for _, hash := range a {
+		set[hash] = true
+	}
+	for _, hash := range b {
+		if !set[hash] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHexChainIndexes(t *testing.T) {
+	genesis := meshHeader(0)
+	genesis.Root = types.EmptyRootHash
+
+	chain, err := NewHexChain(rawdb.NewMemoryDatabase(), params.TestChainConfig, NewHexBlock(genesis, nil, nil), nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	at := func(header *HexHeader, q, r int64) *HexHeader {
+		header.HexPosition = NewHexCoordinate(q, r)
+		return header
+	}
+	east := writeMeshBlock(t, chain, at(meshHeader(1, genesis), 1, 0), nil, nil)
+	west := writeMeshBlock(t, chain, at(meshHeader(1, genesis), -1, 0), nil, nil)
+	merge := writeMeshBlock(t, chain, at(meshHeader(2, east.Header(), west.Header()), 1, 0), nil, nil)
+
+	tests := []struct {
+		name string
+		have []common.Hash
+		want []common.Hash
+	}{
+		{"cell (1,0)", chain.BlocksAtCoordinate(NewHexCoordinate(1, 0)), []common.Hash{east.Hash(), merge.Hash()}},
+		{"cell (-1,0)", chain.BlocksAtCoordinate(NewHexCoordinate(-1, 0)), []common.Hash{west.Hash()}},
+		{"cell (0,1)", chain.BlocksAtCoordinate(NewHexCoordinate(0, 1)), nil},
+		{"number 1", chain.BlocksAtNumber(1), []common.Hash{east.Hash(), west.Hash()}},
+		{"number 2", chain.BlocksAtNumber(2), []common.Hash{merge.Hash()}},
+		{"children of genesis", chain.Children(genesis.Hash()), []common.Hash{east.Hash(), west.Hash()}},
+		{"children by Ethereum hash", chain.Children(west.Header().ToEthHeader().Hash()), []common.Hash{merge.Hash()}},
+		{"children of tip", chain.Children(merge.Hash()), nil},
+	}
+	for _, tt := range tests {
+		if !sameHashes(tt.have, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.name, tt.have, tt.want)
+		}
+	}
+
+	// The mesh iterator walks all cells in position order and resumes anywhere
+	var positions [][]byte
+	all := chain.MeshIterator(nil)
+	for all.Next() {
+		positions = append(positions, all.Position())
+	}
+	all.Release()
+	if len(positions) != 4 {
+		t.Fatalf("mesh positions: got %d, want 4", len(positions))
+	}
+	for i := 1; i < len(positions); i++ {
+		if bytes.Compare(positions[i-1], positions[i]) >= 0 {
+			t.Errorf("mesh positions out of order at %d", i)
+		}
+	}
+	it := chain.MeshIterator(positions[2])
+	defer it.Release()
+	if !it.Next() || !bytes.Equal(it.Position(), positions[2]) {
+		t.Errorf("resumed mesh iterator at wrong position")
+	}
+}
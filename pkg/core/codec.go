@@ -0,0 +1,133 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// The hexagonal fields of a HexHeader are appended to the Ethereum header's
+// Extra field as a tagged suffix:
+//
+//	Extra = userExtra || rlp(hexExtra) || payloadLen (4 bytes, big endian) || version (1 byte) || magic (4 bytes)
+//
+// Parsing starts from the end of Extra, so the user supplied extra data is kept
+// verbatim in front of the suffix.
+const (
+	// HexExtraVersion is the current version of the hexagonal Extra suffix
+	HexExtraVersion = 1
+
+	hexExtraMagic      = "HEX\x00" // Tags an Extra field carrying hexagonal header data
+	hexExtraLenSize    = 4
+	hexExtraTrailerLen = hexExtraLenSize + 1 + len(hexExtraMagic)
+)
+
+var (
+	ErrMissingHexExtra     = errors.New("header extra carries no hexagonal data")
+	ErrUnsupportedHexExtra = errors.New("unsupported hexagonal extra version")
+	ErrPrimaryParent       = errors.New("primary parent does not match parent hashes")
+)
+
+// hexExtra is the versioned payload holding the hexagonal-only header fields
+type hexExtra struct {
+	ParentHashes  [6]common.Hash
+	NeighborCount uint8
+	HexPosition   HexCoordinate
+	MeshRoot      common.Hash
+	HexProof      HexaProof
+}
+
+// encodeHexExtra packs the hexagonal fields of the header behind its extra data
+func encodeHexExtra(h *HexHeader) []byte {
+	payload := rlpEncode(&hexExtra{
+		ParentHashes:  h.ParentHashes,
+		NeighborCount: h.NeighborCount,
+		HexPosition:   h.HexPosition,
+		MeshRoot:      h.MeshRoot,
+		HexProof:      h.HexProof,
+	})
+
+	extra := make([]byte, 0, len(h.Extra)+len(payload)+hexExtraTrailerLen)
+	extra = append(extra, h.Extra...)
+	extra = append(extra, payload...)
+	extra = binary.BigEndian.AppendUint32(extra, uint32(len(payload)))
+	extra = append(extra, HexExtraVersion)
+	extra = append(extra, hexExtraMagic...)
+	return extra
+}
+
+// decodeHexExtra splits an encoded extra field into the user extra data and
+// the hexagonal payload
+func decodeHexExtra(extra []byte) ([]byte, *hexExtra, error) {
+	if len(extra) < hexExtraTrailerLen || !bytes.HasSuffix(extra, []byte(hexExtraMagic)) {
+		return nil, nil, ErrMissingHexExtra
+	}
+	trailer := extra[len(extra)-hexExtraTrailerLen:]
+
+	if version := trailer[hexExtraLenSize]; version != HexExtraVersion {
+		return nil, nil, fmt.Errorf("%w: %d", ErrUnsupportedHexExtra, version)
+	}
+	payloadLen := uint64(binary.BigEndian.Uint32(trailer[:hexExtraLenSize]))
+	if payloadLen > uint64(len(extra)-hexExtraTrailerLen) {
+		return nil, nil, fmt.Errorf("hexagonal extra payload overflows extra data: %d bytes", payloadLen)
+	}
+
+	end := len(extra) - hexExtraTrailerLen
+	start := end - int(payloadLen)
+
+	var dec hexExtra
+	if err := rlp.DecodeBytes(extra[start:end], &dec); err != nil {
+		return nil, nil, fmt.Errorf("invalid hexagonal extra payload: %v", err)
+	}
+	return extra[:start], &dec, nil
+}
+
+// HexHeaderFromEth restores a HexHeader from a standard Ethereum header that
+// was produced by ToEthHeader. It is the exact inverse of ToEthHeader.
+func HexHeaderFromEth(header *types.Header) (*HexHeader, error) {
+	userExtra, dec, err := decodeHexExtra(header.Extra)
+	if err != nil {
+		return nil, err
+	}
+
+	hexHeader := &HexHeader{
+		ParentHashes:  dec.ParentHashes,
+		NeighborCount: dec.NeighborCount,
+		HexPosition:   dec.HexPosition,
+		MeshRoot:      dec.MeshRoot,
+		HexProof:      dec.HexProof,
+
+		Coinbase:        header.Coinbase,
+		Root:            header.Root,
+		TxHash:          header.TxHash,
+		ReceiptHash:     header.ReceiptHash,
+		Bloom:           header.Bloom,
+		Difficulty:      header.Difficulty,
+		Number:          header.Number,
+		GasLimit:        header.GasLimit,
+		GasUsed:         header.GasUsed,
+		Time:            header.Time,
+		MixDigest:       header.MixDigest,
+		Nonce:           header.Nonce,
+		BaseFee:         header.BaseFee,
+		WithdrawalsHash: header.WithdrawalsHash,
+		BlobGasUsed:     header.BlobGasUsed,
+		ExcessBlobGas:   header.ExcessBlobGas,
+		RequestsHash:    header.RequestsHash,
+	}
+	if len(userExtra) > 0 {
+		hexHeader.Extra = common.CopyBytes(userExtra)
+	}
+
+	// The Ethereum parent must be the primary (first non-empty) parent slot
+	if header.ParentHash != hexHeader.PrimaryParent() {
+		return nil, fmt.Errorf("%w: got %x, want %x", ErrPrimaryParent, header.ParentHash, hexHeader.PrimaryParent())
+	}
+
+	return hexHeader, nil
+}
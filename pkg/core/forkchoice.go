@@ -0,0 +1,481 @@
+package core
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// MaxPreferredTips is the number of tips a new block can build upon, one per
+// parent slot
+const MaxPreferredTips = 6
+
+// ReorgEvent is posted when the preferred tips of the mesh change. Dropped lists
+// the blocks that left the selected chain of the head, which is empty when the
+// new head simply extends the old one.
+type ReorgEvent struct {
+	OldHead common.Hash
+	NewHead common.Hash
+	OldTips []common.Hash
+	NewTips []common.Hash
+	Dropped []common.Hash
+}
+
+// meshNode is a block in the fork choice DAG
+type meshNode struct {
+	hash     common.Hash
+	header   *HexHeader
+	parents  []*meshNode
+	children []*meshNode
+
+	number         uint64    // Block number, lower than that of every child
+	selectedParent *meshNode // Heaviest parent, the block's predecessor in its selected chain
+	weight         uint64    // Mesh weight of the block itself
+	score          uint64    // Weight of the block's past cone, including itself
+}
+
+// ForkChoice selects the heaviest sub-mesh of the hex DAG, GHOSTDAG-style. Every
+// block weighs its MeshWeight, and the score of a
+// block is the total weight of its past cone. Each block extends the selected
+// chain of its heaviest parent; the blocks its other parents add to its past
+// cone form its merge set. The head is the tip with the highest score.
+type ForkChoice struct {
+	engine   consensus.Engine      // Consensus engine resolving block producers
+	resolver StateConflictResolver // Tie breaker between equally heavy blocks
+
+	nodes map[common.Hash]*meshNode
+	tips  map[common.Hash]*meshNode
+
+	head      *meshNode
+	preferred []common.Hash
+
+	reorgFeed event.Feed
+	mu        sync.RWMutex
+}
+
+// NewForkChoice creates a fork choice rooted at the genesis header. Ties between
+// equally heavy blocks are settled by the resolver, which defaults to the one
+// prescribed by the engine, or by the lowest hash if there is none.
+func NewForkChoice(genesis *HexHeader, engine consensus.Engine, resolver StateConflictResolver) *ForkChoice {
+	if e, ok := engine.(ResolverEngine); ok && resolver == nil {
+		resolver = e.StateConflictResolver()
+	}
+	weight := MeshWeight(genesis, [6]*HexHeader{})
+	root := &meshNode{
+		hash:   genesis.Hash(),
+		header: genesis,
+		number: genesis.Number.Uint64(),
+		weight: weight,
+		score:  weight,
+	}
+	fc := &ForkChoice{
+		engine:   engine,
+		resolver: resolver,
+		nodes:    map[common.Hash]*meshNode{root.hash: root},
+		tips:     map[common.Hash]*meshNode{root.hash: root},
+		head:     root,
+	}
+	fc.preferred = []common.Hash{root.hash}
+	return fc
+}
+
+// SubscribeReorgEvent registers a subscription for ReorgEvent
+func (fc *ForkChoice) SubscribeReorgEvent(ch chan<- ReorgEvent) event.Subscription {
+	return fc.reorgFeed.Subscribe(ch)
+}
+
+// Add inserts a validated block into the DAG and updates the head and the
+// preferred tips. All parents of the block must have been added before.
+func (fc *ForkChoice) Add(header *HexHeader) error {
+	fc.mu.Lock()
+
+	hash := header.Hash()
+	if _, ok := fc.nodes[hash]; ok {
+		fc.mu.Unlock()
+		return ErrKnownBlock
+	}
+	node := &meshNode{hash: hash, header: header, number: header.Number.Uint64()}
+	var (
+		parentHeaders [6]*HexHeader
+		candidates    []ConflictCandidate
+	)
+	for i, parentHash := range header.ParentHashes {
+		if parentHash == (common.Hash{}) {
+			continue
+		}
+		parent, ok := fc.nodes[parentHash]
+		if !ok {
+			fc.mu.Unlock()
+			return fmt.Errorf("%w: %x", ErrParentNotFound, parentHash)
+		}
+		node.parents = append(node.parents, parent)
+		parentHeaders[i] = parent.header
+		candidates = append(candidates, fc.candidate(parent, HexDirection(i)))
+	}
+	if len(node.parents) == 0 {
+		fc.mu.Unlock()
+		return errors.New("non-genesis block must have parents")
+	}
+
+	// Extend the selected chain of the heaviest parent and merge the rest
+	node.selectedParent = node.parents[fc.heaviest(node.parents, candidates)]
+	node.weight = MeshWeight(header, parentHeaders)
+	node.score = node.selectedParent.score + node.weight
+	for _, merged := range fc.mergeSet(node) {
+		node.score += merged.weight
+	}
+
+	fc.nodes[hash] = node
+	for _, parent := range node.parents {
+		parent.children = append(parent.children, node)
+		delete(fc.tips, parent.hash)
+	}
+	fc.tips[hash] = node
+
+	ev, changed := fc.updateHead()
+	fc.mu.Unlock()
+
+	if changed {
+		fc.reorgFeed.Send(ev)
+	}
+	return nil
+}
+
+// candidate wraps a block for the conflict resolver
+func (fc *ForkChoice) candidate(node *meshNode, dir HexDirection) ConflictCandidate {
+	candidate := ConflictCandidate{Direction: dir, Hash: node.hash, Header: node.header}
+	if fc.engine != nil {
+		if producer, err := fc.engine.Author(node.header.ToEthHeader()); err == nil {
+			candidate.Producer = producer
+		}
+	}
+	return candidate
+}
+
+// heaviest returns the index of the node with the highest score, settling ties
+// through the resolver
+func (fc *ForkChoice) heaviest(nodes []*meshNode, candidates []ConflictCandidate) int {
+	var best []int
+	for i, node := range nodes {
+		switch {
+		case len(best) == 0 || node.score > nodes[best[0]].score:
+			best = []int{i}
+		case node.score == nodes[best[0]].score:
+			best = append(best, i)
+		}
+	}
+	if len(best) == 1 {
+		return best[0]
+	}
+	if fc.resolver != nil {
+		tied := make([]ConflictCandidate, len(best))
+		for i, idx := range best {
+			tied[i] = candidates[idx]
+		}
+		if winner, err := fc.resolver.Resolve(tied); err == nil && winner >= 0 && winner < len(best) {
+			return best[winner]
+		}
+	}
+	winner := best[0]
+	for _, idx := range best[1:] {
+		if bytes.Compare(nodes[idx].hash[:], nodes[winner].hash[:]) < 0 {
+			winner = idx
+		}
+	}
+	return winner
+}
+
+// mergeSet returns the blocks in the past cone of the node that are not in the
+// past cone of its selected parent
+func (fc *ForkChoice) mergeSet(node *meshNode) []*meshNode {
+	var (
+		merged []*meshNode
+		seen   = map[common.Hash]bool{node.selectedParent.hash: true}
+		queue  []*meshNode
+		past   = newPastCone(node.selectedParent)
+	)
+	for _, parent := range node.parents {
+		if !seen[parent.hash] {
+			seen[parent.hash] = true
+			queue = append(queue, parent)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if past.contains(current) {
+			continue
+		}
+		merged = append(merged, current)
+		for _, parent := range current.parents {
+			if !seen[parent.hash] {
+				seen[parent.hash] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return merged
+}
+
+// pastCone answers repeated ancestry queries against one block. Its ancestors
+// are walked in descending number order, only as deep as the queries require,
+// so all queries together visit every ancestor at most once.
+type pastCone struct {
+	seen    map[*meshNode]bool
+	pending nodeHeap // Reached ancestors whose parents are not walked yet
+}
+
+// newPastCone creates the past cone of node, which includes node itself
+func newPastCone(node *meshNode) *pastCone {
+	return &pastCone{
+		seen:    map[*meshNode]bool{node: true},
+		pending: nodeHeap{node},
+	}
+}
+
+// contains reports whether node is in the past cone. Parents have lower numbers
+// than their children, so once every reached ancestor numbered above the node
+// is walked, the node is reached or not in the cone at all.
+func (p *pastCone) contains(node *meshNode) bool {
+	for len(p.pending) > 0 && p.pending[0].number > node.number {
+		current := heap.Pop(&p.pending).(*meshNode)
+		for _, parent := range current.parents {
+			if !p.seen[parent] {
+				p.seen[parent] = true
+				heap.Push(&p.pending, parent)
+			}
+		}
+	}
+	return p.seen[node]
+}
+
+// nodeHeap is a max-heap of mesh nodes by number
+type nodeHeap []*meshNode
+
+func (h nodeHeap) Len() int            { return len(h) }
+func (h nodeHeap) Less(i, j int) bool  { return h[i].number > h[j].number }
+func (h nodeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x interface{}) { *h = append(*h, x.(*meshNode)) }
+
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	node := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return node
+}
+
+// sortedTips returns the tips ordered by preference, heaviest first
+func (fc *ForkChoice) sortedTips() []*meshNode {
+	tips := make([]*meshNode, 0, len(fc.tips))
+	for _, tip := range fc.tips {
+		tips = append(tips, tip)
+	}
+	sort.Slice(tips, func(i, j int) bool {
+		if tips[i].score != tips[j].score {
+			return tips[i].score > tips[j].score
+		}
+		return bytes.Compare(tips[i].hash[:], tips[j].hash[:]) < 0
+	})
+	if len(tips) > 1 && tips[0].score == tips[1].score {
+		// Let the resolver settle the head among the heaviest tips
+		tied := 1
+		for tied < len(tips) && tips[tied].score == tips[0].score {
+			tied++
+		}
+		candidates := make([]ConflictCandidate, tied)
+		for i := 0; i < tied; i++ {
+			// Tips occupy no parent slot, so all compete from the same direction
+			candidates[i] = fc.candidate(tips[i], HexEast)
+		}
+		winner := fc.heaviest(tips[:tied], candidates)
+		tips[0], tips[winner] = tips[winner], tips[0]
+	}
+	return tips
+}
+
+// updateHead recomputes the head and the preferred tips, returning the reorg
+// event to post if the preferred tips changed
+func (fc *ForkChoice) updateHead() (ReorgEvent, bool) {
+	tips := fc.sortedTips()
+	if len(tips) > MaxPreferredTips {
+		tips = tips[:MaxPreferredTips]
+	}
+	preferred := make([]common.Hash, len(tips))
+	for i, tip := range tips {
+		preferred[i] = tip.hash
+	}
+	if equalHashes(preferred, fc.preferred) {
+		return ReorgEvent{}, false
+	}
+
+	ev := ReorgEvent{
+		OldHead: fc.head.hash,
+		NewHead: tips[0].hash,
+		OldTips: fc.preferred,
+		NewTips: preferred,
+	}
+	// Collect the old selected chain blocks the new head does not build on
+	past := newPastCone(tips[0])
+	for node := fc.head; node != nil && !past.contains(node); node = node.selectedParent {
+		ev.Dropped = append(ev.Dropped, node.hash)
+	}
+	fc.head, fc.preferred = tips[0], preferred
+	return ev, true
+}
+
+// Head returns the hash of the heaviest tip
+func (fc *ForkChoice) Head() common.Hash {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	return fc.head.hash
+}
+
+// HeadHeader returns the header of the heaviest tip
+func (fc *ForkChoice) HeadHeader() *HexHeader {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	return fc.head.header
+}
+
+// Tips returns all blocks without known children, heaviest first
+func (fc *ForkChoice) Tips() []common.Hash {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	tips := fc.sortedTips()
+	hashes := make([]common.Hash, len(tips))
+	for i, tip := range tips {
+		hashes[i] = tip.hash
+	}
+	return hashes
+}
+
+// PreferredTips returns the heaviest tips a new block should reference, at
+// most one per parent slot
+func (fc *ForkChoice) PreferredTips() []common.Hash {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	return append([]common.Hash(nil), fc.preferred...)
+}
+
+// Score returns the weight of the past cone of a block
+func (fc *ForkChoice) Score(hash common.Hash) (uint64, bool) {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	node, ok := fc.nodes[hash]
+	if !ok {
+		return 0, false
+	}
+	return node.score, true
+}
+
+// SelectedChain returns the selected chain of the head, from the head back to
+// genesis
+func (fc *ForkChoice) SelectedChain() []common.Hash {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	var chain []common.Hash
+	for node := fc.head; node != nil; node = node.selectedParent {
+		chain = append(chain, node.hash)
+	}
+	return chain
+}
+
+// Prune drops the blocks more than depth numbers below the lowest block of the
+// finalized frontier. Finalized blocks are never reorganised and new blocks may
+// only reference parents up to the depth, so the pruned ones can neither move
+// the head nor be built upon; their weight stays in the scores of the retained
+// blocks. The frontier and the head are always kept. It returns the number of
+// blocks dropped.
+func (fc *ForkChoice) Prune(frontier []common.Hash, depth uint64) int {
+	fc.mu.Lock()
+
+	var (
+		floor uint64
+		found bool
+		keep  = map[common.Hash]bool{fc.head.hash: true}
+	)
+	for _, hash := range frontier {
+		node, ok := fc.nodes[hash]
+		if !ok {
+			continue
+		}
+		if number := node.header.Number.Uint64(); !found || number < floor {
+			floor = number
+		}
+		found, keep[hash] = true, true
+	}
+	if !found || floor <= depth {
+		fc.mu.Unlock()
+		return 0
+	}
+	cutoff := floor - depth
+
+	pruned := make(map[*meshNode]bool)
+	for hash, node := range fc.nodes {
+		if !keep[hash] && node.header.Number.Uint64() < cutoff {
+			pruned[node] = true
+			delete(fc.nodes, hash)
+			delete(fc.tips, hash)
+		}
+	}
+	if len(pruned) == 0 {
+		fc.mu.Unlock()
+		return 0
+	}
+	// Unlink the retained blocks from the pruned ones so they can be collected
+	for _, node := range fc.nodes {
+		parents := node.parents[:0]
+		for _, parent := range node.parents {
+			if !pruned[parent] {
+				parents = append(parents, parent)
+			}
+		}
+		node.parents = parents
+
+		if pruned[node.selectedParent] {
+			node.selectedParent = nil
+		}
+		children := node.children[:0]
+		for _, child := range node.children {
+			if !pruned[child] {
+				children = append(children, child)
+			}
+		}
+		node.children = children
+	}
+	// Stale tips may have been pruned from the preferred ones
+	ev, changed := fc.updateHead()
+	fc.mu.Unlock()
+
+	if changed {
+		fc.reorgFeed.Send(ev)
+	}
+	return len(pruned)
+}
+
+// equalHashes reports whether two hash lists are identical
+func equalHashes(a, b []common.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
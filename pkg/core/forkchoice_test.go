@@ -0,0 +1,232 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// meshHeader creates a header referencing the given parents in slot order
+func meshHeader(number uint64, parents ...*HexHeader) *HexHeader {
+	header := &HexHeader{
+		Number:     new(big.Int).SetUint64(number),
+		Difficulty: big.NewInt(1),
+		Time:       number,
+	}
+	for i, parent := range parents {
+		header.ParentHashes[i] = parent.Hash()
+		header.NeighborCount++
+	}
+	return header
+}
+
+func TestForkChoice(t *testing.T) {
+	genesis := meshHeader(0)
+	fc := NewForkChoice(genesis, nil, nil)
+
+	events := make(chan ReorgEvent, 16)
+	sub := fc.SubscribeReorgEvent(events)
+	defer sub.Unsubscribe()
+
+	add := func(header *HexHeader) {
+		t.Helper()
+		if err := fc.Add(header); err != nil {
+			t.Fatalf("failed to add block %d: %v", header.Number, err)
+		}
+	}
+
+	// Two siblings on genesis in adjacent cells, merged by a third block
+	ring := NewHexCoordinate(0, 0).Neighbors()
+	a, b := meshHeader(1, genesis), meshHeader(1, genesis)
+	a.HexPosition, b.HexPosition = ring[HexEast], ring[HexNorthEast]
+	add(a)
+	add(b)
+	if tips := fc.Tips(); len(tips) != 2 {
+		t.Fatalf("tips after siblings: got %d, want 2", len(tips))
+	}
+	for len(events) > 0 {
+		<-events
+	}
+	c := meshHeader(2, a, b)
+	add(c)
+
+	// The merge block counts its whole past cone: 1 + 3 + 3 + 6
+	if score, _ := fc.Score(c.Hash()); score != 13 {
+		t.Errorf("merge block score: got %d, want 13", score)
+	}
+	if fc.Head() != c.Hash() {
+		t.Fatalf("head: got %x, want merge block %x", fc.Head(), c.Hash())
+	}
+	if tips := fc.PreferredTips(); len(tips) != 1 || tips[0] != c.Hash() {
+		t.Errorf("preferred tips: got %x, want [%x]", tips, c.Hash())
+	}
+	for len(events) > 0 {
+		if ev := <-events; len(ev.Dropped) != 0 {
+			t.Errorf("unexpected reorg dropping %x while merging the tips", ev.Dropped)
+		}
+	}
+
+	// A heavier branch off one of the siblings takes over the head
+	e := meshHeader(2, b)
+	add(e)
+	f := meshHeader(3, e)
+	add(f)
+	if fc.Head() != c.Hash() {
+		t.Fatalf("head switched to a lighter branch")
+	}
+	g := meshHeader(4, f) // Ties with the merge block
+	add(g)
+	h := meshHeader(5, g)
+	add(h)
+	if fc.Head() != h.Hash() {
+		t.Fatalf("head: got %x, want heavier branch %x", fc.Head(), h.Hash())
+	}
+
+	var reorg *ReorgEvent
+	for len(events) > 0 {
+		if ev := <-events; ev.OldHead == c.Hash() {
+			reorg = &ev
+		}
+	}
+	if reorg == nil {
+		t.Fatal("no reorg event leaving the merge block")
+	}
+	if reorg.OldHead != c.Hash() || len(reorg.Dropped) == 0 || reorg.Dropped[0] != c.Hash() {
+		t.Errorf("reorg: old head %x, dropped %x", reorg.OldHead, reorg.Dropped)
+	}
+	for _, hash := range reorg.Dropped {
+		if hash == b.Hash() || hash == genesis.Hash() {
+			t.Errorf("reorg dropped shared ancestor %x", hash)
+		}
+	}
+	if chain := fc.SelectedChain(); len(chain) != 6 || chain[len(chain)-1] != genesis.Hash() {
+		t.Errorf("selected chain: got %x", chain)
+	}
+
+	// Blocks with unknown parents or already known are rejected
+	if err := fc.Add(meshHeader(6, meshHeader(5))); err == nil {
+		t.Error("expected error for unknown parent")
+	}
+	if err := fc.Add(h); err != ErrKnownBlock {
+		t.Errorf("re-adding block: got %v, want %v", err, ErrKnownBlock)
+	}
+}
+
+func TestForkChoicePrune(t *testing.T) {
+	genesis := meshHeader(0)
+	fc := NewForkChoice(genesis, nil, nil)
+
+	// A chain of ten blocks with a stale side branch at the second
+	chain := []*HexHeader{genesis}
+	for i := uint64(1); i <= 10; i++ {
+		header := meshHeader(i, chain[i-1])
+		if err := fc.Add(header); err != nil {
+			t.Fatalf("failed to add block %d: %v", i, err)
+		}
+		chain = append(chain, header)
+	}
+	side := meshHeader(2, chain[1])
+	side.Coinbase = common.HexToAddress("0x5")
+	if err := fc.Add(side); err != nil {
+		t.Fatalf("failed to add side block: %v", err)
+	}
+	head := fc.Head()
+	score, _ := fc.Score(head)
+
+	// Nothing is pruned before the frontier is deeper than the retained depth
+	if n := fc.Prune([]common.Hash{chain[2].Hash()}, 2); n != 0 {
+		t.Errorf("pruned %d blocks below a shallow frontier", n)
+	}
+	// Finalizing the eighth block drops everything below the sixth
+	if n := fc.Prune([]common.Hash{chain[8].Hash()}, 2); n != 7 {
+		t.Errorf("pruned blocks: got %d, want 7", n)
+	}
+	if len(fc.nodes) != 5 || len(fc.Tips()) != 1 {
+		t.Errorf("retained %d blocks and %d tips, want 5 and 1", len(fc.nodes), len(fc.Tips()))
+	}
+	if got, _ := fc.Score(head); fc.Head() != head || got != score {
+		t.Errorf("head after pruning: got %x with score %d, want %x with %d", fc.Head(), got, head, score)
+	}
+	if selected := fc.SelectedChain(); len(selected) != 5 || selected[4] != chain[6].Hash() {
+		t.Errorf("selected chain after pruning: got %x", selected)
+	}
+
+	// The mesh keeps growing on the retained blocks, but not on pruned ones
+	next := meshHeader(11, chain[10])
+	if err := fc.Add(next); err != nil {
+		t.Fatalf("failed to extend pruned mesh: %v", err)
+	}
+	if got, _ := fc.Score(next.Hash()); got != score+MeshWeight(next, [6]*HexHeader{chain[10]}) {
+		t.Errorf("score after pruning: got %d, want %d", got, score+MeshWeight(next, [6]*HexHeader{chain[10]}))
+	}
+	if err := fc.Add(meshHeader(4, chain[3])); err == nil {
+		t.Error("expected block on a pruned parent to be rejected")
+	}
+}
+
+// Long branches are merged with the weight of every block the selected one
+// lacks, and a reorg to the other branch drops the whole old selected chain
+func TestForkChoiceLongBranches(t *testing.T) {
+	genesis := meshHeader(0)
+	fc := NewForkChoice(genesis, nil, nil)
+
+	branch := func(length int, coinbase common.Address) []*HexHeader {
+		headers := []*HexHeader{genesis}
+		for i := 1; i <= length; i++ {
+			header := meshHeader(uint64(i), headers[i-1])
+			header.Coinbase = coinbase
+			if err := fc.Add(header); err != nil {
+				t.Fatalf("failed to add block %d: %v", i, err)
+			}
+			headers = append(headers, header)
+		}
+		return headers[1:]
+	}
+	long := branch(300, common.HexToAddress("0x1"))
+	short := branch(200, common.HexToAddress("0x2"))
+	if fc.Head() != long[len(long)-1].Hash() {
+		t.Fatalf("head: got %x, want tip of the long branch", fc.Head())
+	}
+	longScore, _ := fc.Score(long[len(long)-1].Hash())
+	shortScore, _ := fc.Score(short[len(short)-1].Hash())
+	genesisScore, _ := fc.Score(genesis.Hash())
+
+	events := make(chan ReorgEvent, 1)
+	sub := fc.SubscribeReorgEvent(events)
+	defer sub.Unsubscribe()
+
+	// Merging from the short branch selects the long one and merges the other
+	tip := long[len(long)-1]
+	merge := meshHeader(301, short[len(short)-1], tip)
+	if err := fc.Add(merge); err != nil {
+		t.Fatalf("failed to add merge block: %v", err)
+	}
+	weight := MeshWeight(merge, [6]*HexHeader{short[len(short)-1], tip})
+	if score, _ := fc.Score(merge.Hash()); score != longScore+shortScore-genesisScore+weight {
+		t.Errorf("merge score: got %d, want %d", score, longScore+shortScore-genesisScore+weight)
+	}
+	if ev := <-events; len(ev.Dropped) != 0 {
+		t.Errorf("merge dropped %d blocks, want none", len(ev.Dropped))
+	}
+
+	// Outgrowing the merge on the short branch drops everything but genesis
+	var (
+		ev     ReorgEvent
+		parent = short[len(short)-1]
+	)
+	for ev.NewHead != parent.Hash() {
+		if score, _ := fc.Score(parent.Hash()); score > 2*longScore {
+			t.Fatalf("short branch at score %d never became head", score)
+		}
+		next := meshHeader(parent.Number.Uint64()+1, parent)
+		next.Coinbase = common.HexToAddress("0x2")
+		if err := fc.Add(next); err != nil {
+			t.Fatalf("failed to extend short branch: %v", err)
+		}
+		parent, ev = next, <-events
+	}
+	if len(ev.Dropped) != len(long)+1 {
+		t.Errorf("reorg dropped %d blocks, want %d", len(ev.Dropped), len(long)+1)
+	}
+}
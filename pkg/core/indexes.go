@@ -0,0 +1,110 @@
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// HashIterator walks the block hashes of an index entry in key order. It must
+// be released after use.
+type HashIterator struct {
+	it     ethdb.Iterator
+	prefix int // Length of the prefix stripped from positions
+	hash   common.Hash
+}
+
+// newHashIterator iterates the keys under prefix from the position start, each
+// key ending in a block hash
+func newHashIterator(db ethdb.Iteratee, prefix []byte, start []byte) *HashIterator {
+	return &HashIterator{it: db.NewIterator(prefix, start), prefix: len(prefix)}
+}
+
+// Next moves the iterator to the next hash, returning whether there is one
+func (it *HashIterator) Next() bool {
+	for it.it.Next() {
+		key := it.it.Key()
+		if len(key) < common.HashLength {
+			continue
+		}
+		it.hash = common.BytesToHash(key[len(key)-common.HashLength:])
+		return true
+	}
+	return false
+}
+
+// Hash returns the block hash at the current position
+func (it *HashIterator) Hash() common.Hash {
+	return it.hash
+}
+
+// Position returns the index key at the current position, without the prefix
+// the iterator was opened with
+func (it *HashIterator) Position() []byte {
+	return common.CopyBytes(it.it.Key()[it.prefix:])
+}
+
+// Error returns any failure that occurred during iteration
+func (it *HashIterator) Error() error {
+	return it.it.Error()
+}
+
+// Release releases the resources of the iterator
+func (it *HashIterator) Release() {
+	it.it.Release()
+}
+
+// collect drains the iterator into a slice and releases it
+func (it *HashIterator) collect() []common.Hash {
+	defer it.Release()
+
+	var hashes []common.Hash
+	for it.Next() {
+		hashes = append(hashes, it.Hash())
+	}
+	return hashes
+}
+
+// CoordinateIterator iterates the hashes of the blocks occupying a hex cell
+func (c *HexChain) CoordinateIterator(coord HexCoordinate) *HashIterator {
+	prefix := append(append([]byte{}, hexCoordPrefix...), encodeCoordinate(coord)...)
+	return newHashIterator(c.db, prefix, nil)
+}
+
+// MeshIterator iterates the blocks of the whole coordinate index from the
+// position start onwards. Positions are the encoded coordinate followed by the
+// block hash, ordering blocks by cell and then by hash.
+func (c *HexChain) MeshIterator(start []byte) *HashIterator {
+	return newHashIterator(c.db, hexCoordPrefix, start)
+}
+
+// NumberIterator iterates the hashes of the blocks at a number. The header
+// keys are ordered by number, so they double as the number index.
+func (c *HexChain) NumberIterator(number uint64) *HashIterator {
+	prefix := append(append([]byte{}, hexHeaderPrefix...), encodeBlockNumber(number)...)
+	return newHashIterator(c.db, prefix, nil)
+}
+
+// ChildIterator iterates the hashes of the blocks referencing a block by its
+// hex or Ethereum hash in any parent slot
+func (c *HexChain) ChildIterator(hash common.Hash) *HashIterator {
+	if hexHash, _, ok := readHexBlockNumber(c.db, hash); ok {
+		hash = hexHash
+	}
+	prefix := append(append([]byte{}, hexChildPrefix...), hash[:]...)
+	return newHashIterator(c.db, prefix, nil)
+}
+
+// BlocksAtCoordinate returns the hashes of the blocks occupying a hex cell
+func (c *HexChain) BlocksAtCoordinate(coord HexCoordinate) []common.Hash {
+	return c.CoordinateIterator(coord).collect()
+}
+
+// BlocksAtNumber returns the hashes of all blocks at a number
+func (c *HexChain) BlocksAtNumber(number uint64) []common.Hash {
+	return c.NumberIterator(number).collect()
+}
+
+// Children returns the hashes of the blocks referencing a block
+func (c *HexChain) Children(hash common.Hash) []common.Hash {
+	return c.ChildIterator(hash).collect()
+}
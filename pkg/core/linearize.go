@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// OrderedTransaction is a transaction at its position in the linearized mesh
+type OrderedTransaction struct {
+	Tx        *types.Transaction
+	Block     common.Hash // Block including this copy of the transaction
+	Index     int         // Index of the transaction within its block
+	Duplicate bool        // Whether an earlier copy precedes it in the order
+}
+
+// meshLess orders blocks that are ready at the same time in the topological
+// sort: by hex coordinate (Q, then R), then by hash
+func meshLess(a, b *HexBlock) bool {
+	pa, pb := a.HexPosition(), b.HexPosition()
+	if pa.Q != pb.Q {
+		return pa.Q < pb.Q
+	}
+	if pa.R != pb.R {
+		return pa.R < pb.R
+	}
+	ha, hb := a.Hash(), b.Hash()
+	return bytes.Compare(ha[:], hb[:]) < 0
+}
+
+// readyHeap is a min-heap of blocks by mesh order
+type readyHeap []*HexBlock
+
+func (h readyHeap) Len() int            { return len(h) }
+func (h readyHeap) Less(i, j int) bool  { return meshLess(h[i], h[j]) }
+func (h readyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *readyHeap) Push(x interface{}) { *h = append(*h, x.(*HexBlock)) }
+
+func (h *readyHeap) Pop() interface{} {
+	old := *h
+	block := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return block
+}
+
+// LinearizeBlocks returns the blocks in a deterministic topological order,
+// every block following its parents within the set. Blocks whose parents are
+// all placed are taken in hex coordinate order, then by hash.
+func LinearizeBlocks(blocks []*HexBlock) []*HexBlock {
+	var (
+		index    = make(map[common.Hash]*HexBlock, len(blocks))
+		pending  = make(map[common.Hash]int, len(blocks))
+		children = make(map[common.Hash][]*HexBlock, len(blocks))
+	)
+	for _, block := range blocks {
+		index[block.Hash()] = block
+	}
+	var ready readyHeap
+	for hash, block := range index {
+		for _, parentHash := range block.ParentHashes() {
+			if _, ok := index[parentHash]; ok && parentHash != (common.Hash{}) {
+				pending[hash]++
+				children[parentHash] = append(children[parentHash], block)
+			}
+		}
+		if pending[hash] == 0 {
+			ready = append(ready, block)
+		}
+	}
+
+	heap.Init(&ready)
+
+	order := make([]*HexBlock, 0, len(index))
+	for len(ready) > 0 {
+		next := heap.Pop(&ready).(*HexBlock)
+
+		order = append(order, next)
+		for _, child := range children[next.Hash()] {
+			if pending[child.Hash()]--; pending[child.Hash()] == 0 {
+				heap.Push(&ready, child)
+			}
+		}
+	}
+	return order
+}
+
+// LinearizeTransactions returns the transactions of the blocks in execution
+// order, following LinearizeBlocks. The first copy of a transaction wins, later
+// copies are marked as duplicates.
+func LinearizeTransactions(blocks []*HexBlock) []OrderedTransaction {
+	var (
+		ordered []OrderedTransaction
+		seen    = make(map[common.Hash]bool)
+	)
+	for _, block := range LinearizeBlocks(blocks) {
+		for i, tx := range block.Transactions() {
+			hash := tx.Hash()
+			ordered = append(ordered, OrderedTransaction{
+				Tx:        tx,
+				Block:     block.Hash(),
+				Index:     i,
+				Duplicate: seen[hash],
+			})
+			seen[hash] = true
+		}
+	}
+	return ordered
+}
+
+// concurrentBlocks returns the blocks merged into the pre-state of a block
+// besides their common ancestor: everything reachable from the parents within
+// the merge window above the ancestor. Parents are numbered below their
+// children, so none of these is in the past of the ancestor, and the window is
+// at most maxMergeDepth deep. Blocks with a single parent merge nothing.
+func (v *HexBlockValidator) concurrentBlocks(header *HexHeader) ([]*HexBlock, error) {
+	var parents []*HexHeader
+	for _, parentHash := range header.ParentHashes {
+		if parentHash == (common.Hash{}) {
+			continue
+		}
+		parent := v.bc.GetHexHeader(parentHash)
+		if parent == nil {
+			return nil, fmt.Errorf("%w: %x", ErrParentNotFound, parentHash)
+		}
+		parents = append(parents, parent)
+	}
+	if len(parents) < 2 {
+		return nil, nil
+	}
+	ancestor, err := v.findCommonAncestor(parents)
+	if err != nil {
+		return nil, err
+	}
+	floor := ancestor.Number.Uint64() + 1
+
+	region := make(map[common.Hash]*HexHeader)
+	for _, parent := range parents {
+		if parent.Number.Uint64() < floor {
+			continue // The ancestor itself
+		}
+		for hash, header := range v.collectAncestors(parent, floor) {
+			region[hash] = header
+		}
+	}
+	blocks := make([]*HexBlock, 0, len(region))
+	for hash := range region {
+		block := v.bc.GetHexBlock(hash)
+		if block == nil {
+			return nil, fmt.Errorf("missing concurrent block %x", hash)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestLinearizeTransactions(t *testing.T) {
+	var (
+		tx1 = types.NewTx(&types.LegacyTx{Nonce: 1, Gas: 21000, GasPrice: big.NewInt(1)})
+		tx2 = types.NewTx(&types.LegacyTx{Nonce: 2, Gas: 21000, GasPrice: big.NewInt(1)})
+		tx3 = types.NewTx(&types.LegacyTx{Nonce: 3, Gas: 21000, GasPrice: big.NewInt(1)})
+	)
+	block := func(pos HexCoordinate, txs []*types.Transaction, parents ...*HexBlock) *HexBlock {
+		header := &HexHeader{HexPosition: pos, Number: big.NewInt(int64(len(parents))), Difficulty: big.NewInt(1)}
+		for i, parent := range parents {
+			header.ParentHashes[i] = parent.Hash()
+			header.NeighborCount++
+		}
+		return NewHexBlock(header, txs, nil)
+	}
+
+	// Two concurrent siblings both include tx2, and their merge block includes
+	// tx1 again
+	root := block(NewHexCoordinate(0, 0), []*types.Transaction{tx1})
+	east := block(NewHexCoordinate(1, 0), []*types.Transaction{tx2, tx3}, root)
+	west := block(NewHexCoordinate(-1, 0), []*types.Transaction{tx2}, root)
+	merge := block(NewHexCoordinate(0, 1), []*types.Transaction{tx1}, east, west)
+
+	// The order must not depend on the input order
+	want := []common.Hash{root.Hash(), west.Hash(), east.Hash(), merge.Hash()}
+	for _, input := range [][]*HexBlock{
+		{root, east, west, merge},
+		{merge, west, east, root},
+	} {
+		order := LinearizeBlocks(input)
+		if len(order) != len(want) {
+			t.Fatalf("linearized %d blocks, want %d", len(order), len(want))
+		}
+		for i, block := range order {
+			if block.Hash() != want[i] {
+				t.Errorf("position %d: got block %x, want %x", i, block.Hash(), want[i])
+			}
+		}
+	}
+
+	ordered := LinearizeTransactions([]*HexBlock{merge, east, west, root})
+	expected := []struct {
+		tx        *types.Transaction
+		block     common.Hash
+		duplicate bool
+	}{
+		{tx1, root.Hash(), false},
+		{tx2, west.Hash(), false},
+		{tx2, east.Hash(), true},
+		{tx3, east.Hash(), false},
+		{tx1, merge.Hash(), true},
+	}
+	if len(ordered) != len(expected) {
+		t.Fatalf("ordered %d transactions, want %d", len(ordered), len(expected))
+	}
+	for i, exp := range expected {
+		got := ordered[i]
+		if got.Tx.Hash() != exp.tx.Hash() || got.Block != exp.block || got.Duplicate != exp.duplicate {
+			t.Errorf("position %d: got tx %x in %x (duplicate %t), want tx %x in %x (duplicate %t)",
+				i, got.Tx.Hash(), got.Block, got.Duplicate, exp.tx.Hash(), exp.block, exp.duplicate)
+		}
+	}
+}
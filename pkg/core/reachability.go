@@ -0,0 +1,252 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// reachabilityEpoch is the number of block numbers summarized by one
+	// ancestor bloom. Each block keeps the blooms of its own epoch and the
+	// previous one, so ancestors up to two epochs deep are filtered.
+	reachabilityEpoch = 32
+
+	// maxReachabilityVisits bounds the blocks visited by the walk settling a
+	// reachability query, capping the validation cost of deep or wide meshes.
+	// The walk does not depend on cached state, so every node agrees on it.
+	maxReachabilityVisits = 4096
+
+	// reachabilityCacheLimit is the number of ancestor summaries kept in memory
+	reachabilityCacheLimit = 4096
+)
+
+var (
+	ErrDuplicateParent = errors.New("duplicate parent")
+	ErrRedundantParent = errors.New("parent is an ancestor of another parent")
+	ErrMeshTooDeep     = errors.New("mesh too deep to validate")
+)
+
+// HeaderLookup retrieves a header by the hash parents reference it with
+type HeaderLookup func(hash common.Hash) *HexHeader
+
+// ancestorSummary holds bloom filters over the ancestors of a block, split by
+// the epoch of the ancestor's number
+type ancestorSummary struct {
+	number   uint64
+	current  types.Bloom // Ancestors in the block's own epoch
+	previous types.Bloom // Ancestors in the epoch before
+}
+
+// covers returns the bloom summarizing ancestors at the given number, if any
+func (s *ancestorSummary) covers(number uint64) (*types.Bloom, bool) {
+	switch epoch := s.number / reachabilityEpoch; number / reachabilityEpoch {
+	case epoch:
+		return &s.current, true
+	case epoch - 1:
+		return &s.previous, true
+	}
+	return nil, false
+}
+
+// ReachabilityIndex answers ancestry queries on the mesh. Bloom summaries of
+// recent ancestors rule out most negatives without walking the mesh; the rest
+// are settled by a walk bounded by block numbers and maxReachabilityVisits.
+//
+// Parents must have lower numbers than their children, which also makes
+// cycles impossible once a block does not reference itself.
+type ReachabilityIndex struct {
+	summaries *lru.Cache // Ancestor summaries by block hash
+}
+
+// NewReachabilityIndex creates an empty reachability index
+func NewReachabilityIndex() *ReachabilityIndex {
+	summaries, _ := lru.New(reachabilityCacheLimit)
+	return &ReachabilityIndex{summaries: summaries}
+}
+
+// summary returns the ancestor summary of a block. It is folded from the
+// summaries of the parents when they are cached, and otherwise collected by
+// walking the ancestors within the summarized epochs. Both yield the same
+// blooms, so answers never depend on the contents of the cache.
+func (r *ReachabilityIndex) summary(lookup HeaderLookup, hash common.Hash, header *HexHeader) (*ancestorSummary, error) {
+	if s, ok := r.summaries.Get(hash); ok {
+		return s.(*ancestorSummary), nil
+	}
+	s, err := r.foldSummary(lookup, header)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		if s, err = collectSummary(lookup, header); err != nil {
+			return nil, err
+		}
+	}
+	r.summaries.Add(hash, s)
+	return s, nil
+}
+
+// foldSummary derives the ancestor summary of a block from the cached
+// summaries of its parents, returning nil if any of them is missing
+func (r *ReachabilityIndex) foldSummary(lookup HeaderLookup, header *HexHeader) (*ancestorSummary, error) {
+	s := &ancestorSummary{number: header.Number.Uint64()}
+	for _, parentHash := range header.ParentHashes {
+		if parentHash == (common.Hash{}) {
+			continue
+		}
+		parent := lookup(parentHash)
+		if parent == nil {
+			return nil, fmt.Errorf("%w: %x", ErrParentNotFound, parentHash)
+		}
+		bloom, ok := s.covers(parent.Number.Uint64())
+		if !ok {
+			continue // Neither the parent nor its ancestors are summarized
+		}
+		bloom.Add(parentHash[:])
+
+		cached, ok := r.summaries.Get(parentHash)
+		if !ok {
+			return nil, nil
+		}
+		// Fold the parent's blooms into the matching epochs of the block
+		ps := cached.(*ancestorSummary)
+		if ps.number/reachabilityEpoch == s.number/reachabilityEpoch {
+			orBloom(&s.current, &ps.current)
+			orBloom(&s.previous, &ps.previous)
+		} else {
+			orBloom(&s.previous, &ps.current)
+		}
+	}
+	return s, nil
+}
+
+// collectSummary builds the ancestor summary of a block by walking all of its
+// ancestors in the summarized epochs. Parents have lower numbers than their
+// children, so every path to such an ancestor stays within those epochs.
+func collectSummary(lookup HeaderLookup, header *HexHeader) (*ancestorSummary, error) {
+	var (
+		s     = &ancestorSummary{number: header.Number.Uint64()}
+		seen  = make(map[common.Hash]bool)
+		queue = []*HexHeader{header}
+	)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, parentHash := range current.ParentHashes {
+			if parentHash == (common.Hash{}) || seen[parentHash] {
+				continue
+			}
+			seen[parentHash] = true
+
+			parent := lookup(parentHash)
+			if parent == nil {
+				return nil, fmt.Errorf("%w: %x", ErrParentNotFound, parentHash)
+			}
+			if bloom, ok := s.covers(parent.Number.Uint64()); ok {
+				bloom.Add(parentHash[:])
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return s, nil
+}
+
+// orBloom merges the src bloom into dst
+func orBloom(dst, src *types.Bloom) {
+	for i := range dst {
+		dst[i] |= src[i]
+	}
+}
+
+// IsAncestor reports whether ancestor is in the past cone of descendant
+func (r *ReachabilityIndex) IsAncestor(lookup HeaderLookup, ancestor, descendant common.Hash) (bool, error) {
+	if ancestor == descendant {
+		return true, nil
+	}
+	anc, desc := lookup(ancestor), lookup(descendant)
+	if anc == nil {
+		return false, fmt.Errorf("%w: %x", ErrParentNotFound, ancestor)
+	}
+	if desc == nil {
+		return false, fmt.Errorf("%w: %x", ErrParentNotFound, descendant)
+	}
+	limit := anc.Number.Uint64()
+	if limit >= desc.Number.Uint64() {
+		return false, nil
+	}
+	// Recent ancestors missing from the bloom are certainly not reachable
+	s, err := r.summary(lookup, descendant, desc)
+	if err != nil {
+		return false, err
+	}
+	if bloom, ok := s.covers(limit); ok && !bloom.Test(ancestor[:]) {
+		return false, nil
+	}
+
+	// Confirm the bloom hit, or search older ancestors, by walking the mesh
+	var (
+		seen   = map[common.Hash]bool{descendant: true}
+		queue  = []*HexHeader{desc}
+		visits int
+	)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, parentHash := range current.ParentHashes {
+			if parentHash == (common.Hash{}) || seen[parentHash] {
+				continue
+			}
+			if parentHash == ancestor {
+				return true, nil
+			}
+			seen[parentHash] = true
+
+			if visits++; visits > maxReachabilityVisits {
+				return false, ErrMeshTooDeep
+			}
+			parent := lookup(parentHash)
+			if parent == nil {
+				return false, fmt.Errorf("%w: %x", ErrParentNotFound, parentHash)
+			}
+			if parent.Number.Uint64() > limit {
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return false, nil
+}
+
+// ValidateParents checks that the parents of a block are distinct and that no
+// parent is an ancestor of another one
+func (r *ReachabilityIndex) ValidateParents(lookup HeaderLookup, parents [6]common.Hash) error {
+	seen := make(map[common.Hash]HexDirection)
+	for i, parentHash := range parents {
+		if parentHash == (common.Hash{}) {
+			continue
+		}
+		if prev, ok := seen[parentHash]; ok {
+			return fmt.Errorf("%w: %x in slots %s and %s", ErrDuplicateParent, parentHash, prev, HexDirection(i))
+		}
+		seen[parentHash] = HexDirection(i)
+	}
+	for a, dirA := range seen {
+		for b, dirB := range seen {
+			if a == b {
+				continue
+			}
+			reachable, err := r.IsAncestor(lookup, a, b)
+			if err != nil {
+				return err
+			}
+			if reachable {
+				return fmt.Errorf("%w: %s parent %x precedes %s parent %x", ErrRedundantParent, dirA, a, dirB, b)
+			}
+		}
+	}
+	return nil
+}
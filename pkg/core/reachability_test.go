@@ -0,0 +1,81 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestReachabilityIndex(t *testing.T) {
+	headers := make(map[common.Hash]*HexHeader)
+	lookup := func(hash common.Hash) *HexHeader { return headers[hash] }
+	add := func(header *HexHeader) *HexHeader {
+		headers[header.Hash()] = header
+		return header
+	}
+
+	// A long chain spanning several bloom epochs with a side block near the tip
+	chain := []*HexHeader{add(meshHeader(0))}
+	for i := uint64(1); i < 5000; i++ {
+		chain = append(chain, add(meshHeader(i, chain[i-1])))
+	}
+	side := meshHeader(95, chain[94])
+	side.Time = 1000
+	add(side)
+	index := NewReachabilityIndex()
+
+	tests := []struct {
+		ancestor, descendant *HexHeader
+		want                 bool
+	}{
+		{chain[90], chain[99], true},
+		{chain[40], chain[99], true},
+		{chain[99], chain[90], false},
+		{side, chain[99], false},
+		{chain[94], side, true},
+		{chain[95], side, false},
+	}
+	for i, tt := range tests {
+		got, err := index.IsAncestor(lookup, tt.ancestor.Hash(), tt.descendant.Hash())
+		if err != nil {
+			t.Fatalf("test %d: reachability failed: %v", i, err)
+		}
+		if got != tt.want {
+			t.Errorf("test %d: %d ancestor of %d: got %v, want %v", i, tt.ancestor.Number, tt.descendant.Number, got, tt.want)
+		}
+	}
+
+	// Parents must be distinct and mutually unreachable
+	var parents [6]common.Hash
+	parents[HexEast], parents[HexWest] = chain[99].Hash(), side.Hash()
+	if err := index.ValidateParents(lookup, parents); err != nil {
+		t.Errorf("concurrent parents rejected: %v", err)
+	}
+	parents[HexWest] = chain[50].Hash()
+	if err := index.ValidateParents(lookup, parents); !errors.Is(err, ErrRedundantParent) {
+		t.Errorf("redundant parent: got %v, want %v", err, ErrRedundantParent)
+	}
+	parents[HexWest] = chain[99].Hash()
+	if err := index.ValidateParents(lookup, parents); !errors.Is(err, ErrDuplicateParent) {
+		t.Errorf("duplicate parent: got %v, want %v", err, ErrDuplicateParent)
+	}
+
+	// A cold index answers like a warm one, however deep the history
+	tip := meshHeader(4995, chain[4994])
+	tip.Time = 1000
+	add(tip)
+	for _, index := range []*ReachabilityIndex{NewReachabilityIndex(), index} {
+		if ok, err := index.IsAncestor(lookup, chain[4990].Hash(), chain[4999].Hash()); err != nil || !ok {
+			t.Errorf("recent ancestor on a deep chain: got %v, %v", ok, err)
+		}
+		if ok, err := index.IsAncestor(lookup, tip.Hash(), chain[4999].Hash()); err != nil || ok {
+			t.Errorf("unrelated block on a deep chain: got %v, %v", ok, err)
+		}
+	}
+
+	// Walks deeper than the visit budget are refused
+	if _, err := index.IsAncestor(lookup, chain[0].Hash(), chain[4999].Hash()); !errors.Is(err, ErrMeshTooDeep) {
+		t.Errorf("deep query: got %v, want %v", err, ErrMeshTooDeep)
+	}
+}
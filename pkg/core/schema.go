@@ -0,0 +1,276 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Hex chain data lives under its own prefixes so it never collides with the
+// Ethereum chain schema of rawdb, which shares the database for trie nodes.
+var (
+	hexHeadKey = []byte("hex-LastBlock") // Hash of the heaviest known block
+
+	hexHeaderPrefix    = []byte("hex-h") // hexHeaderPrefix + num (uint64 big endian) + hash -> header
+	hexBodyPrefix      = []byte("hex-b") // hexBodyPrefix + num + hash -> body
+	hexReceiptsPrefix  = []byte("hex-r") // hexReceiptsPrefix + num + hash -> receipts
+	hexWeightPrefix    = []byte("hex-w") // hexWeightPrefix + num + hash -> total weight
+	hexNumberPrefix    = []byte("hex-n") // hexNumberPrefix + hash -> num
+	hexCanonicalPrefix = []byte("hex-c") // hexCanonicalPrefix + num -> hash on the primary lineage of the head
+	hexEthHashPrefix   = []byte("hex-e") // hexEthHashPrefix + Ethereum header hash -> hash
+	hexCoordPrefix     = []byte("hex-x") // hexCoordPrefix + q + r (sign flipped big endian) + hash -> nothing
+	hexChildPrefix     = []byte("hex-k") // hexChildPrefix + parent hash + child hash -> nothing
+)
+
+// encodeBlockNumber encodes a block number as big endian uint64
+func encodeBlockNumber(number uint64) []byte {
+	enc := make([]byte, 8)
+	binary.BigEndian.PutUint64(enc, number)
+	return enc
+}
+
+// numHashKey builds a key of the prefix followed by the number and the hash
+func numHashKey(prefix []byte, number uint64, hash common.Hash) []byte {
+	key := make([]byte, 0, len(prefix)+8+common.HashLength)
+	key = append(key, prefix...)
+	key = append(key, encodeBlockNumber(number)...)
+	return append(key, hash[:]...)
+}
+
+func hexHeaderKey(number uint64, hash common.Hash) []byte {
+	return numHashKey(hexHeaderPrefix, number, hash)
+}
+
+func hexBodyKey(number uint64, hash common.Hash) []byte {
+	return numHashKey(hexBodyPrefix, number, hash)
+}
+
+func hexReceiptsKey(number uint64, hash common.Hash) []byte {
+	return numHashKey(hexReceiptsPrefix, number, hash)
+}
+
+func hexWeightKey(number uint64, hash common.Hash) []byte {
+	return numHashKey(hexWeightPrefix, number, hash)
+}
+
+func hexNumberKey(hash common.Hash) []byte {
+	return append(append([]byte{}, hexNumberPrefix...), hash[:]...)
+}
+
+func hexCanonicalKey(number uint64) []byte {
+	return append(append([]byte{}, hexCanonicalPrefix...), encodeBlockNumber(number)...)
+}
+
+func hexEthHashKey(ethHash common.Hash) []byte {
+	return append(append([]byte{}, hexEthHashPrefix...), ethHash[:]...)
+}
+
+// encodeCoordinate encodes a hex coordinate so keys sort by Q, then R
+func encodeCoordinate(coord HexCoordinate) []byte {
+	enc := make([]byte, 16)
+	binary.BigEndian.PutUint64(enc[:8], uint64(coord.Q)^(1<<63))
+	binary.BigEndian.PutUint64(enc[8:], uint64(coord.R)^(1<<63))
+	return enc
+}
+
+// hexCoordKey = hexCoordPrefix + coordinate + hash
+func hexCoordKey(coord HexCoordinate, hash common.Hash) []byte {
+	return append(append(append([]byte{}, hexCoordPrefix...), encodeCoordinate(coord)...), hash[:]...)
+}
+
+// hexChildKey = hexChildPrefix + parent hash + child hash
+func hexChildKey(parent, child common.Hash) []byte {
+	return append(append(append([]byte{}, hexChildPrefix...), parent[:]...), child[:]...)
+}
+
+// readHexHeadHash retrieves the hash of the heaviest known block
+func readHexHeadHash(db ethdb.KeyValueReader) common.Hash {
+	data, _ := db.Get(hexHeadKey)
+	if len(data) == 0 {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// writeHexHeadHash stores the hash of the heaviest known block
+func writeHexHeadHash(db ethdb.KeyValueWriter, hash common.Hash) {
+	if err := db.Put(hexHeadKey, hash.Bytes()); err != nil {
+		log.Crit("Failed to store hex head hash", "err", err)
+	}
+}
+
+// readHexBlockNumber returns the number of a block, resolving Ethereum header
+// hashes to their hex block first
+func readHexBlockNumber(db ethdb.KeyValueReader, hash common.Hash) (common.Hash, uint64, bool) {
+	data, _ := db.Get(hexNumberKey(hash))
+	if len(data) != 8 {
+		alias, _ := db.Get(hexEthHashKey(hash))
+		if len(alias) != common.HashLength {
+			return common.Hash{}, 0, false
+		}
+		hash = common.BytesToHash(alias)
+		if data, _ = db.Get(hexNumberKey(hash)); len(data) != 8 {
+			return common.Hash{}, 0, false
+		}
+	}
+	return hash, binary.BigEndian.Uint64(data), true
+}
+
+// readHexHeader retrieves the header of a block
+func readHexHeader(db ethdb.KeyValueReader, hash common.Hash, number uint64) *HexHeader {
+	data, _ := db.Get(hexHeaderKey(number, hash))
+	if len(data) == 0 {
+		return nil
+	}
+	header := new(HexHeader)
+	if err := rlp.DecodeBytes(data, header); err != nil {
+		log.Error("Invalid hex header RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return header
+}
+
+// writeHexHeader stores a header together with its number and Ethereum hash
+// lookups and its coordinate and child index entries
+func writeHexHeader(db ethdb.KeyValueWriter, header *HexHeader) {
+	var (
+		hash   = header.Hash()
+		number = header.Number.Uint64()
+	)
+	data, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		log.Crit("Failed to RLP encode hex header", "err", err)
+	}
+	if err := db.Put(hexHeaderKey(number, hash), data); err != nil {
+		log.Crit("Failed to store hex header", "err", err)
+	}
+	if err := db.Put(hexNumberKey(hash), encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store hex block number", "err", err)
+	}
+	if err := db.Put(hexEthHashKey(header.ToEthHeader().Hash()), hash.Bytes()); err != nil {
+		log.Crit("Failed to store Ethereum hash lookup", "err", err)
+	}
+	if err := db.Put(hexCoordKey(header.HexPosition, hash), nil); err != nil {
+		log.Crit("Failed to store hex coordinate index", "err", err)
+	}
+	for _, parentHash := range header.ParentHashes {
+		if parentHash == (common.Hash{}) {
+			continue
+		}
+		if err := db.Put(hexChildKey(parentHash, hash), nil); err != nil {
+			log.Crit("Failed to store hex child index", "err", err)
+		}
+	}
+}
+
+// readHexBody retrieves the body of a block
+func readHexBody(db ethdb.KeyValueReader, hash common.Hash, number uint64) *HexBody {
+	data, _ := db.Get(hexBodyKey(number, hash))
+	if len(data) == 0 {
+		return nil
+	}
+	body := new(HexBody)
+	if err := rlp.DecodeBytes(data, body); err != nil {
+		log.Error("Invalid hex body RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return body
+}
+
+// writeHexBody stores the body of a block
+func writeHexBody(db ethdb.KeyValueWriter, hash common.Hash, number uint64, body *HexBody) {
+	data, err := rlp.EncodeToBytes(body)
+	if err != nil {
+		log.Crit("Failed to RLP encode hex body", "err", err)
+	}
+	if err := db.Put(hexBodyKey(number, hash), data); err != nil {
+		log.Crit("Failed to store hex body", "err", err)
+	}
+}
+
+// readHexReceipts retrieves the consensus fields of the receipts of a block.
+// Derived fields are not stored.
+func readHexReceipts(db ethdb.KeyValueReader, hash common.Hash, number uint64) types.Receipts {
+	data, _ := db.Get(hexReceiptsKey(number, hash))
+	if len(data) == 0 {
+		return nil
+	}
+	var storage []*types.ReceiptForStorage
+	if err := rlp.DecodeBytes(data, &storage); err != nil {
+		log.Error("Invalid hex receipts RLP", "hash", hash, "err", err)
+		return nil
+	}
+	receipts := make(types.Receipts, len(storage))
+	for i, receipt := range storage {
+		receipts[i] = (*types.Receipt)(receipt)
+	}
+	return receipts
+}
+
+// writeHexReceipts stores the receipts of a block
+func writeHexReceipts(db ethdb.KeyValueWriter, hash common.Hash, number uint64, receipts types.Receipts) {
+	storage := make([]*types.ReceiptForStorage, len(receipts))
+	for i, receipt := range receipts {
+		storage[i] = (*types.ReceiptForStorage)(receipt)
+	}
+	data, err := rlp.EncodeToBytes(storage)
+	if err != nil {
+		log.Crit("Failed to RLP encode hex receipts", "err", err)
+	}
+	if err := db.Put(hexReceiptsKey(number, hash), data); err != nil {
+		log.Crit("Failed to store hex receipts", "err", err)
+	}
+}
+
+// readHexWeight retrieves the total weight of a block
+func readHexWeight(db ethdb.KeyValueReader, hash common.Hash, number uint64) *big.Int {
+	data, _ := db.Get(hexWeightKey(number, hash))
+	if len(data) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(data)
+}
+
+// writeHexWeight stores the total weight of a block
+func writeHexWeight(db ethdb.KeyValueWriter, hash common.Hash, number uint64, weight *big.Int) {
+	if err := db.Put(hexWeightKey(number, hash), weight.Bytes()); err != nil {
+		log.Crit("Failed to store hex total weight", "err", err)
+	}
+}
+
+// readHexCanonicalHash retrieves the hash at a number on the primary lineage of
+// the head
+func readHexCanonicalHash(db ethdb.KeyValueReader, number uint64) common.Hash {
+	data, _ := db.Get(hexCanonicalKey(number))
+	if len(data) == 0 {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// writeHexCanonicalHash stores the hash at a number on the primary lineage of
+// the head
+func writeHexCanonicalHash(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Put(hexCanonicalKey(number), hash.Bytes()); err != nil {
+		log.Crit("Failed to store hex canonical hash", "err", err)
+	}
+}
+
+// deleteHexCanonicalHash removes the hash at a number from the primary lineage
+func deleteHexCanonicalHash(db ethdb.KeyValueWriter, number uint64) {
+	if err := db.Delete(hexCanonicalKey(number)); err != nil {
+		log.Crit("Failed to delete hex canonical hash", "err", err)
+	}
+}
+
+// hasHexCanonicalHash reports whether the number maps to the given hash
+func hasHexCanonicalHash(db ethdb.KeyValueReader, hash common.Hash, number uint64) bool {
+	data, _ := db.Get(hexCanonicalKey(number))
+	return bytes.Equal(data, hash[:])
+}
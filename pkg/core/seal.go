@@ -0,0 +1,104 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Extra data layout of sealed hexagonal headers:
+//
+//	Extra = vanity (32 bytes) || validators (20 bytes each, checkpoints only) || seal (65 bytes)
+const (
+	ExtraVanity = 32                     // Fixed number of extra-data prefix bytes reserved for producer vanity
+	ExtraSeal   = crypto.SignatureLength // Fixed number of extra-data suffix bytes reserved for the producer seal
+)
+
+// SealHash returns the hash of a header prior to it being signed. The producer
+// seal and the neighbor signatures both derive from this hash, so they are
+// excluded together with the cached proof hash that commits to them.
+func SealHash(header *HexHeader) common.Hash {
+	cpy := *header
+	if len(cpy.Extra) >= ExtraSeal {
+		cpy.Extra = cpy.Extra[:len(cpy.Extra)-ExtraSeal]
+	}
+	cpy.HexProof.NeighborSignatures = [6][]byte{}
+	cpy.HexProof.ProofHash = common.Hash{}
+	return rlpHash(&cpy)
+}
+
+// NeighborSigningHash returns the digest the producer of the parent block in
+// the given direction slot signs to endorse the header. The state root is not
+// covered: it includes the rewards paid for the endorsements themselves.
+func NeighborSigningHash(header *HexHeader, dir HexDirection) common.Hash {
+	cpy := *header
+	cpy.Root = common.Hash{}
+	sealHash := SealHash(&cpy)
+	return crypto.Keccak256Hash(sealHash[:], []byte{byte(dir)})
+}
+
+// RecoverSigner recovers the address that produced a secp256k1 signature
+// over the given digest
+func RecoverSigner(digest common.Hash, sig []byte) (common.Address, error) {
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("%w: signature length %d, want %d", ErrInvalidProof, len(sig), crypto.SignatureLength)
+	}
+	pubkey, err := crypto.SigToPub(digest[:], sig)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
+
+// VerifyNeighborSignatures checks that every occupied parent slot carries a
+// signature from the producer of that parent, and that each signer is part of
+// the validators authorized to produce the header, as tracked by the consensus
+// engine. The proof must declare exactly that validator set, the header cannot
+// vouch for its own signers. Slots without a parent must be unsigned.
+//
+// parentSigner returns the producer of the given parent block, recoverSigner returns
+// the signer of a slot so callers can plug in signature caching.
+func VerifyNeighborSignatures(header *HexHeader, active []common.Address, parentSigner func(common.Hash) (common.Address, error), recoverSigner func(HexDirection) (common.Address, error)) error {
+	proof := &header.HexProof
+
+	if len(proof.ValidatorSet) != len(active) {
+		return fmt.Errorf("%w: proof declares %d validators, %d active", ErrInvalidProof, len(proof.ValidatorSet), len(active))
+	}
+	validators := make(map[common.Address]bool, len(active))
+	for i, validator := range active {
+		if proof.ValidatorSet[i] != validator {
+			return fmt.Errorf("%w: proof validator %x, active validator %x", ErrInvalidProof, proof.ValidatorSet[i], validator)
+		}
+		validators[validator] = true
+	}
+
+	for i, parentHash := range header.ParentHashes {
+		dir := HexDirection(i)
+		if parentHash == (common.Hash{}) {
+			if len(proof.NeighborSignatures[i]) > 0 {
+				return fmt.Errorf("%w: signature in empty %s slot", ErrInvalidProof, dir)
+			}
+			continue
+		}
+		if len(proof.NeighborSignatures[i]) == 0 {
+			return fmt.Errorf("%w: missing signature in %s slot", ErrInvalidProof, dir)
+		}
+
+		signer, err := recoverSigner(dir)
+		if err != nil {
+			return fmt.Errorf("invalid signature in %s slot: %w", dir, err)
+		}
+		expected, err := parentSigner(parentHash)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve producer of %s parent %x: %v", dir, parentHash, err)
+		}
+		if signer != expected {
+			return fmt.Errorf("%w: %s slot signed by %x, parent produced by %x", ErrInvalidProof, dir, signer, expected)
+		}
+		if !validators[signer] {
+			return fmt.Errorf("%w: %s slot signer %x not in validator set", ErrInvalidProof, dir, signer)
+		}
+	}
+	return nil
+}
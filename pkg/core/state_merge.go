@@ -0,0 +1,568 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
+)
+
+const (
+	// maxMergeDepth bounds how far below the parents the common ancestor
+	// search walks before giving up
+	maxMergeDepth = 128
+)
+
+var (
+	ErrNoCommonAncestor = errors.New("parents share no common ancestor")
+	ErrMissingPreimage  = errors.New("missing trie key preimage")
+)
+
+// StateField identifies which part of an account a state write touches
+type StateField uint8
+
+const (
+	BalanceField StateField = iota
+	NonceField
+	CodeField
+	StorageField
+	AccountField // The account as a whole, only written when it is deleted
+)
+
+// String returns the string representation of a state field
+func (f StateField) String() string {
+	fields := []string{"balance", "nonce", "code", "storage", "account"}
+	if int(f) < len(fields) {
+		return fields[f]
+	}
+	return "unknown"
+}
+
+// StateKey identifies a single mutable item of the world state
+type StateKey struct {
+	Address common.Address
+	Field   StateField
+	Slot    common.Hash // Storage slot, only set for storage writes
+}
+
+// String returns a human readable representation of the state key
+func (k StateKey) String() string {
+	if k.Field == StorageField {
+		return fmt.Sprintf("%s.storage[%s]", k.Address.Hex(), k.Slot.Hex())
+	}
+	return fmt.Sprintf("%s.%s", k.Address.Hex(), k.Field)
+}
+
+// less orders state keys by address, field and slot
+func (k StateKey) less(other StateKey) bool {
+	if c := bytes.Compare(k.Address[:], other.Address[:]); c != 0 {
+		return c < 0
+	}
+	if k.Field != other.Field {
+		return k.Field < other.Field
+	}
+	return bytes.Compare(k.Slot[:], other.Slot[:]) < 0
+}
+
+// ConflictCandidate is one of the parents competing for a conflicting write
+type ConflictCandidate struct {
+	Direction HexDirection   // Parent slot the candidate occupies
+	Hash      common.Hash    // Parent block hash
+	Header    *HexHeader     // Parent block header
+	Producer  common.Address // Validator that produced the parent block
+}
+
+// StateConflictResolver picks the winning candidate of a conflict. It must be
+// deterministic so every node merges parent states identically.
+type StateConflictResolver interface {
+	Resolve(candidates []ConflictCandidate) (int, error)
+}
+
+// ResolverEngine is implemented by consensus engines prescribing the conflict
+// resolver of the chain, which the state merger and fork choice adopt
+type ResolverEngine interface {
+	StateConflictResolver() StateConflictResolver
+}
+
+// stateWrite is the value a parent wrote to a state key
+type stateWrite struct {
+	value common.Hash // Balance, nonce, code hash or storage value
+	base  common.Hash // Balance in the ancestor state, only set for balance writes
+	code  []byte      // Contract code, only set for code writes
+}
+
+// stateDiff holds the writes of a state relative to an ancestor state
+type stateDiff map[StateKey]stateWrite
+
+// emptyAccount is the content of an account that does not exist
+var emptyAccount = types.StateAccount{
+	Balance:  new(uint256.Int),
+	Root:     types.EmptyRootHash,
+	CodeHash: types.EmptyCodeHash.Bytes(),
+}
+
+// SetConflictResolver sets the resolver applied to conflicting parent writes,
+// replacing the one prescribed by the engine. Without a resolver every
+// conflict fails the merge.
+func (v *HexBlockValidator) SetConflictResolver(resolver StateConflictResolver) {
+	v.resolver = resolver
+}
+
+// MergeParentStates builds the pre-state of a block by merging the states of
+// all its parents. Each parent's writes are diffed against the parents' common
+// ancestor; non-conflicting writes are combined and conflicting ones are
+// settled by the configured conflict resolver. Differing balance changes to
+// the same account add up, and deleting an account conflicts with any other
+// write to it.
+//
+// Diffs only visit the trie nodes that differ between the ancestor and each
+// parent, but need the backing trie database to record key preimages.
+func (v *HexBlockValidator) MergeParentStates(header *HexHeader) (*state.StateDB, error) {
+	var (
+		parents    []*HexHeader
+		candidates []ConflictCandidate
+	)
+	for i, parentHash := range header.ParentHashes {
+		if parentHash == (common.Hash{}) {
+			continue
+		}
+		parent := v.bc.GetHexHeader(parentHash)
+		if parent == nil {
+			return nil, fmt.Errorf("%w: %x", ErrParentNotFound, parentHash)
+		}
+		producer, err := v.engine.Author(parent.ToEthHeader())
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve producer of parent %x: %v", parentHash, err)
+		}
+		parents = append(parents, parent)
+		candidates = append(candidates, ConflictCandidate{
+			Direction: HexDirection(i),
+			Hash:      parentHash,
+			Header:    parent,
+			Producer:  producer,
+		})
+	}
+
+	switch len(parents) {
+	case 0:
+		return nil, errors.New("block has no parent states to merge")
+	case 1:
+		parentState, err := v.bc.GetState(candidates[0].Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get parent state %x: %v", candidates[0].Hash, err)
+		}
+		return parentState.Copy(), nil
+	}
+
+	ancestor, err := v.findCommonAncestor(parents)
+	if err != nil {
+		return nil, err
+	}
+	ancestorState, err := v.bc.GetState(ancestor.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ancestor state %x: %v", ancestor.Hash(), err)
+	}
+	db := ancestorState.Database()
+
+	diffs := make([]stateDiff, len(parents))
+	for i, parent := range parents {
+		if diffs[i], err = diffState(db, ancestor.Root, parent.Root); err != nil {
+			return nil, fmt.Errorf("failed to diff parent state %x: %v", candidates[i].Hash, err)
+		}
+	}
+
+	writes, err := v.mergeDiffs(diffs, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := state.New(ancestor.Root, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ancestor state %x: %v", ancestor.Hash(), err)
+	}
+	for _, key := range sortedKeys(writes) {
+		applyWrite(merged, key, writes[key])
+	}
+	// Drop the deleted accounts before the block executes on the state
+	merged.Finalise(v.config.IsEIP158(header.Number))
+	return merged, nil
+}
+
+// mergeDiffs combines the parent diffs, resolving every key written with
+// different values by more than one parent. Differing balance writes are merged
+// by adding up their changes, which only conflict if the sum leaves the uint256
+// range; parents agreeing on a balance, like siblings executing the same
+// transaction, made one change. An account deletion competes with every parent
+// writing to the account.
+func (v *HexBlockValidator) mergeDiffs(diffs []stateDiff, candidates []ConflictCandidate) (stateDiff, error) {
+	var (
+		keys    = make(map[StateKey]struct{})
+		touched = make(map[common.Address][]int) // Parents writing to an account without deleting it
+	)
+	for i, diff := range diffs {
+		for key := range diff {
+			keys[key] = struct{}{}
+			if key.Field != AccountField {
+				if writers := touched[key.Address]; len(writers) == 0 || writers[len(writers)-1] != i {
+					touched[key.Address] = append(writers, i)
+				}
+			}
+		}
+	}
+
+	var (
+		merged    = make(stateDiff, len(keys))
+		conflicts []StateKey
+	)
+	for key := range keys {
+		var (
+			writers     []int
+			conflicting bool
+		)
+		for i, diff := range diffs {
+			write, ok := diff[key]
+			if !ok {
+				continue
+			}
+			if len(writers) > 0 && diffs[writers[0]][key].value != write.value {
+				conflicting = true
+			}
+			writers = append(writers, i)
+		}
+		switch key.Field {
+		case BalanceField:
+			if conflicting {
+				if write, ok := mergeBalances(diffs, writers, key); ok {
+					merged[key] = write
+					continue
+				}
+				conflicting = true
+			}
+		case AccountField:
+			if len(touched[key.Address]) > 0 {
+				writers = append(writers, touched[key.Address]...)
+				sort.Ints(writers)
+				conflicting = true
+			}
+		}
+		if !conflicting {
+			merged[key] = diffs[writers[0]][key]
+			continue
+		}
+
+		if v.resolver == nil {
+			conflicts = append(conflicts, key)
+			continue
+		}
+		competing := make([]ConflictCandidate, len(writers))
+		for i, writer := range writers {
+			competing[i] = candidates[writer]
+		}
+		winner, err := v.resolver.Resolve(competing)
+		if err != nil || winner < 0 || winner >= len(writers) {
+			conflicts = append(conflicts, key)
+			continue
+		}
+		if write, ok := diffs[writers[winner]][key]; ok {
+			merged[key] = write
+		}
+	}
+	// Deleted accounts drop the writes of the parents that lost to the deletion
+	for key := range merged {
+		if key.Field == AccountField {
+			continue
+		}
+		if _, ok := merged[StateKey{Address: key.Address, Field: AccountField}]; ok {
+			delete(merged, key)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].less(conflicts[j]) })
+		names := make([]string, len(conflicts))
+		for i, key := range conflicts {
+			names[i] = key.String()
+		}
+		return nil, fmt.Errorf("%w: unresolved keys %s", ErrStateConflict, strings.Join(names, ", "))
+	}
+	return merged, nil
+}
+
+// mergeBalances adds up the balance changes the writers made to the ancestor
+// balance, failing if the sum is negative or overflows
+func mergeBalances(diffs []stateDiff, writers []int, key StateKey) (stateWrite, bool) {
+	base := diffs[writers[0]][key].base
+	total := new(big.Int).SetBytes(base[:])
+	for _, writer := range writers {
+		write := diffs[writer][key]
+		total.Add(total, new(big.Int).SetBytes(write.value[:]))
+		total.Sub(total, new(big.Int).SetBytes(base[:]))
+	}
+	if total.Sign() < 0 || total.BitLen() > 256 {
+		return stateWrite{}, false
+	}
+	return stateWrite{value: common.BigToHash(total), base: base}, true
+}
+
+// findCommonAncestor returns the highest block that is an ancestor (or equal
+// to) every given parent. Ties are broken by the lowest hash.
+func (v *HexBlockValidator) findCommonAncestor(parents []*HexHeader) (*HexHeader, error) {
+	minNumber := parents[0].Number.Uint64()
+	for _, parent := range parents[1:] {
+		if n := parent.Number.Uint64(); n < minNumber {
+			minNumber = n
+		}
+	}
+	var floor uint64
+	if minNumber > maxMergeDepth {
+		floor = minNumber - maxMergeDepth
+	}
+
+	var shared map[common.Hash]*HexHeader
+	for _, parent := range parents {
+		ancestors := v.collectAncestors(parent, floor)
+		if shared == nil {
+			shared = ancestors
+			continue
+		}
+		for hash := range shared {
+			if _, ok := ancestors[hash]; !ok {
+				delete(shared, hash)
+			}
+		}
+	}
+
+	var best *HexHeader
+	for hash, header := range shared {
+		if best == nil {
+			best = header
+			continue
+		}
+		switch header.Number.Cmp(best.Number) {
+		case 1:
+			best = header
+		case 0:
+			if bytes.Compare(hash[:], best.Hash().Bytes()) < 0 {
+				best = header
+			}
+		}
+	}
+	if best == nil {
+		return nil, ErrNoCommonAncestor
+	}
+	return best, nil
+}
+
+// collectAncestors walks the mesh breadth-first from the given header down to
+// the floor block number, returning the header and all its reachable ancestors
+func (v *HexBlockValidator) collectAncestors(header *HexHeader, floor uint64) map[common.Hash]*HexHeader {
+	ancestors := map[common.Hash]*HexHeader{header.Hash(): header}
+	queue := []*HexHeader{header}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, parentHash := range current.ParentHashes {
+			if parentHash == (common.Hash{}) {
+				continue
+			}
+			if _, ok := ancestors[parentHash]; ok {
+				continue
+			}
+			parent := v.bc.GetHexHeader(parentHash)
+			if parent == nil || parent.Number.Uint64() < floor {
+				continue
+			}
+			ancestors[parentHash] = parent
+			queue = append(queue, parent)
+		}
+	}
+	return ancestors
+}
+
+// diffState computes the writes that turn the state at base into the state
+// at root, visiting only the trie nodes that differ between the two
+func diffState(db state.Database, base, root common.Hash) (stateDiff, error) {
+	baseTrie, err := db.OpenTrie(base)
+	if err != nil {
+		return nil, err
+	}
+	targetTrie, err := db.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	statedb, err := state.New(root, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Accounts created or modified by the target
+	diff := make(stateDiff)
+	changed := make(map[string]bool)
+
+	it, err := diffLeaves(baseTrie, targetTrie)
+	if err != nil {
+		return nil, err
+	}
+	for it.Next() {
+		changed[string(it.Key)] = true
+
+		addrBytes := targetTrie.GetKey(it.Key)
+		if addrBytes == nil {
+			return nil, fmt.Errorf("%w: account %x", ErrMissingPreimage, it.Key)
+		}
+		addr := common.BytesToAddress(addrBytes)
+
+		var account types.StateAccount
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			return nil, fmt.Errorf("invalid account %x: %v", addr, err)
+		}
+		prev, err := baseTrie.GetAccount(addr)
+		if err != nil {
+			return nil, err
+		}
+		if prev == nil {
+			prev = &emptyAccount
+		}
+		if !account.Balance.Eq(prev.Balance) {
+			diff[StateKey{Address: addr, Field: BalanceField}] = stateWrite{value: account.Balance.Bytes32(), base: prev.Balance.Bytes32()}
+		}
+		if account.Nonce != prev.Nonce {
+			var nonce common.Hash
+			binary.BigEndian.PutUint64(nonce[common.HashLength-8:], account.Nonce)
+			diff[StateKey{Address: addr, Field: NonceField}] = stateWrite{value: nonce}
+		}
+		if codeHash := common.BytesToHash(account.CodeHash); codeHash != common.BytesToHash(prev.CodeHash) {
+			diff[StateKey{Address: addr, Field: CodeField}] = stateWrite{value: codeHash, code: statedb.GetCode(addr)}
+		}
+		if account.Root != prev.Root {
+			if err := diffStorage(db, base, root, addr, prev.Root, account.Root, baseTrie, targetTrie, diff); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+
+	// Accounts deleted by the target, together with their storage
+	if it, err = diffLeaves(targetTrie, baseTrie); err != nil {
+		return nil, err
+	}
+	for it.Next() {
+		if changed[string(it.Key)] {
+			continue
+		}
+		addrBytes := baseTrie.GetKey(it.Key)
+		if addrBytes == nil {
+			return nil, fmt.Errorf("%w: account %x", ErrMissingPreimage, it.Key)
+		}
+		diff[StateKey{Address: common.BytesToAddress(addrBytes), Field: AccountField}] = stateWrite{}
+	}
+	return diff, it.Err
+}
+
+// diffStorage adds the storage writes that turn the storage trie of an account
+// at from into the one at to
+func diffStorage(db state.Database, base, root common.Hash, addr common.Address, from, to common.Hash, baseTrie, targetTrie state.Trie, diff stateDiff) error {
+	fromTrie, err := db.OpenStorageTrie(base, addr, from, baseTrie)
+	if err != nil {
+		return err
+	}
+	toTrie, err := db.OpenStorageTrie(root, addr, to, targetTrie)
+	if err != nil {
+		return err
+	}
+
+	// Slots set or modified by the target
+	written := make(map[string]bool)
+	it, err := diffLeaves(fromTrie, toTrie)
+	if err != nil {
+		return err
+	}
+	for it.Next() {
+		written[string(it.Key)] = true
+
+		slot := toTrie.GetKey(it.Key)
+		if slot == nil {
+			return fmt.Errorf("%w: storage %x of %x", ErrMissingPreimage, it.Key, addr)
+		}
+		_, content, _, err := rlp.Split(it.Value)
+		if err != nil {
+			return fmt.Errorf("invalid storage value of %x: %v", addr, err)
+		}
+		diff[StateKey{Address: addr, Field: StorageField, Slot: common.BytesToHash(slot)}] = stateWrite{value: common.BytesToHash(content)}
+	}
+	if it.Err != nil {
+		return it.Err
+	}
+
+	// Slots cleared by the target
+	if it, err = diffLeaves(toTrie, fromTrie); err != nil {
+		return err
+	}
+	for it.Next() {
+		if written[string(it.Key)] {
+			continue
+		}
+		slot := fromTrie.GetKey(it.Key)
+		if slot == nil {
+			return fmt.Errorf("%w: storage %x of %x", ErrMissingPreimage, it.Key, addr)
+		}
+		diff[StateKey{Address: addr, Field: StorageField, Slot: common.BytesToHash(slot)}] = stateWrite{}
+	}
+	return it.Err
+}
+
+// diffLeaves iterates over the leaves of the target trie that are missing or
+// hold another value in the base trie, skipping the subtries both share
+func diffLeaves(base, target state.Trie) (*trie.Iterator, error) {
+	baseIt, err := base.NodeIterator(nil)
+	if err != nil {
+		return nil, err
+	}
+	targetIt, err := target.NodeIterator(nil)
+	if err != nil {
+		return nil, err
+	}
+	diffIt, _ := trie.NewDifferenceIterator(baseIt, targetIt)
+	return trie.NewIterator(diffIt), nil
+}
+
+// applyWrite performs a single merged write on the state
+func applyWrite(statedb *state.StateDB, key StateKey, write stateWrite) {
+	switch key.Field {
+	case BalanceField:
+		statedb.SetBalance(key.Address, new(uint256.Int).SetBytes32(write.value[:]), tracing.BalanceChangeUnspecified)
+	case NonceField:
+		statedb.SetNonce(key.Address, binary.BigEndian.Uint64(write.value[common.HashLength-8:]), tracing.NonceChangeUnspecified)
+	case CodeField:
+		statedb.SetCode(key.Address, write.code)
+	case StorageField:
+		statedb.SetState(key.Address, key.Slot, write.value)
+	case AccountField:
+		statedb.SelfDestruct(key.Address)
+	}
+}
+
+// sortedKeys returns the keys of a diff in deterministic order
+func sortedKeys(diff stateDiff) []StateKey {
+	keys := make([]StateKey, 0, len(diff))
+	for key := range diff {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	return keys
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+)
+
+// testEngine attributes blocks to their coinbase and authorizes a fixed
+// validator set
+type testEngine struct {
+	consensus.Engine
+	validators []common.Address
+}
+
+func (testEngine) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+func (e testEngine) Validators(chain consensus.ChainHeaderReader, hash common.Hash) ([]common.Address, error) {
+	return e.validators, nil
+}
+
+func (testEngine) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state vm.StateDB, body *types.Body) {
+}
+
+// testChain is a minimal in-memory HexBlockChain backed by a shared state database
+type testChain struct {
+	HexBlockChain
+
+	sdb     state.Database
+	headers map[common.Hash]*HexHeader
+	blocks  map[common.Hash]*HexBlock
+}
+
+func newTestChain() *testChain {
+	tdb := triedb.NewDatabase(rawdb.NewMemoryDatabase(), &triedb.Config{Preimages: true})
+	return &testChain{
+		sdb:     state.NewDatabase(tdb, nil),
+		headers: make(map[common.Hash]*HexHeader),
+		blocks:  make(map[common.Hash]*HexBlock),
+	}
+}
+
+func (c *testChain) GetHexHeader(hash common.Hash) *HexHeader { return c.headers[hash] }
+func (c *testChain) GetHexBlock(hash common.Hash) *HexBlock   { return c.blocks[hash] }
+
+func (c *testChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	if header := c.headers[hash]; header != nil {
+		return header.ToEthHeader()
+	}
+	return nil
+}
+func (c *testChain) HasHexBlock(hash common.Hash) bool { return c.headers[hash] != nil }
+
+func (c *testChain) GetState(hash common.Hash) (*state.StateDB, error) {
+	header := c.headers[hash]
+	if header == nil {
+		return nil, ErrParentNotFound
+	}
+	return state.New(header.Root, c.sdb)
+}
+
+// addBlock commits the modifications on top of the parents' first state and
+// stores the resulting header
+func (c *testChain) addBlock(t *testing.T, coinbase common.Address, parents []common.Hash, modify func(*state.StateDB)) common.Hash {
+	root := types.EmptyRootHash
+	number := uint64(0)
+	header := &HexHeader{Coinbase: coinbase, Difficulty: big.NewInt(1)}
+	for i, parent := range parents {
+		header.ParentHashes[i] = parent
+		header.NeighborCount++
+		if i == 0 {
+			root = c.headers[parent].Root
+		}
+		if n := c.headers[parent].Number.Uint64() + 1; n > number {
+			number = n
+		}
+	}
+	statedb, err := state.New(root, c.sdb)
+	if err != nil {
+		t.Fatalf("failed to open state: %v", err)
+	}
+	modify(statedb)
+	if header.Root, err = statedb.Commit(number, true, false); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	header.Number = new(big.Int).SetUint64(number)
+	c.headers[header.Hash()] = header
+	return header.Hash()
+}
+
+// addHexBlock processes a block on top of its merged parent states, commits
+// the resulting state and stores the block
+func (c *testChain) addHexBlock(t *testing.T, validator *HexBlockValidator, header *HexHeader, txs []*types.Transaction) *HexBlock {
+	statedb, err := validator.MergeParentStates(header)
+	if err != nil {
+		t.Fatalf("failed to build pre-state of block %d: %v", header.Number, err)
+	}
+	result, err := validator.ProcessHexBlock(NewHexBlock(header, txs, nil), statedb)
+	if err != nil {
+		t.Fatalf("failed to process block %d: %v", header.Number, err)
+	}
+	header.GasUsed = result.GasUsed
+	if header.Root, err = statedb.Commit(header.Number.Uint64(), true, false); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	block := NewHexBlock(header, txs, nil)
+	c.headers[block.Hash()] = header
+	c.blocks[block.Hash()] = block
+	return block
+}
+
+// slotResolver always picks the candidate in the highest direction slot
+type slotResolver struct{}
+
+func (slotResolver) Resolve(candidates []ConflictCandidate) (int, error) {
+	winner := 0
+	for i, c := range candidates {
+		if c.Direction > candidates[winner].Direction {
+			winner = i
+		}
+	}
+	return winner, nil
+}
+
+// resolvingEngine prescribes the slot resolver
+type resolvingEngine struct {
+	testEngine
+}
+
+func (resolvingEngine) StateConflictResolver() StateConflictResolver {
+	return slotResolver{}
+}
+
+func TestMergeParentStates(t *testing.T) {
+	var (
+		alice = common.HexToAddress("0xa1")
+		bob   = common.HexToAddress("0xb0")
+		carol = common.HexToAddress("0xc0")
+		slot1 = common.HexToHash("0x01")
+		slot2 = common.HexToHash("0x02")
+	)
+	chain := newTestChain()
+	validator := NewHexBlockValidator(params.TestChainConfig, chain, testEngine{})
+
+	genesis := chain.addBlock(t, common.Address{}, nil, func(s *state.StateDB) {
+		s.SetBalance(alice, uint256.NewInt(100), tracing.BalanceChangeUnspecified)
+		s.SetNonce(bob, 1, tracing.NonceChangeUnspecified)
+		s.SetState(bob, slot1, common.HexToHash("0xaa"))
+	})
+	left := chain.addBlock(t, common.HexToAddress("0x01"), []common.Hash{genesis}, func(s *state.StateDB) {
+		s.SetBalance(alice, uint256.NewInt(50), tracing.BalanceChangeUnspecified)
+		s.SetState(bob, slot1, common.HexToHash("0xbb"))
+	})
+	right := chain.addBlock(t, common.HexToAddress("0x02"), []common.Hash{genesis}, func(s *state.StateDB) {
+		s.SetBalance(carol, uint256.NewInt(7), tracing.BalanceChangeUnspecified)
+		s.SetState(bob, slot2, common.HexToHash("0xcc"))
+	})
+	conflicting := chain.addBlock(t, common.HexToAddress("0x03"), []common.Hash{genesis}, func(s *state.StateDB) {
+		s.SetBalance(alice, uint256.NewInt(60), tracing.BalanceChangeUnspecified)
+		s.SetState(bob, slot1, common.HexToHash("0xdd"))
+	})
+	drained := chain.addBlock(t, common.HexToAddress("0x05"), []common.Hash{genesis}, func(s *state.StateDB) {
+		s.SetBalance(alice, uint256.NewInt(20), tracing.BalanceChangeUnspecified)
+	})
+
+	// Non-conflicting writes from both parents are combined
+	merged, err := validator.MergeParentStates(&HexHeader{
+		ParentHashes:  [6]common.Hash{left, right},
+		NeighborCount: 2,
+		Number:        big.NewInt(2),
+	})
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if balance := merged.GetBalance(alice); balance.Uint64() != 50 {
+		t.Errorf("alice balance: got %d, want 50", balance.Uint64())
+	}
+	if balance := merged.GetBalance(carol); balance.Uint64() != 7 {
+		t.Errorf("carol balance: got %d, want 7", balance.Uint64())
+	}
+	if value := merged.GetState(bob, slot1); value != common.HexToHash("0xbb") {
+		t.Errorf("bob slot1: got %x, want 0xbb", value)
+	}
+	if value := merged.GetState(bob, slot2); value != common.HexToHash("0xcc") {
+		t.Errorf("bob slot2: got %x, want 0xcc", value)
+	}
+
+	// Conflicting writes fail without a resolver
+	conflictHeader := &HexHeader{
+		ParentHashes:  [6]common.Hash{left, {}, conflicting},
+		NeighborCount: 2,
+		Number:        big.NewInt(2),
+	}
+	_, err = validator.MergeParentStates(conflictHeader)
+	if !errors.Is(err, ErrStateConflict) {
+		t.Fatalf("expected ErrStateConflict, got %v", err)
+	}
+	if strings.Contains(err.Error(), ".balance") {
+		t.Errorf("balance changes reported as conflict: %v", err)
+	}
+	// Balance changes add up unless they overdraw the account
+	overdrawn := &HexHeader{
+		ParentHashes:  [6]common.Hash{left, drained},
+		NeighborCount: 2,
+		Number:        big.NewInt(2),
+	}
+	if _, err := validator.MergeParentStates(overdrawn); !errors.Is(err, ErrStateConflict) {
+		t.Errorf("overdrawn balance: got %v, want %v", err, ErrStateConflict)
+	}
+
+	// Conflicts are settled by the resolver otherwise
+	validator.SetConflictResolver(slotResolver{})
+	merged, err = validator.MergeParentStates(conflictHeader)
+	if err != nil {
+		t.Fatalf("merge with resolver failed: %v", err)
+	}
+	if value := merged.GetState(bob, slot1); value != common.HexToHash("0xdd") {
+		t.Errorf("resolved bob slot1: got %x, want 0xdd", value)
+	}
+	if balance := merged.GetBalance(alice); balance.Uint64() != 10 {
+		t.Errorf("summed alice balance: got %d, want 10", balance.Uint64())
+	}
+	if merged, err = validator.MergeParentStates(overdrawn); err != nil {
+		t.Fatalf("merge of overdrawn balance with resolver failed: %v", err)
+	} else if balance := merged.GetBalance(alice); balance.Uint64() != 20 {
+		t.Errorf("resolved alice balance: got %d, want 20", balance.Uint64())
+	}
+	// The resolver of the engine is adopted by default
+	merged, err = NewHexBlockValidator(params.TestChainConfig, chain, resolvingEngine{}).MergeParentStates(conflictHeader)
+	if err != nil {
+		t.Fatalf("merge with engine resolver failed: %v", err)
+	}
+	if value := merged.GetState(bob, slot1); value != common.HexToHash("0xdd") {
+		t.Errorf("bob slot1 resolved by engine: got %x, want 0xdd", value)
+	}
+
+	// Cleared slots and destructed accounts are carried over
+	cleared := chain.addBlock(t, common.HexToAddress("0x04"), []common.Hash{genesis}, func(s *state.StateDB) {
+		s.SetState(bob, slot1, common.Hash{})
+		s.SelfDestruct(alice)
+	})
+	merged, err = validator.MergeParentStates(&HexHeader{
+		ParentHashes:  [6]common.Hash{cleared, right},
+		NeighborCount: 2,
+		Number:        big.NewInt(2),
+	})
+	if err != nil {
+		t.Fatalf("merge of cleared state failed: %v", err)
+	}
+	if value := merged.GetState(bob, slot1); value != (common.Hash{}) {
+		t.Errorf("cleared slot1: got %x, want empty", value)
+	}
+	if value := merged.GetState(bob, slot2); value != common.HexToHash("0xcc") {
+		t.Errorf("bob slot2 beside cleared slot: got %x, want 0xcc", value)
+	}
+	if merged.Exist(alice) {
+		t.Errorf("destructed alice still exists")
+	}
+
+	// Deleting an account conflicts with changing it
+	deleted := &HexHeader{
+		ParentHashes:  [6]common.Hash{cleared, left},
+		NeighborCount: 2,
+		Number:        big.NewInt(2),
+	}
+	_, err = NewHexBlockValidator(params.TestChainConfig, chain, testEngine{}).MergeParentStates(deleted)
+	if !errors.Is(err, ErrStateConflict) || !strings.Contains(err.Error(), alice.Hex()+".account") {
+		t.Errorf("deleted and changed account: got %v, want %v on %s", err, ErrStateConflict, alice.Hex())
+	}
+	if merged, err = validator.MergeParentStates(deleted); err != nil {
+		t.Fatalf("merge of deleted account with resolver failed: %v", err)
+	} else if balance := merged.GetBalance(alice); balance.Uint64() != 50 {
+		t.Errorf("alice balance kept over deletion: got %d, want 50", balance.Uint64())
+	}
+	deleted.ParentHashes[0], deleted.ParentHashes[1] = left, cleared
+	if merged, err = validator.MergeParentStates(deleted); err != nil {
+		t.Fatalf("merge of winning deletion failed: %v", err)
+	} else if merged.Exist(alice) {
+		t.Errorf("alice survived a winning deletion")
+	}
+}
@@ -4,6 +4,8 @@ package core
 import (
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"io"
 	"math/big"
 	"time"
 
@@ -52,6 +54,33 @@ func (h HexCoordinate) Neighbors() [6]HexCoordinate {
 	return neighbors
 }
 
+// EncodeRLP implements rlp.Encoder. RLP has no signed integers, so each cube
+// component is written as its two's complement uint64.
+func (h HexCoordinate) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, &extHexCoordinate{
+		Q: uint64(h.Q),
+		R: uint64(h.R),
+		S: uint64(h.S),
+	})
+}
+
+// DecodeRLP implements rlp.Decoder
+func (h *HexCoordinate) DecodeRLP(s *rlp.Stream) error {
+	var enc extHexCoordinate
+	if err := s.Decode(&enc); err != nil {
+		return err
+	}
+	h.Q, h.R, h.S = int64(enc.Q), int64(enc.R), int64(enc.S)
+	return nil
+}
+
+// extHexCoordinate is the wire representation of a HexCoordinate
+type extHexCoordinate struct {
+	Q uint64
+	R uint64
+	S uint64
+}
+
 // HexDirection represents the 6 directions in a hexagonal grid
 type HexDirection uint8
 
@@ -75,12 +104,13 @@ func (d HexDirection) String() string {
 
 // HexaProof contains consensus data for hexagonal validation
 type HexaProof struct {
-	NeighborSignatures [6][]byte        `json:"neighborSignatures"` // Signatures from neighbors
-	StateProof         []byte           `json:"stateProof"`         // Proof of state consistency
-	MeshProof          []byte           `json:"meshProof"`          // Proof of mesh integrity
-	Timestamp          uint64           `json:"timestamp"`          // Consensus timestamp
-	ValidatorSet       []common.Address `json:"validatorSet"`       // Active validators
-	ProofHash          common.Hash      `json:"proofHash"`          // Hash of the proof
+	NeighborSignatures [6][]byte        `json:"neighborSignatures"`      // Signatures from neighbors
+	StateProof         []byte           `json:"stateProof"`              // Proof of state consistency
+	MeshProof          []byte           `json:"meshProof"`               // Proof of mesh integrity
+	Timestamp          uint64           `json:"timestamp"`               // Consensus timestamp
+	ValidatorSet       []common.Address `json:"validatorSet"`            // Active validators
+	ProofHash          common.Hash      `json:"proofHash"`               // Hash of the proof
+	Producer           common.Address   `json:"producer" rlp:"optional"` // Validator declaring to seal the block
 }
 
 // Hash calculates the hash of the HexaProof
@@ -104,6 +134,7 @@ func (hp *HexaProof) Hash() common.Hash {
 	for _, addr := range hp.ValidatorSet {
 		hasher.Write(addr.Bytes())
 	}
+	hasher.Write(hp.Producer.Bytes())
 
 	copy(hp.ProofHash[:], hasher.Sum(nil))
 	return hp.ProofHash
@@ -134,10 +165,11 @@ type HexHeader struct {
 	Nonce       types.BlockNonce `json:"nonce"`
 
 	// EIP fields
-	BaseFee         *big.Int     `json:"baseFeePerGas,omitempty"`
-	WithdrawalsHash *common.Hash `json:"withdrawalsRoot,omitempty"`
-	BlobGasUsed     *uint64      `json:"blobGasUsed,omitempty"`
-	ExcessBlobGas   *uint64      `json:"excessBlobGas,omitempty"`
+	BaseFee         *big.Int     `json:"baseFeePerGas,omitempty" rlp:"optional"`
+	WithdrawalsHash *common.Hash `json:"withdrawalsRoot,omitempty" rlp:"optional"`
+	BlobGasUsed     *uint64      `json:"blobGasUsed,omitempty" rlp:"optional"`
+	ExcessBlobGas   *uint64      `json:"excessBlobGas,omitempty" rlp:"optional"`
+	RequestsHash    *common.Hash `json:"requestsHash,omitempty" rlp:"optional"`
 }
 
 // Hash calculates the hash of the hexagonal header
@@ -145,19 +177,23 @@ func (h *HexHeader) Hash() common.Hash {
 	return rlpHash(h)
 }
 
-// ToEthHeader converts HexHeader to standard Ethereum Header for compatibility
-func (h *HexHeader) ToEthHeader() *types.Header {
-	// Use first non-zero parent as the primary parent
-	var parentHash common.Hash
+// PrimaryParent returns the first non-zero parent hash, which is used as the
+// parent of the Ethereum-compatible header
+func (h *HexHeader) PrimaryParent() common.Hash {
 	for _, hash := range h.ParentHashes {
 		if hash != (common.Hash{}) {
-			parentHash = hash
-			break
+			return hash
 		}
 	}
+	return common.Hash{}
+}
 
+// ToEthHeader converts HexHeader to standard Ethereum Header for compatibility.
+// The hexagonal fields are packed into the Extra field and can be restored
+// with HexHeaderFromEth.
+func (h *HexHeader) ToEthHeader() *types.Header {
 	return &types.Header{
-		ParentHash:      parentHash,
+		ParentHash:      h.PrimaryParent(),
 		UncleHash:       types.EmptyUncleHash, // No uncles in hex chain
 		Coinbase:        h.Coinbase,
 		Root:            h.Root,
@@ -169,13 +205,14 @@ func (h *HexHeader) ToEthHeader() *types.Header {
 		GasLimit:        h.GasLimit,
 		GasUsed:         h.GasUsed,
 		Time:            h.Time,
-		Extra:           h.Extra,
+		Extra:           encodeHexExtra(h),
 		MixDigest:       h.MixDigest,
 		Nonce:           h.Nonce,
 		BaseFee:         h.BaseFee,
 		WithdrawalsHash: h.WithdrawalsHash,
 		BlobGasUsed:     h.BlobGasUsed,
 		ExcessBlobGas:   h.ExcessBlobGas,
+		RequestsHash:    h.RequestsHash,
 	}
 }
 
@@ -198,6 +235,24 @@ type HexBlock struct {
 	ReceivedFrom interface{}
 }
 
+// HexBody is a simple (mutable, non-safe) data container for storing and moving
+// a hexagonal block's data contents together
+type HexBody struct {
+	Transactions   []*types.Transaction
+	NeighborProofs [6][]byte
+	MeshWitness    []byte
+	Withdrawals    []*types.Withdrawal `rlp:"optional"`
+}
+
+// extHexBlock is the wire representation of a HexBlock
+type extHexBlock struct {
+	Header         *HexHeader
+	Txs            []*types.Transaction
+	NeighborProofs [6][]byte
+	MeshWitness    []byte
+	Withdrawals    []*types.Withdrawal `rlp:"optional"`
+}
+
 // NewHexBlock creates a new hexagonal block
 func NewHexBlock(header *HexHeader, txs []*types.Transaction, withdrawals []*types.Withdrawal) *HexBlock {
 	return &HexBlock{
@@ -207,6 +262,66 @@ func NewHexBlock(header *HexHeader, txs []*types.Transaction, withdrawals []*typ
 	}
 }
 
+// NewHexBlockWithBody creates a new hexagonal block from a header and a full body,
+// including the neighbor proofs and mesh witness
+func NewHexBlockWithBody(header *HexHeader, body *HexBody) *HexBlock {
+	block := &HexBlock{header: header}
+	if body != nil {
+		block.transactions = body.Transactions
+		block.withdrawals = body.Withdrawals
+		block.neighborProofs = body.NeighborProofs
+		block.meshWitness = body.MeshWitness
+	}
+	return block
+}
+
+// EncodeRLP implements rlp.Encoder, serializing the header together with the
+// full body so the block survives a network round trip
+func (b *HexBlock) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, &extHexBlock{
+		Header:         b.header,
+		Txs:            b.transactions,
+		NeighborProofs: b.neighborProofs,
+		MeshWitness:    b.meshWitness,
+		Withdrawals:    b.withdrawals,
+	})
+}
+
+// DecodeRLP implements rlp.Decoder
+func (b *HexBlock) DecodeRLP(s *rlp.Stream) error {
+	var eb extHexBlock
+	_, size, _ := s.Kind()
+	if err := s.Decode(&eb); err != nil {
+		return err
+	}
+	if eb.Header == nil {
+		return errors.New("missing hex block header")
+	}
+	b.header, b.transactions, b.withdrawals = eb.Header, eb.Txs, eb.Withdrawals
+	b.neighborProofs, b.meshWitness = eb.NeighborProofs, eb.MeshWitness
+	b.hash = common.Hash{}
+	b.size = rlp.ListSize(size)
+	return nil
+}
+
+// Body returns the non-header content of the block
+func (b *HexBlock) Body() *HexBody {
+	return &HexBody{
+		Transactions:   b.transactions,
+		NeighborProofs: b.neighborProofs,
+		MeshWitness:    b.meshWitness,
+		Withdrawals:    b.withdrawals,
+	}
+}
+
+// Size returns the RLP encoded storage size of the block
+func (b *HexBlock) Size() uint64 {
+	if b.size == 0 {
+		b.size = uint64(len(rlpEncode(b)))
+	}
+	return b.size
+}
+
 // Header returns the block header
 func (b *HexBlock) Header() *HexHeader {
 	return b.header
@@ -235,6 +350,16 @@ func (b *HexBlock) Withdrawals() []*types.Withdrawal {
 	return b.withdrawals
 }
 
+// NeighborProofs returns the proofs supplied by the neighboring blocks
+func (b *HexBlock) NeighborProofs() [6][]byte {
+	return b.neighborProofs
+}
+
+// MeshWitness returns the witness data for mesh validation
+func (b *HexBlock) MeshWitness() []byte {
+	return b.meshWitness
+}
+
 // ParentHashes returns all parent hashes
 func (b *HexBlock) ParentHashes() [6]common.Hash {
 	return b.header.ParentHashes
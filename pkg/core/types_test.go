@@ -1,12 +1,15 @@
 package core
 
 import (
+	"bytes"
+	"errors"
 	"math/big"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 func TestHexCoordinate(t *testing.T) {
@@ -134,6 +137,65 @@ func TestHexHeader(t *testing.T) {
 	}
 }
 
+func TestHexHeaderEthCodec(t *testing.T) {
+	baseFee := big.NewInt(7)
+	header := &HexHeader{
+		ParentHashes: [6]common.Hash{
+			{}, common.HexToHash("0x02"), {}, common.HexToHash("0x04"), {}, common.HexToHash("0x06"),
+		},
+		NeighborCount: 3,
+		HexPosition:   NewHexCoordinate(-2, 5),
+		MeshRoot:      common.HexToHash("0xabcd"),
+		HexProof: HexaProof{
+			NeighborSignatures: [6][]byte{nil, []byte("sig1"), nil, []byte("sig3"), nil, []byte("sig5")},
+			StateProof:         []byte("stateproof"),
+			Timestamp:          1700000001,
+			ValidatorSet:       []common.Address{common.HexToAddress("0x1234")},
+		},
+		Coinbase:   common.HexToAddress("0x5678"),
+		Root:       common.HexToHash("0xef01"),
+		Difficulty: big.NewInt(3),
+		Number:     big.NewInt(9),
+		GasLimit:   5000000,
+		Time:       1700000000,
+		Extra:      []byte("user extra"),
+		BaseFee:    baseFee,
+	}
+
+	ethHeader := header.ToEthHeader()
+	if ethHeader.ParentHash != common.HexToHash("0x02") {
+		t.Errorf("primary parent mismatch: got %x", ethHeader.ParentHash)
+	}
+
+	restored, err := HexHeaderFromEth(ethHeader)
+	if err != nil {
+		t.Fatalf("failed to restore hex header: %v", err)
+	}
+	if restored.Hash() != header.Hash() {
+		t.Errorf("hash mismatch after codec round trip: got %x, want %x", restored.Hash(), header.Hash())
+	}
+	if restored.ParentHashes != header.ParentHashes {
+		t.Error("parent hashes not restored")
+	}
+	if restored.HexPosition != header.HexPosition || restored.NeighborCount != header.NeighborCount {
+		t.Error("hex position or neighbor count not restored")
+	}
+	if !bytes.Equal(restored.Extra, header.Extra) {
+		t.Errorf("user extra not restored: got %q", restored.Extra)
+	}
+
+	// Headers without the hexagonal suffix are rejected
+	if _, err := HexHeaderFromEth(&types.Header{Number: big.NewInt(1), Extra: []byte("plain")}); err != ErrMissingHexExtra {
+		t.Errorf("expected ErrMissingHexExtra, got %v", err)
+	}
+
+	// Tampering with the primary parent is detected
+	ethHeader.ParentHash = common.HexToHash("0x04")
+	if _, err := HexHeaderFromEth(ethHeader); !errors.Is(err, ErrPrimaryParent) {
+		t.Errorf("expected ErrPrimaryParent, got %v", err)
+	}
+}
+
 func TestHexBlock(t *testing.T) {
 	// Create test header
 	header := &HexHeader{
@@ -270,6 +332,86 @@ func TestHexBlockWithTransactions(t *testing.T) {
 	}
 }
 
+func TestHexBlockRLP(t *testing.T) {
+	tx := types.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(1000), 21000, big.NewInt(1000000000), nil)
+	withdrawalsHash := common.HexToHash("0x77")
+
+	header := &HexHeader{
+		ParentHashes: [6]common.Hash{
+			common.HexToHash("0x01"), {}, common.HexToHash("0x03"),
+		},
+		NeighborCount: 2,
+		HexPosition:   NewHexCoordinate(-3, 2),
+		MeshRoot:      common.HexToHash("0xabcd"),
+		HexProof: HexaProof{
+			NeighborSignatures: [6][]byte{[]byte("sig0"), nil, []byte("sig2")},
+			Timestamp:          1700000000,
+			ValidatorSet:       []common.Address{common.HexToAddress("0x1234")},
+		},
+		Difficulty:      big.NewInt(1),
+		Number:          big.NewInt(7),
+		GasLimit:        5000000,
+		GasUsed:         21000,
+		Time:            1700000000,
+		Extra:           []byte("rlp test"),
+		WithdrawalsHash: &withdrawalsHash,
+	}
+	block := NewHexBlockWithBody(header, &HexBody{
+		Transactions:   []*types.Transaction{tx},
+		NeighborProofs: [6][]byte{[]byte("proof0"), nil, []byte("proof2")},
+		MeshWitness:    []byte("witness"),
+		Withdrawals:    []*types.Withdrawal{{Index: 1, Validator: 2, Address: common.HexToAddress("0x99"), Amount: 3}},
+	})
+
+	enc, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	var decoded HexBlock
+	if err := rlp.DecodeBytes(enc, &decoded); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+
+	if decoded.Hash() != block.Hash() {
+		t.Errorf("hash mismatch after round trip: got %x, want %x", decoded.Hash(), block.Hash())
+	}
+	reenc, err := rlp.EncodeToBytes(&decoded)
+	if err != nil {
+		t.Fatalf("re-encode failed: %v", err)
+	}
+	if !bytes.Equal(enc, reenc) {
+		t.Error("re-encoded block differs from the original encoding")
+	}
+	if decoded.HexPosition() != header.HexPosition {
+		t.Errorf("position mismatch: got %+v, want %+v", decoded.HexPosition(), header.HexPosition)
+	}
+	for i, proof := range block.NeighborProofs() {
+		if !bytes.Equal(decoded.NeighborProofs()[i], proof) {
+			t.Errorf("neighbor proof %d lost in round trip", i)
+		}
+	}
+	if !bytes.Equal(decoded.MeshWitness(), []byte("witness")) {
+		t.Error("mesh witness lost in round trip")
+	}
+	if len(decoded.Transactions()) != 1 || decoded.Transactions()[0].Hash() != tx.Hash() {
+		t.Error("transactions lost in round trip")
+	}
+	if len(decoded.Withdrawals()) != 1 || decoded.Withdrawals()[0].Amount != 3 {
+		t.Error("withdrawals lost in round trip")
+	}
+	if decoded.Size() != uint64(len(enc)) {
+		t.Errorf("size mismatch: got %d, want %d", decoded.Size(), len(enc))
+	}
+
+	// Distinct positions must yield distinct header hashes
+	other := *header
+	other.HexPosition = NewHexCoordinate(3, -2)
+	if other.Hash() == header.Hash() {
+		t.Error("headers at different positions share a hash")
+	}
+}
+
 // Benchmark tests
 func BenchmarkHexCoordinateDistance(b *testing.B) {
 	coord1 := NewHexCoordinate(0, 0)
@@ -4,11 +4,14 @@ package core
 import (
 	"errors"
 	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus"
+	gethcore "github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/trie"
 )
@@ -19,13 +22,34 @@ var (
 	ErrParentNotFound  = errors.New("parent block not found")
 	ErrStateConflict   = errors.New("conflicting states from parents")
 	ErrInvalidProof    = errors.New("invalid hexagonal proof")
+	ErrMisplacedParent = errors.New("parent not in the neighbor cell of its slot")
 )
 
 // HexBlockValidator validates hexagonal blocks with multiple parents
 type HexBlockValidator struct {
-	config *params.ChainConfig // Chain configuration
-	bc     HexBlockChain       // Hexagonal blockchain interface
-	engine consensus.Engine    // Consensus engine
+	config   *params.ChainConfig   // Chain configuration
+	bc       HexBlockChain         // Hexagonal blockchain interface
+	engine   consensus.Engine      // Consensus engine
+	resolver StateConflictResolver // Resolver for conflicting parent state writes
+	reach    *ReachabilityIndex    // Ancestry queries between parents
+
+	strictPlacement bool // Whether parent slot d must hold the neighbor in direction d
+}
+
+// ValidatorReader is implemented by consensus engines tracking the validator
+// set, which neighbor signatures are checked against
+type ValidatorReader interface {
+	// Validators returns the validators authorized to produce children of the
+	// given block, in ascending order
+	Validators(chain consensus.ChainHeaderReader, hash common.Hash) ([]common.Address, error)
+}
+
+// PlacementEngine is implemented by consensus engines prescribing the parent
+// placement rule of the chain, which the validator adopts
+type PlacementEngine interface {
+	// StrictPlacement reports whether parent slot d must hold the neighbor in
+	// direction d
+	StrictPlacement() bool
 }
 
 // HexBlockChain interface for hexagonal blockchain operations
@@ -50,13 +74,23 @@ type HexBlockChain interface {
 	GetStateByNumber(number uint64) (*state.StateDB, error)
 }
 
-// NewHexBlockValidator creates a new hexagonal block validator
+// NewHexBlockValidator creates a new hexagonal block validator, settling
+// conflicting parent writes with the resolver of the engine if it has one and
+// following its placement rule
 func NewHexBlockValidator(config *params.ChainConfig, blockchain HexBlockChain, engine consensus.Engine) *HexBlockValidator {
-	return &HexBlockValidator{
+	v := &HexBlockValidator{
 		config: config,
 		bc:     blockchain,
 		engine: engine,
+		reach:  NewReachabilityIndex(),
+	}
+	if e, ok := engine.(ResolverEngine); ok {
+		v.resolver = e.StateConflictResolver()
 	}
+	if e, ok := engine.(PlacementEngine); ok {
+		v.strictPlacement = e.StrictPlacement()
+	}
+	return v
 }
 
 // ValidateHexBlock validates a complete hexagonal block
@@ -78,7 +112,7 @@ func (v *HexBlockValidator) ValidateHexBlock(block *HexBlock) error {
 
 	// 4. Validate state transitions from all parents
 	if err := v.ValidateStateTransitions(block); err != nil {
-		return fmt.Errorf("state transition validation failed: %v", err)
+		return fmt.Errorf("state transition validation failed: %w", err)
 	}
 
 	// 5. Validate mesh integrity
@@ -201,29 +235,14 @@ func (v *HexBlockValidator) ValidateHexBody(block *HexBlock) error {
 	return nil
 }
 
-// ValidateStateTransitions validates state transitions from all parent blocks
+// ValidateStateTransitions validates state transitions from all parent blocks.
+// The parent states are merged into the block's pre-state, the block is
+// executed on the merged state and the results, including the state root, are
+// checked against the header.
 func (v *HexBlockValidator) ValidateStateTransitions(block *HexBlock) error {
 	header := block.Header()
 
-	// Get states from all parent blocks
-	parentStates := make([]*state.StateDB, 0, header.NeighborCount)
-
-	for _, parentHash := range header.ParentHashes {
-		if parentHash == (common.Hash{}) {
-			continue
-		}
-
-		parentState, err := v.bc.GetState(parentHash)
-		if err != nil {
-			return fmt.Errorf("failed to get parent state %x: %v", parentHash, err)
-		}
-
-		parentStates = append(parentStates, parentState)
-	}
-
-	// For now, we'll use the first parent's state as the base
-	// In a full implementation, we'd need sophisticated state merging
-	if len(parentStates) == 0 {
+	if header.NeighborCount == 0 {
 		// Genesis block case
 		if header.Number.Uint64() != 0 {
 			return errors.New("non-genesis block must have parent states")
@@ -231,19 +250,16 @@ func (v *HexBlockValidator) ValidateStateTransitions(block *HexBlock) error {
 		return nil
 	}
 
-	// Use the first parent state as base (simplified approach)
-	baseState := parentStates[0].Copy()
-
-	// TODO: Implement proper multi-parent state merging
-	// This would involve:
-	// 1. Detecting conflicts between parent states
-	// 2. Applying conflict resolution rules
-	// 3. Merging non-conflicting state changes
-	// 4. Validating the final state against header.Root
-
-	_ = baseState // Suppress unused variable warning for now
+	merged, err := v.MergeParentStates(header)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	result, err := v.ProcessHexBlock(block, merged)
+	if err != nil {
+		return err
+	}
+	return v.ValidateProcessedHexBlock(block, result, merged)
 }
 
 // ValidateMeshIntegrity validates the mesh topology integrity
@@ -259,16 +275,11 @@ func (v *HexBlockValidator) ValidateMeshIntegrity(block *HexBlock) error {
 		if parentHash == block.Hash() {
 			return errors.New("block cannot reference itself")
 		}
+	}
 
-		// Check if parent references this block (circular dependency)
-		parentBlock := v.bc.GetHexBlock(parentHash)
-		if parentBlock != nil {
-			for _, grandParentHash := range parentBlock.ParentHashes() {
-				if grandParentHash == block.Hash() {
-					return errors.New("circular reference detected")
-				}
-			}
-		}
+	// Parents must be distinct and none may precede another
+	if err := v.reach.ValidateParents(v.bc.GetHexHeader, header.ParentHashes); err != nil {
+		return err
 	}
 
 	// Validate mesh topology constraints
@@ -292,6 +303,24 @@ func (v *HexBlockValidator) ValidateMeshIntegrity(block *HexBlock) error {
 	return nil
 }
 
+// SetStrictPlacement selects strict or loose parent placement. Strict placement
+// requires the parent in slot d to occupy the neighbor cell in HexDirection d,
+// loose placement accepts parents in any neighbor cell.
+func (v *HexBlockValidator) SetStrictPlacement(strict bool) {
+	v.strictPlacement = strict
+}
+
+// CheckParentPlacement verifies that the parent referenced in slot dir occupies
+// the neighbor cell of the header in that direction
+func CheckParentPlacement(header *HexHeader, dir HexDirection, parent *HexHeader) error {
+	want := header.HexPosition.Neighbors()[dir]
+	if have := parent.HexPosition; have != want {
+		return fmt.Errorf("%w: %s parent at (%d,%d,%d), want (%d,%d,%d)", ErrMisplacedParent, dir,
+			have.Q, have.R, have.S, want.Q, want.R, want.S)
+	}
+	return nil
+}
+
 // validateMeshTopology validates the mesh topology rules
 func (v *HexBlockValidator) validateMeshTopology(block *HexBlock) error {
 	header := block.Header()
@@ -304,21 +333,28 @@ func (v *HexBlockValidator) validateMeshTopology(block *HexBlock) error {
 	}
 
 	// Check that all parents are in valid neighbor positions
-	for _, parentHash := range header.ParentHashes {
+	for i, parentHash := range header.ParentHashes {
 		if parentHash == (common.Hash{}) {
 			continue
 		}
 
-		parentBlock := v.bc.GetHexBlock(parentHash)
-		if parentBlock != nil {
-			parentPos := parentBlock.HexPosition()
-
-			// Check if parent is in a valid neighbor position
-			if !validNeighborMap[parentPos] {
-				return fmt.Errorf("parent at invalid neighbor position: parent at (%d,%d,%d), not a neighbor of (%d,%d,%d)",
-					parentPos.Q, parentPos.R, parentPos.S,
-					header.HexPosition.Q, header.HexPosition.R, header.HexPosition.S)
+		parent := v.bc.GetHexHeader(parentHash)
+		if parent == nil {
+			return fmt.Errorf("%w: %x", ErrParentNotFound, parentHash)
+		}
+		if v.strictPlacement {
+			if err := CheckParentPlacement(header, HexDirection(i), parent); err != nil {
+				return err
 			}
+			continue
+		}
+		parentPos := parent.HexPosition
+
+		// Check if parent is in a valid neighbor position
+		if !validNeighborMap[parentPos] {
+			return fmt.Errorf("parent at invalid neighbor position: parent at (%d,%d,%d), not a neighbor of (%d,%d,%d)",
+				parentPos.Q, parentPos.R, parentPos.S,
+				header.HexPosition.Q, header.HexPosition.R, header.HexPosition.S)
 		}
 	}
 
@@ -339,26 +375,66 @@ func (v *HexBlockValidator) ValidateHexProof(block *HexBlock) error {
 		return errors.New("proof timestamp before block timestamp")
 	}
 
-	// Count valid signatures
-	validSigs := 0
-	for _, sig := range proof.NeighborSignatures {
-		if len(sig) > 0 {
-			validSigs++
+	// Verify each neighbor signature against the producer of its parent
+	parentSigner := func(hash common.Hash) (common.Address, error) {
+		parent := v.bc.GetHexHeader(hash)
+		if parent == nil {
+			return common.Address{}, ErrParentNotFound
 		}
+		return v.engine.Author(parent.ToEthHeader())
 	}
-
-	// Must have signatures from all neighbors
-	if validSigs < int(header.NeighborCount) {
-		return fmt.Errorf("insufficient signatures: got %d, need %d", validSigs, header.NeighborCount)
+	recoverSigner := func(dir HexDirection) (common.Address, error) {
+		return RecoverSigner(NeighborSigningHash(header, dir), proof.NeighborSignatures[dir])
+	}
+	active, err := v.activeValidators(header)
+	if err != nil {
+		return err
+	}
+	if err := VerifyNeighborSignatures(header, active, parentSigner, recoverSigner); err != nil {
+		return err
 	}
 
-	// TODO: Validate cryptographic signatures
 	// TODO: Validate state proof
 	// TODO: Validate mesh proof
 
 	return nil
 }
 
+// activeValidators returns the validators the engine authorizes to produce the
+// header, taken from its primary parent. The genesis set is fixed by the
+// genesis hash itself.
+func (v *HexBlockValidator) activeValidators(header *HexHeader) ([]common.Address, error) {
+	if header.Number.Sign() == 0 {
+		return header.HexProof.ValidatorSet, nil
+	}
+	reader, ok := v.engine.(ValidatorReader)
+	if !ok {
+		return nil, fmt.Errorf("%w: engine tracks no validator set", ErrInvalidProof)
+	}
+	parent := v.bc.GetHeaderByHash(header.PrimaryParent())
+	if parent == nil {
+		return nil, fmt.Errorf("%w: %x", ErrParentNotFound, header.PrimaryParent())
+	}
+	return reader.Validators(v.bc, parent.Hash())
+}
+
+// skippedReceipt creates the receipt of a duplicate transaction that was not
+// executed: it failed and consumed no gas
+func skippedReceipt(tx *types.Transaction, index int, cumulativeGas uint64, blockHash common.Hash, blockNumber *big.Int) *types.Receipt {
+	receipt := &types.Receipt{
+		Type:              tx.Type(),
+		Status:            types.ReceiptStatusFailed,
+		CumulativeGasUsed: cumulativeGas,
+		Logs:              []*types.Log{},
+		TxHash:            tx.Hash(),
+		BlockHash:         blockHash,
+		BlockNumber:       blockNumber,
+		TransactionIndex:  uint(index),
+	}
+	receipt.Bloom = types.CreateBloom(receipt)
+	return receipt
+}
+
 // ProcessHexResult represents the result of processing a hexagonal block
 type ProcessHexResult struct {
 	GasUsed  uint64
@@ -367,50 +443,118 @@ type ProcessHexResult struct {
 	Logs     []*types.Log
 }
 
-// ProcessHexBlock processes a hexagonal block and returns the results
+// ProcessHexBlock processes a hexagonal block on top of its merged parent
+// state, running every transaction through the EVM and returning the results.
+// The statedb is modified in place.
 func (v *HexBlockValidator) ProcessHexBlock(block *HexBlock, statedb *state.StateDB) (*ProcessHexResult, error) {
-	// Convert to standard block for processing
-	ethBlock := block.ToEthBlock()
-
-	// Process transactions (simplified - would need proper multi-parent processing)
 	var (
-		receipts []*types.Receipt
-		gasUsed  uint64
-		allLogs  []*types.Log
+		receipts    []*types.Receipt
+		usedGas     = new(uint64)
+		allLogs     []*types.Log
+		header      = block.Header().ToEthHeader()
+		blockHash   = block.Hash()
+		blockNumber = block.Number()
+		gp          = new(gethcore.GasPool).AddGas(header.GasLimit)
+		signer      = types.MakeSigner(v.config, header.Number, header.Time)
+		chain       = &hexChainContext{bc: v.bc, engine: v.engine}
 	)
 
+	// Apply pre-execution system calls
+	context := gethcore.NewEVMBlockContext(header, chain, nil)
+	evm := vm.NewEVM(context, statedb, v.config, vm.Config{})
+
+	if v.config.IsPrague(header.Number, header.Time) {
+		gethcore.ProcessParentBlockHash(header.ParentHash, evm)
+	}
+
+	// Linearize the block after the concurrent blocks merged into its pre-state,
+	// so transactions they already included are skipped
+	concurrent, err := v.concurrentBlocks(block.Header())
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect concurrent blocks: %w", err)
+	}
+	duplicates := make(map[int]bool)
+	for _, entry := range LinearizeTransactions(append(concurrent, block)) {
+		if entry.Block == blockHash && entry.Duplicate {
+			duplicates[entry.Index] = true
+		}
+	}
+
 	// Process each transaction
-	for i, tx := range ethBlock.Transactions() {
-		// TODO: Implement proper transaction processing with multi-parent state
-		// For now, we'll just validate the transaction structure
-
-		receipt := &types.Receipt{
-			Type:              tx.Type(),
-			PostState:         nil, // Only for pre-Byzantium blocks
-			Status:            types.ReceiptStatusSuccessful,
-			CumulativeGasUsed: gasUsed + tx.Gas(),
-			Bloom:             types.Bloom{},
-			Logs:              []*types.Log{},
-			TxHash:            tx.Hash(),
-			ContractAddress:   common.Address{},
-			GasUsed:           tx.Gas(),
-			BlockHash:         block.Hash(),
-			BlockNumber:       block.Number(),
-			TransactionIndex:  uint(i),
+	for i, tx := range block.Transactions() {
+		if duplicates[i] {
+			receipts = append(receipts, skippedReceipt(tx, i, *usedGas, blockHash, blockNumber))
+			continue
 		}
+		msg, err := gethcore.TransactionToMessage(tx, signer, header.BaseFee)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+		}
+		statedb.SetTxContext(tx.Hash(), i)
 
+		receipt, err := gethcore.ApplyTransactionWithEVM(msg, gp, statedb, blockNumber, blockHash, tx, usedGas, evm)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+		}
 		receipts = append(receipts, receipt)
-		gasUsed += tx.Gas()
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+
+	// Collect EIP-7685 requests if Prague is enabled
+	var requests [][]byte
+	if v.config.IsPrague(header.Number, header.Time) {
+		requests = [][]byte{}
+		// EIP-6110
+		if err := gethcore.ParseDepositLogs(&requests, allLogs, v.config); err != nil {
+			return nil, err
+		}
+		// EIP-7002
+		if err := gethcore.ProcessWithdrawalQueue(&requests, evm); err != nil {
+			return nil, err
+		}
+		// EIP-7251
+		if err := gethcore.ProcessConsolidationQueue(&requests, evm); err != nil {
+			return nil, err
+		}
 	}
 
+	// Apply consensus engine specific extras (e.g. block rewards)
+	v.engine.Finalize(v.bc, header, statedb, &types.Body{
+		Transactions: block.Transactions(),
+		Withdrawals:  block.Withdrawals(),
+	})
+
 	return &ProcessHexResult{
-		GasUsed:  gasUsed,
+		GasUsed:  *usedGas,
 		Receipts: receipts,
-		Requests: nil, // TODO: Handle requests
+		Requests: requests,
 		Logs:     allLogs,
 	}, nil
 }
 
+// hexChainContext adapts the hexagonal chain to the EVM's chain context. Block
+// hash lookups (BLOCKHASH) follow the primary parent lineage of the mesh.
+type hexChainContext struct {
+	bc     HexBlockChain
+	engine consensus.Engine
+}
+
+// Engine implements core.ChainContext
+func (c *hexChainContext) Engine() consensus.Engine {
+	return c.engine
+}
+
+// GetHeader implements core.ChainContext. Primary parents may skip block
+// numbers, so headers are resolved by hash alone.
+func (c *hexChainContext) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return c.bc.GetHeaderByHash(hash)
+}
+
+// Config implements core.ChainContext
+func (c *hexChainContext) Config() *params.ChainConfig {
+	return c.bc.Config()
+}
+
 // ValidateProcessedHexBlock validates the processed results against the block
 func (v *HexBlockValidator) ValidateProcessedHexBlock(block *HexBlock, result *ProcessHexResult, statedb *state.StateDB) error {
 	header := block.Header()
@@ -420,13 +564,27 @@ func (v *HexBlockValidator) ValidateProcessedHexBlock(block *HexBlock, result *P
 		return fmt.Errorf("gas used mismatch: got %d, want %d", result.GasUsed, header.GasUsed)
 	}
 
-	// Validate receipts root
+	// Validate the bloom filter
 	receiptsList := types.Receipts(result.Receipts)
+	if bloom := types.MergeBloom(receiptsList); bloom != header.Bloom {
+		return fmt.Errorf("invalid bloom: got %x, want %x", bloom, header.Bloom)
+	}
+
+	// Validate receipts root
 	receiptHash := types.DeriveSha(receiptsList, trie.NewStackTrie(nil))
 	if receiptHash != header.ReceiptHash {
 		return fmt.Errorf("receipt root mismatch: got %x, want %x", receiptHash, header.ReceiptHash)
 	}
 
+	// Validate the EIP-7685 requests hash
+	if header.RequestsHash != nil {
+		if requestsHash := types.CalcRequestsHash(result.Requests); requestsHash != *header.RequestsHash {
+			return fmt.Errorf("requests hash mismatch: got %x, want %x", requestsHash, *header.RequestsHash)
+		}
+	} else if result.Requests != nil {
+		return errors.New("block has requests before prague fork")
+	}
+
 	// Validate state root
 	if statedb != nil {
 		stateRoot := statedb.IntermediateRoot(v.config.IsEIP158(header.Number))
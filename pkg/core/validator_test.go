@@ -0,0 +1,385 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
+)
+
+func TestProcessHexBlock(t *testing.T) {
+	var (
+		key, _    = crypto.GenerateKey()
+		sender    = crypto.PubkeyToAddress(key.PublicKey)
+		recipient = common.HexToAddress("0xbeef")
+		coinbase  = common.HexToAddress("0xc0ffee")
+		config    = params.TestChainConfig
+	)
+	chain := newTestChain()
+	validator := NewHexBlockValidator(config, chain, testEngine{})
+
+	genesis := chain.addBlock(t, common.Address{}, nil, func(s *state.StateDB) {
+		s.SetBalance(sender, uint256.NewInt(params.Ether), tracing.BalanceChangeUnspecified)
+	})
+
+	signer := types.LatestSigner(config)
+	tx := types.MustSignNewTx(key, signer, &types.LegacyTx{
+		Nonce:    0,
+		To:       &recipient,
+		Value:    big.NewInt(1000),
+		Gas:      params.TxGas,
+		GasPrice: big.NewInt(params.GWei),
+	})
+	header := &HexHeader{
+		ParentHashes:  [6]common.Hash{genesis},
+		NeighborCount: 1,
+		Coinbase:      coinbase,
+		Difficulty:    big.NewInt(1),
+		Number:        big.NewInt(1),
+		GasLimit:      5000000,
+		Time:          1,
+		BaseFee:       big.NewInt(params.InitialBaseFee),
+		TxHash:        types.DeriveSha(types.Transactions{tx}, trie.NewStackTrie(nil)),
+	}
+	block := NewHexBlock(header, []*types.Transaction{tx}, nil)
+
+	statedb, err := validator.MergeParentStates(header)
+	if err != nil {
+		t.Fatalf("failed to build pre-state: %v", err)
+	}
+	result, err := validator.ProcessHexBlock(block, statedb)
+	if err != nil {
+		t.Fatalf("failed to process block: %v", err)
+	}
+
+	if result.GasUsed != params.TxGas {
+		t.Errorf("gas used: got %d, want %d", result.GasUsed, params.TxGas)
+	}
+	if len(result.Receipts) != 1 || result.Receipts[0].Status != types.ReceiptStatusSuccessful {
+		t.Fatal("expected one successful receipt")
+	}
+	if receipt := result.Receipts[0]; receipt.BlockHash != block.Hash() || receipt.CumulativeGasUsed != params.TxGas {
+		t.Errorf("unexpected receipt: block %x, cumulative gas %d", receipt.BlockHash, receipt.CumulativeGasUsed)
+	}
+	if balance := statedb.GetBalance(recipient); balance.Uint64() != 1000 {
+		t.Errorf("recipient balance: got %d, want 1000", balance.Uint64())
+	}
+	if nonce := statedb.GetNonce(sender); nonce != 1 {
+		t.Errorf("sender nonce: got %d, want 1", nonce)
+	}
+
+	// A header claiming different gas usage is rejected
+	header.GasUsed = params.TxGas + 1
+	header.ReceiptHash = types.DeriveSha(types.Receipts(result.Receipts), trie.NewStackTrie(nil))
+	header.Root = statedb.IntermediateRoot(true)
+	if err := validator.ValidateProcessedHexBlock(block, result, statedb); err == nil {
+		t.Error("expected gas used mismatch")
+	}
+	header.GasUsed = params.TxGas
+	if err := validator.ValidateProcessedHexBlock(block, result, statedb); err != nil {
+		t.Errorf("valid block rejected: %v", err)
+	}
+
+	// Requests must match the header commitment
+	header.RequestsHash = &common.Hash{0x01}
+	if err := validator.ValidateProcessedHexBlock(block, result, statedb); err == nil {
+		t.Error("expected requests hash mismatch")
+	}
+	header.RequestsHash = nil
+	result.Requests = [][]byte{}
+	if err := validator.ValidateProcessedHexBlock(block, result, statedb); err == nil {
+		t.Error("expected uncommitted requests to be rejected")
+	}
+	requestsHash := types.CalcRequestsHash(result.Requests)
+	header.RequestsHash = &requestsHash
+	if err := validator.ValidateProcessedHexBlock(block, result, statedb); err != nil {
+		t.Errorf("block with matching requests rejected: %v", err)
+	}
+}
+
+func TestValidateHexProof(t *testing.T) {
+	var (
+		keyA, _  = crypto.GenerateKey()
+		keyB, _  = crypto.GenerateKey()
+		outer, _ = crypto.GenerateKey()
+		addrA    = crypto.PubkeyToAddress(keyA.PublicKey)
+		addrB    = crypto.PubkeyToAddress(keyB.PublicKey)
+	)
+	if bytes.Compare(addrA[:], addrB[:]) > 0 {
+		keyA, keyB, addrA, addrB = keyB, keyA, addrB, addrA
+	}
+	chain := newTestChain()
+
+	parentA := chain.addBlock(t, addrA, nil, func(*state.StateDB) {})
+	parentB := chain.addBlock(t, addrB, nil, func(*state.StateDB) {})
+
+	newHeader := func() *HexHeader {
+		return &HexHeader{
+			ParentHashes:  [6]common.Hash{HexEast: parentA, HexWest: parentB},
+			NeighborCount: 2,
+			Difficulty:    big.NewInt(1),
+			Number:        big.NewInt(1),
+			Time:          10,
+			HexProof: HexaProof{
+				ValidatorSet: []common.Address{addrA, addrB},
+				Timestamp:    10,
+			},
+		}
+	}
+	sign := func(header *HexHeader, dir HexDirection, key *ecdsa.PrivateKey) {
+		sig, err := crypto.Sign(NeighborSigningHash(header, dir).Bytes(), key)
+		if err != nil {
+			t.Fatalf("failed to sign %s slot: %v", dir, err)
+		}
+		header.HexProof.NeighborSignatures[dir] = sig
+	}
+
+	tests := []struct {
+		name   string
+		active []common.Address // Validators authorized by the engine, both if nil
+		setup  func(h *HexHeader)
+		valid  bool
+	}{
+		{"valid", nil, func(h *HexHeader) {
+			sign(h, HexEast, keyA)
+			sign(h, HexWest, keyB)
+		}, true},
+		{"missing signature", nil, func(h *HexHeader) {
+			sign(h, HexEast, keyA)
+		}, false},
+		{"wrong signer", nil, func(h *HexHeader) {
+			sign(h, HexEast, keyB)
+			sign(h, HexWest, keyB)
+		}, false},
+		{"signer outside validator set", []common.Address{addrA}, func(h *HexHeader) {
+			h.HexProof.ValidatorSet = []common.Address{addrA}
+			sign(h, HexEast, keyA)
+			sign(h, HexWest, keyB)
+		}, false},
+		{"self-declared validator set", []common.Address{addrA}, func(h *HexHeader) {
+			sign(h, HexEast, keyA)
+			sign(h, HexWest, keyB)
+		}, false},
+		{"shrunk validator set", nil, func(h *HexHeader) {
+			h.HexProof.ValidatorSet = []common.Address{addrA}
+			sign(h, HexEast, keyA)
+			sign(h, HexWest, keyB)
+		}, false},
+		{"signature in empty slot", nil, func(h *HexHeader) {
+			sign(h, HexEast, keyA)
+			sign(h, HexWest, keyB)
+			sign(h, HexNorthEast, outer)
+		}, false},
+		{"malformed signature", nil, func(h *HexHeader) {
+			sign(h, HexEast, keyA)
+			h.HexProof.NeighborSignatures[HexWest] = []byte{0x01}
+		}, false},
+		{"tampered header", nil, func(h *HexHeader) {
+			sign(h, HexEast, keyA)
+			sign(h, HexWest, keyB)
+			h.Time++
+			h.HexProof.Timestamp++
+		}, false},
+	}
+	for _, tt := range tests {
+		header := newHeader()
+		tt.setup(header)
+
+		active := tt.active
+		if active == nil {
+			active = []common.Address{addrA, addrB}
+		}
+		validator := NewHexBlockValidator(params.TestChainConfig, chain, testEngine{validators: active})
+		err := validator.ValidateHexProof(NewHexBlock(header, nil, nil))
+		if tt.valid && err != nil {
+			t.Errorf("%s: valid proof rejected: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: invalid proof accepted", tt.name)
+		}
+	}
+}
+
+func TestProcessHexBlockDuplicates(t *testing.T) {
+	var (
+		key, _    = crypto.GenerateKey()
+		sender    = crypto.PubkeyToAddress(key.PublicKey)
+		recipient = common.HexToAddress("0xbeef")
+		config    = params.TestChainConfig
+	)
+	chain := newTestChain()
+	validator := NewHexBlockValidator(config, chain, testEngine{})
+
+	genesis := chain.addBlock(t, common.Address{}, nil, func(s *state.StateDB) {
+		s.SetBalance(sender, uint256.NewInt(params.Ether), tracing.BalanceChangeUnspecified)
+	})
+	tx := types.MustSignNewTx(key, types.LatestSigner(config), &types.LegacyTx{
+		To:       &recipient,
+		Value:    big.NewInt(1000),
+		Gas:      params.TxGas,
+		GasPrice: big.NewInt(params.GWei),
+	})
+	header := &HexHeader{
+		ParentHashes:  [6]common.Hash{genesis},
+		NeighborCount: 1,
+		Coinbase:      common.HexToAddress("0xc0ffee"),
+		Difficulty:    big.NewInt(1),
+		Number:        big.NewInt(1),
+		GasLimit:      5000000,
+		Time:          1,
+		BaseFee:       big.NewInt(params.InitialBaseFee),
+	}
+	block := NewHexBlock(header, []*types.Transaction{tx, tx}, nil)
+
+	statedb, err := validator.MergeParentStates(header)
+	if err != nil {
+		t.Fatalf("failed to build pre-state: %v", err)
+	}
+	result, err := validator.ProcessHexBlock(block, statedb)
+	if err != nil {
+		t.Fatalf("duplicate transaction rejected the block: %v", err)
+	}
+	if len(result.Receipts) != 2 {
+		t.Fatalf("receipts: got %d, want 2", len(result.Receipts))
+	}
+	skipped := result.Receipts[1]
+	if skipped.Status != types.ReceiptStatusFailed || skipped.GasUsed != 0 || skipped.CumulativeGasUsed != params.TxGas {
+		t.Errorf("skipped receipt: status %d, gas %d, cumulative gas %d", skipped.Status, skipped.GasUsed, skipped.CumulativeGasUsed)
+	}
+	if balance := statedb.GetBalance(recipient); balance.Uint64() != 1000 {
+		t.Errorf("recipient balance: got %d, want 1000", balance.Uint64())
+	}
+
+	// Sibling parents both including the transaction merge a single execution
+	// of it, and a child repeating it skips it as well
+	sibling := func(coinbase common.Address) *HexHeader {
+		header := &HexHeader{
+			ParentHashes:  [6]common.Hash{genesis},
+			NeighborCount: 1,
+			Coinbase:      coinbase,
+			Difficulty:    big.NewInt(1),
+			Number:        big.NewInt(1),
+			GasLimit:      5000000,
+			Time:          1,
+			BaseFee:       big.NewInt(params.InitialBaseFee),
+		}
+		return chain.addHexBlock(t, validator, header, []*types.Transaction{tx}).Header()
+	}
+	east, west := sibling(common.HexToAddress("0xea57")), sibling(common.HexToAddress("0x3e57"))
+	child := &HexHeader{
+		ParentHashes:  [6]common.Hash{east.Hash(), west.Hash()},
+		NeighborCount: 2,
+		Coinbase:      common.HexToAddress("0xc0ffee"),
+		Difficulty:    big.NewInt(1),
+		Number:        big.NewInt(2),
+		GasLimit:      5000000,
+		Time:          2,
+		BaseFee:       big.NewInt(params.InitialBaseFee),
+	}
+	concurrent, err := validator.concurrentBlocks(child)
+	if err != nil {
+		t.Fatalf("failed to collect concurrent blocks: %v", err)
+	}
+	if len(concurrent) != 2 {
+		t.Fatalf("concurrent blocks: got %d, want both siblings", len(concurrent))
+	}
+	statedb, err = validator.MergeParentStates(child)
+	if err != nil {
+		t.Fatalf("failed to merge sibling states: %v", err)
+	}
+	if balance := statedb.GetBalance(recipient); balance.Uint64() != 1000 {
+		t.Errorf("merged recipient balance: got %d, want 1000", balance.Uint64())
+	}
+	result, err = validator.ProcessHexBlock(NewHexBlock(child, []*types.Transaction{tx}, nil), statedb)
+	if err != nil {
+		t.Fatalf("transaction included by both parents rejected the child: %v", err)
+	}
+	if len(result.Receipts) != 1 || result.Receipts[0].Status != types.ReceiptStatusFailed || result.GasUsed != 0 {
+		t.Errorf("child receipts: got %d, gas used %d, want one skipped transaction", len(result.Receipts), result.GasUsed)
+	}
+	if balance := statedb.GetBalance(recipient); balance.Uint64() != 1000 {
+		t.Errorf("child recipient balance: got %d, want 1000", balance.Uint64())
+	}
+
+	// The merged and executed state is checked against the state root
+	receipts := types.Receipts(result.Receipts)
+	child.GasUsed = result.GasUsed
+	child.Bloom = types.MergeBloom(receipts)
+	child.ReceiptHash = types.DeriveSha(receipts, trie.NewStackTrie(nil))
+	if result.Requests != nil {
+		requestsHash := types.CalcRequestsHash(result.Requests)
+		child.RequestsHash = &requestsHash
+	}
+	child.Root = statedb.IntermediateRoot(true)
+	if err := validator.ValidateStateTransitions(NewHexBlock(child, []*types.Transaction{tx}, nil)); err != nil {
+		t.Errorf("valid state transition rejected: %v", err)
+	}
+	child.Root = common.HexToHash("0x01")
+	if err := validator.ValidateStateTransitions(NewHexBlock(child, []*types.Transaction{tx}, nil)); err == nil {
+		t.Error("state root mismatch accepted")
+	}
+}
+
+func TestStrictPlacement(t *testing.T) {
+	genesis := meshHeader(0)
+	genesis.Root = types.EmptyRootHash
+
+	chain, err := NewHexChain(rawdb.NewMemoryDatabase(), params.TestChainConfig, NewHexBlock(genesis, nil, nil), nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	validator := NewHexBlockValidator(params.TestChainConfig, chain, testEngine{})
+
+	// Genesis sits west of (1,0), so it belongs in the West slot
+	placed := &HexHeader{HexPosition: NewHexCoordinate(1, 0), NeighborCount: 1, Number: big.NewInt(1)}
+	placed.ParentHashes[HexWest] = genesis.Hash()
+	swapped := &HexHeader{HexPosition: NewHexCoordinate(1, 0), NeighborCount: 1, Number: big.NewInt(1)}
+	swapped.ParentHashes[HexEast] = genesis.Hash()
+
+	for _, strict := range []bool{false, true} {
+		validator.SetStrictPlacement(strict)
+		if err := validator.validateMeshTopology(NewHexBlock(placed, nil, nil)); err != nil {
+			t.Errorf("strict %v: placed parent rejected: %v", strict, err)
+		}
+		err := validator.validateMeshTopology(NewHexBlock(swapped, nil, nil))
+		if strict && !errors.Is(err, ErrMisplacedParent) {
+			t.Errorf("strict: swapped parent: got %v, want %v", err, ErrMisplacedParent)
+		}
+		if !strict && err != nil {
+			t.Errorf("loose: swapped parent rejected: %v", err)
+		}
+	}
+
+	// The validator follows the placement rule of the engine
+	validator = NewHexBlockValidator(params.TestChainConfig, chain, strictEngine{})
+	if err := validator.validateMeshTopology(NewHexBlock(swapped, nil, nil)); !errors.Is(err, ErrMisplacedParent) {
+		t.Errorf("engine strict: swapped parent: got %v, want %v", err, ErrMisplacedParent)
+	}
+	// Parents must be known whatever the placement rule
+	orphan := &HexHeader{HexPosition: NewHexCoordinate(1, 0), NeighborCount: 1, Number: big.NewInt(1)}
+	orphan.ParentHashes[HexWest] = common.Hash{0x01}
+	for _, strict := range []bool{false, true} {
+		validator.SetStrictPlacement(strict)
+		if err := validator.validateMeshTopology(NewHexBlock(orphan, nil, nil)); !errors.Is(err, ErrParentNotFound) {
+			t.Errorf("strict %v: unknown parent: got %v, want %v", strict, err, ErrParentNotFound)
+		}
+	}
+}
+
+// strictEngine prescribes strict parent placement
+type strictEngine struct {
+	testEngine
+}
+
+func (strictEngine) StrictPlacement() bool { return true }
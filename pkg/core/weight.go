@@ -0,0 +1,48 @@
+package core
+
+import "github.com/ethereum/go-ethereum/common"
+
+// MeshWeight returns the weight a block adds to the score of its future, which
+// is also its difficulty. Blocks weigh more the better they knit the mesh:
+//
+//	weight = 1 + neighbors + triangles + recent
+//
+// neighbors is the number of occupied parent slots. triangles counts the pairs
+// of adjacent cells in the ring around the block (East/NorthEast, ...,
+// SouthEast/East) that both hold a parent, each closing a triangle of the grid
+// around the block. It follows the parents' actual positions, so parents off
+// the ring or stacked on one cell close nothing. recent counts the parents
+// exactly one number below the block. parents holds the parent headers by
+// slot; slots whose header is unknown earn no triangle nor recency bonus.
+func MeshWeight(header *HexHeader, parents [6]*HexHeader) uint64 {
+	var (
+		weight = uint64(1)
+		ring   = header.HexPosition.Neighbors()
+		filled [6]bool
+	)
+	for i, parentHash := range header.ParentHashes {
+		if parentHash == (common.Hash{}) {
+			continue
+		}
+		weight++ // Connected neighbor
+
+		parent := parents[i]
+		if parent == nil {
+			continue
+		}
+		for dir, cell := range ring {
+			if parent.HexPosition == cell {
+				filled[dir] = true
+			}
+		}
+		if parent.Number.Uint64()+1 == header.Number.Uint64() {
+			weight++ // Recent parent
+		}
+	}
+	for dir := range filled {
+		if filled[dir] && filled[(dir+1)%6] {
+			weight++ // Closed triangle with the next direction
+		}
+	}
+	return weight
+}
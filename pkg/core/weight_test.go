@@ -0,0 +1,66 @@
+package core
+
+import "testing"
+
+func TestMeshWeight(t *testing.T) {
+	genesis := meshHeader(0)
+	ring := NewHexCoordinate(0, 0).Neighbors()
+
+	// parent builds a header at the given number in the ring cell of dir
+	parent := func(number uint64, dir HexDirection) *HexHeader {
+		header := meshHeader(number, genesis)
+		header.HexPosition = ring[dir]
+		return header
+	}
+	// block builds a header at number 10 with the given parents by slot
+	block := func(parents map[HexDirection]*HexHeader) (*HexHeader, [6]*HexHeader) {
+		header := meshHeader(10)
+		var slots [6]*HexHeader
+		for dir, parent := range parents {
+			header.ParentHashes[dir] = parent.Hash()
+			header.NeighborCount++
+			slots[dir] = parent
+		}
+		return header, slots
+	}
+	tests := []struct {
+		name    string
+		parents map[HexDirection]*HexHeader
+		want    uint64 // 1 + neighbors + triangles + recent
+	}{
+		{"genesis", nil, 1},
+		{"single stale parent", map[HexDirection]*HexHeader{HexEast: parent(5, HexEast)}, 2},
+		{"single recent parent", map[HexDirection]*HexHeader{HexEast: parent(9, HexEast)}, 3},
+		{"opposite parents", map[HexDirection]*HexHeader{HexEast: parent(9, HexEast), HexWest: parent(5, HexWest)}, 4},
+		{"adjacent parents", map[HexDirection]*HexHeader{HexEast: parent(9, HexEast), HexNorthEast: parent(5, HexNorthEast)}, 5},
+		{"wrapping adjacent parents", map[HexDirection]*HexHeader{HexSouthEast: parent(9, HexSouthEast), HexEast: parent(9, HexEast)}, 6},
+		{"adjacent cells in opposite slots", map[HexDirection]*HexHeader{HexEast: parent(9, HexNorthWest), HexWest: parent(5, HexWest)}, 5},
+		{"adjacent slots in opposite cells", map[HexDirection]*HexHeader{HexEast: parent(9, HexEast), HexNorthEast: parent(5, HexWest)}, 4},
+		{"adjacent slots off the ring", map[HexDirection]*HexHeader{HexEast: parent(9, HexEast), HexNorthEast: meshHeader(5, genesis)}, 4},
+		{"stacked parents", map[HexDirection]*HexHeader{HexEast: parent(9, HexEast), HexNorthEast: parent(8, HexEast)}, 4},
+	}
+	for _, tt := range tests {
+		header, slots := block(tt.parents)
+		if got := MeshWeight(header, slots); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Parents whose headers are unknown still connect, but earn no bonus
+	header, slots := block(map[HexDirection]*HexHeader{HexEast: parent(9, HexEast), HexNorthEast: parent(9, HexNorthEast)})
+	slots[HexNorthEast] = nil
+	if got := MeshWeight(header, slots); got != 4 {
+		t.Errorf("unknown parent: got %d, want 4", got)
+	}
+
+	// A complete ring of recent parents closes all six triangles
+	slots = [6]*HexHeader{}
+	header = meshHeader(10)
+	for i := range slots {
+		slots[i] = parent(9, HexDirection(i))
+		header.ParentHashes[i] = slots[i].Hash()
+	}
+	if got := MeshWeight(header, slots); got != 1+6+6+6 {
+		t.Errorf("full ring: got %d, want %d", got, 1+6+6+6)
+	}
+}
@@ -0,0 +1,434 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+const (
+	maxHeaderFetch = 192              // Header hashes requested per query while walking the ancestry
+	maxAncestry    = 64 * 1024        // Missing headers a single sync may walk before giving up
+	maxBodyFetch   = 64               // Blocks requested per query while fetching bodies
+	syncBatchSize  = 256              // Headers verified and imported per batch
+	syncInterval   = 10 * time.Second // Interval between sync cycles
+)
+
+var syncProgressKey = []byte("hexmesh-sync-progress") // Database key of the interrupted sync
+
+var (
+	ErrBusy            = errors.New("busy synchronising")
+	ErrMissingAncestor = errors.New("peer did not deliver a requested ancestor")
+	ErrInvalidAncestry = errors.New("invalid ancestry")
+	ErrAncestryTooLong = errors.New("missing ancestry too long")
+	ErrMissingBodies   = errors.New("no peer delivered the requested blocks")
+)
+
+// SyncChain is the chain the downloader extends
+type SyncChain interface {
+	consensus.ChainHeaderReader
+
+	HasHexBlock(hash common.Hash) bool
+	GetHexHeader(hash common.Hash) *hexcore.HexHeader
+	CurrentHexHeader() *hexcore.HexHeader
+}
+
+// storedProgress is the sync state persisted to resume after a restart
+type storedProgress struct {
+	Target        common.Hash
+	StartingBlock uint64
+	HighestBlock  uint64
+}
+
+// Downloader synchronizes the local mesh with the heads of its peers. Missing
+// ancestors are discovered breadth-first along all parent links, verified in
+// batches by the consensus engine and imported in number order after their
+// bodies are fetched from all available peers.
+//
+// The target of a running sync is persisted, so a restarted node resumes it
+// from the blocks it had already imported.
+type Downloader struct {
+	mesh   *HexMeshProtocol
+	chain  SyncChain
+	engine consensus.Engine
+	db     ethdb.KeyValueStore
+	insert func(*hexcore.HexBlock) error // Imports a block whose header was verified
+
+	syncing  atomic.Bool
+	progress ethereum.SyncProgress
+	resume   common.Hash // Target of an interrupted sync, if any
+	lock     sync.RWMutex
+
+	newPeerCh chan *HexPeer
+	quitCh    chan struct{}
+}
+
+// NewDownloader creates a downloader importing blocks with insert, restoring
+// an interrupted sync from the database
+func NewDownloader(mesh *HexMeshProtocol, chain SyncChain, engine consensus.Engine, db ethdb.KeyValueStore, insert func(*hexcore.HexBlock) error) *Downloader {
+	d := &Downloader{
+		mesh:      mesh,
+		chain:     chain,
+		engine:    engine,
+		db:        db,
+		insert:    insert,
+		newPeerCh: make(chan *HexPeer, 1),
+		quitCh:    make(chan struct{}),
+	}
+	if data, _ := db.Get(syncProgressKey); len(data) > 0 {
+		var stored storedProgress
+		if err := rlp.DecodeBytes(data, &stored); err != nil {
+			log.Warn("Failed to decode sync progress", "err", err)
+		} else {
+			d.resume = stored.Target
+			d.progress.StartingBlock = stored.StartingBlock
+			d.progress.HighestBlock = stored.HighestBlock
+			d.progress.CurrentBlock = chain.CurrentHexHeader().Number.Uint64()
+		}
+	}
+	return d
+}
+
+// EnableSync creates the downloader of the protocol, which synchronises the
+// chain with every peer completing the handshake or advertising a head missing
+// locally. The chain must be set and the downloader runs along the protocol.
+func (hmp *HexMeshProtocol) EnableSync(engine consensus.Engine, db ethdb.KeyValueStore, insert func(*hexcore.HexBlock) error) *Downloader {
+	hmp.downloader = NewDownloader(hmp, hmp.chain, engine, db, insert)
+	return hmp.downloader
+}
+
+// Start runs sync cycles on new peers and periodically
+func (d *Downloader) Start() {
+	go d.loop()
+}
+
+// Stop terminates the sync loop
+func (d *Downloader) Stop() {
+	close(d.quitCh)
+}
+
+// NewPeer notifies the downloader of a peer that completed the handshake or
+// advertised a new head
+func (d *Downloader) NewPeer(peer *HexPeer) {
+	select {
+	case d.newPeerCh <- peer:
+	default:
+	}
+}
+
+// Progress returns the state of the running or interrupted sync
+func (d *Downloader) Progress() ethereum.SyncProgress {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.progress
+}
+
+// Syncing reports whether a sync is running
+func (d *Downloader) Syncing() bool {
+	return d.syncing.Load()
+}
+
+// loop runs a sync cycle whenever a peer joins and every syncInterval
+func (d *Downloader) loop() {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.newPeerCh:
+			d.syncCycle()
+		case <-ticker.C:
+			d.syncCycle()
+		case <-d.quitCh:
+			return
+		}
+	}
+}
+
+// syncCycle resumes an interrupted sync, then syncs to every peer head
+// missing locally
+func (d *Downloader) syncCycle() {
+	peers := d.mesh.GetPeers()
+
+	d.lock.RLock()
+	resume := d.resume
+	d.lock.RUnlock()
+	if resume != (common.Hash{}) && !d.chain.HasHexBlock(resume) {
+		for _, peer := range peers {
+			if err := d.synchronise(peer, resume); err == nil {
+				break
+			}
+		}
+	}
+	for _, peer := range peers {
+		if head := peer.Head(); head != (common.Hash{}) && !d.chain.HasHexBlock(head) {
+			d.synchronise(peer, head)
+		}
+	}
+}
+
+// synchronise syncs to a target, dropping peers serving invalid data
+func (d *Downloader) synchronise(peer *HexPeer, target common.Hash) error {
+	err := d.Synchronise(peer, target)
+	switch {
+	case err == nil, errors.Is(err, ErrBusy):
+	case errors.Is(err, ErrInvalidAncestry), errors.Is(err, ErrInvalidResponse), errors.Is(err, consensus.ErrUnknownAncestor):
+		log.Debug("Dropping peer serving invalid mesh", "peer", peer.id.String()[:8], "err", err)
+		peer.conn.Disconnect(p2p.DiscUselessPeer)
+	default:
+		log.Debug("Mesh sync failed", "peer", peer.id.String()[:8], "target", target, "err", err)
+	}
+	return err
+}
+
+// Synchronise imports the missing past cone of target, walking its ancestry
+// on peer and fetching bodies from all peers
+func (d *Downloader) Synchronise(peer *HexPeer, target common.Hash) error {
+	if !d.syncing.CompareAndSwap(false, true) {
+		return ErrBusy
+	}
+	defer d.syncing.Store(false)
+
+	if d.chain.HasHexBlock(target) {
+		return nil
+	}
+	headers, err := d.fetchAncestry(peer, target)
+	if err != nil {
+		return err
+	}
+	log.Info("Synchronising mesh", "peer", peer.id.String()[:8], "target", target, "headers", len(headers))
+
+	// Record the sync so an interrupted one can be resumed
+	d.lock.Lock()
+	if d.resume != target {
+		d.progress.StartingBlock = d.chain.CurrentHexHeader().Number.Uint64()
+	}
+	d.resume = target
+	d.progress.HighestBlock = headers[len(headers)-1].Number.Uint64()
+	d.progress.CurrentBlock = d.chain.CurrentHexHeader().Number.Uint64()
+	stored := storedProgress{Target: target, StartingBlock: d.progress.StartingBlock, HighestBlock: d.progress.HighestBlock}
+	d.lock.Unlock()
+
+	data, err := rlp.EncodeToBytes(&stored)
+	if err != nil {
+		return err
+	}
+	if err := d.db.Put(syncProgressKey, data); err != nil {
+		return err
+	}
+
+	// Parents are numbered below their children, so batches in number order
+	// only depend on the chain and earlier batches
+	for start := 0; start < len(headers); start += syncBatchSize {
+		end := min(start+syncBatchSize, len(headers))
+		if err := d.importBatch(headers[start:end], peer); err != nil {
+			return err
+		}
+	}
+
+	d.lock.Lock()
+	d.resume = common.Hash{}
+	d.progress = ethereum.SyncProgress{}
+	d.lock.Unlock()
+
+	return d.db.Delete(syncProgressKey)
+}
+
+// fetchAncestry walks the ancestry of target breadth-first across all parent
+// links until reaching locally known blocks, returning the missing headers in
+// number order. Every delivered header must be numbered below the children
+// referencing it, which bounds the depth of the walk by the number of target;
+// its size is capped by maxAncestry.
+func (d *Downloader) fetchAncestry(peer *HexPeer, target common.Hash) ([]*hexcore.HexHeader, error) {
+	var (
+		queue   = []common.Hash{target}
+		bounds  = map[common.Hash]*big.Int{target: nil}    // Lowest number of the referencing children, nil for the target
+		fetched = make(map[common.Hash]*hexcore.HexHeader) // By the hash the block is referenced with
+		seen    = make(map[common.Hash]bool)               // By hex hash
+		headers []*hexcore.HexHeader
+	)
+	for len(queue) > 0 {
+		batch := queue[:min(maxHeaderFetch, len(queue))]
+		queue = queue[len(batch):]
+
+		delivered, err := peer.RequestHeaders(batch)
+		if err != nil {
+			return nil, err
+		}
+		byHash := make(map[common.Hash]*hexcore.HexHeader, 2*len(delivered))
+		for _, header := range delivered {
+			byHash[header.Hash()] = header
+			byHash[header.ToEthHeader().Hash()] = header
+		}
+		for _, hash := range batch {
+			header := byHash[hash]
+			if header == nil {
+				return nil, fmt.Errorf("%w: %x", ErrMissingAncestor, hash)
+			}
+			// Parents must precede their children for number order to be topological
+			if bound := bounds[hash]; bound != nil && header.Number.Cmp(bound) >= 0 {
+				return nil, fmt.Errorf("%w: parent %x numbered %d not below child %d", ErrInvalidAncestry, hash, header.Number, bound)
+			}
+			fetched[hash] = header
+			if seen[header.Hash()] {
+				continue // Referenced by both of its hashes
+			}
+			seen[header.Hash()] = true
+			if header.Number.Sign() == 0 {
+				return nil, fmt.Errorf("%w: unknown genesis %x", ErrInvalidAncestry, header.Hash())
+			}
+			if len(headers) == maxAncestry {
+				return nil, fmt.Errorf("%w: more than %d missing headers", ErrAncestryTooLong, maxAncestry)
+			}
+			headers = append(headers, header)
+
+			for _, parentHash := range header.ParentHashes {
+				if parentHash == (common.Hash{}) {
+					continue
+				}
+				if parent := fetched[parentHash]; parent != nil && parent.Number.Cmp(header.Number) >= 0 {
+					return nil, fmt.Errorf("%w: parent %x numbered %d not below child %d", ErrInvalidAncestry, parentHash, parent.Number, header.Number)
+				}
+				if bound, ok := bounds[parentHash]; ok {
+					if bound != nil && header.Number.Cmp(bound) < 0 {
+						bounds[parentHash] = header.Number
+					}
+					continue
+				}
+				if d.chain.HasHexBlock(parentHash) {
+					continue
+				}
+				bounds[parentHash] = header.Number
+				queue = append(queue, parentHash)
+			}
+		}
+	}
+	sort.Slice(headers, func(i, j int) bool {
+		if c := headers[i].Number.Cmp(headers[j].Number); c != 0 {
+			return c < 0
+		}
+		return headers[i].Hash().Cmp(headers[j].Hash()) < 0
+	})
+	return headers, nil
+}
+
+// importBatch verifies a batch of headers, fetches their bodies and imports
+// the blocks in order
+func (d *Downloader) importBatch(headers []*hexcore.HexHeader, origin *HexPeer) error {
+	ethHeaders := make([]*types.Header, len(headers))
+	for i, header := range headers {
+		ethHeaders[i] = header.ToEthHeader()
+	}
+	abort, results := d.engine.VerifyHeaders(d.chain, ethHeaders)
+	defer close(abort)
+
+	for i := range ethHeaders {
+		if err := <-results; err != nil {
+			return fmt.Errorf("header %d (%x) rejected: %w", headers[i].Number, headers[i].Hash(), err)
+		}
+	}
+
+	peers := d.mesh.GetPeers()
+	if len(peers) == 0 {
+		peers = []*HexPeer{origin}
+	}
+	blocks, err := d.fetchBodies(headers, peers)
+	if err != nil {
+		return err
+	}
+	for _, header := range headers {
+		block := blocks[header.Hash()]
+		if err := d.insert(block); err != nil && !errors.Is(err, hexcore.ErrKnownBlock) {
+			return fmt.Errorf("failed to import block %d (%x): %w", header.Number, header.Hash(), err)
+		}
+		d.lock.Lock()
+		d.progress.CurrentBlock = max(d.progress.CurrentBlock, header.Number.Uint64())
+		d.lock.Unlock()
+	}
+	return nil
+}
+
+// fetchBodies retrieves the blocks of the headers, spreading the queries over
+// the peers in parallel. Chunks rotate between peers every round, so blocks a
+// peer lacks are asked from the others; peers failing a query are dropped.
+func (d *Downloader) fetchBodies(headers []*hexcore.HexHeader, peers []*HexPeer) (map[common.Hash]*hexcore.HexBlock, error) {
+	var (
+		blocks  = make(map[common.Hash]*hexcore.HexBlock, len(headers))
+		pending = make([]common.Hash, len(headers))
+		stalled int // Rounds in a row without new blocks
+	)
+	for i, header := range headers {
+		pending[i] = header.Hash()
+	}
+	for round := 0; len(pending) > 0; round++ {
+		if len(peers) == 0 || stalled >= len(peers) {
+			return nil, fmt.Errorf("%w: %d missing", ErrMissingBodies, len(pending))
+		}
+		// Hand out one chunk per peer, in parallel
+		var (
+			delivered = make([][]*hexcore.HexBlock, len(peers))
+			failed    = make([]bool, len(peers))
+			wg        sync.WaitGroup
+		)
+		for i, start := 0, 0; i < len(peers) && start < len(pending); i, start = i+1, start+maxBodyFetch {
+			chunk := pending[start:min(start+maxBodyFetch, len(pending))]
+			p := (i + round) % len(peers)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				res, err := peers[p].RequestBlocks(chunk)
+				if err != nil {
+					log.Debug("Block fetch failed", "peer", peers[p].id.String()[:8], "err", err)
+					failed[p] = true
+					return
+				}
+				delivered[p] = res
+			}()
+		}
+		wg.Wait()
+
+		stalled++
+		for _, res := range delivered {
+			for _, block := range res {
+				if _, ok := blocks[block.Hash()]; !ok {
+					blocks[block.Hash()] = block
+					stalled = 0
+				}
+			}
+		}
+		remaining := pending[:0:0]
+		for _, hash := range pending {
+			if blocks[hash] == nil {
+				remaining = append(remaining, hash)
+			}
+		}
+		pending = remaining
+
+		alive := peers[:0:0]
+		for i, peer := range peers {
+			if !failed[i] {
+				alive = append(alive, peer)
+			}
+		}
+		peers = alive
+	}
+	return blocks, nil
+}
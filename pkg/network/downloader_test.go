@@ -0,0 +1,167 @@
+package network
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+// acceptingEngine accepts every header
+type acceptingEngine struct {
+	consensus.Engine
+}
+
+func (acceptingEngine) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header) (chan<- struct{}, <-chan error) {
+	abort, results := make(chan struct{}), make(chan error, len(headers))
+	for range headers {
+		results <- nil
+	}
+	return abort, results
+}
+
+// countingReader counts the blocks served from a chain
+type countingReader struct {
+	*hexcore.HexChain
+	served *atomic.Int64
+}
+
+func (r countingReader) GetHexBlock(hash common.Hash) *hexcore.HexBlock {
+	r.served.Add(1)
+	return r.HexChain.GetHexBlock(hash)
+}
+
+func TestDownloader(t *testing.T) {
+	// A long mesh with a side branch merged back near the tip
+	remote, genesis := newTestChain(t)
+	headers := []*hexcore.HexHeader{genesis}
+	for i := uint64(1); i <= 300; i++ {
+		headers = append(headers, writeTestBlock(t, remote, i, i, headers[i-1]).Header())
+	}
+	side := writeTestBlock(t, remote, 299, 1000, headers[298])
+	target := writeTestBlock(t, remote, 301, 1001, headers[300], side.Header())
+
+	// Two peers advertising the tip serve the blocks
+	var served [2]atomic.Int64
+	client := NewHexMeshProtocol(nil)
+	var peers []*HexPeer
+	for i := range served {
+		server := NewHexMeshProtocol(nil)
+		server.currentHead = target.Hash()
+		server.SetChainReader(countingReader{remote, &served[i]})
+		peers = append(peers, connectPeers(t, server, client, enode.ID{byte(i + 1)}))
+	}
+
+	local, _ := newTestChain(t)
+	failAt := uint64(100)
+	insert := func(block *hexcore.HexBlock) error {
+		if block.Number().Uint64() == failAt {
+			return errors.New("interrupted")
+		}
+		statedb, err := local.GetState(block.Header().PrimaryParent())
+		if err != nil {
+			return err
+		}
+		return local.WriteBlock(block, nil, statedb)
+	}
+	db := rawdb.NewMemoryDatabase()
+	dl := NewDownloader(client, local, acceptingEngine{}, db, insert)
+
+	// An interrupted sync keeps its progress
+	if err := dl.Synchronise(peers[0], target.Hash()); err == nil {
+		t.Fatal("interrupted sync succeeded")
+	}
+	if head := local.CurrentHexHeader().Number.Uint64(); head != failAt-1 {
+		t.Errorf("head after interruption: got %d, want %d", head, failAt-1)
+	}
+
+	// A restarted downloader resumes the sync towards the same target
+	failAt = 0
+	dl = NewDownloader(client, local, acceptingEngine{}, db, insert)
+	if progress := dl.Progress(); progress.HighestBlock != 301 || progress.CurrentBlock != 99 {
+		t.Errorf("restored progress: got %+v", progress)
+	}
+	dl.syncCycle()
+
+	if !local.HasHexBlock(target.Hash()) || !local.HasHexBlock(side.Hash()) {
+		t.Fatal("mesh not synchronised")
+	}
+	if head := local.CurrentHexHeader().Hash(); head != target.Hash() {
+		t.Errorf("head after sync: got %x, want %x", head, target.Hash())
+	}
+	if progress := dl.Progress(); progress.HighestBlock != 0 {
+		t.Errorf("progress after sync: got %+v", progress)
+	}
+	if data, _ := db.Get(syncProgressKey); len(data) != 0 {
+		t.Error("sync progress left in the database")
+	}
+	for i := range served {
+		if served[i].Load() == 0 {
+			t.Errorf("peer %d served no blocks", i)
+		}
+	}
+
+	// Ancestries numbered out of order are refused before any import
+	high := writeTestBlock(t, remote, 1007, 1007, genesis)
+	low := writeTestBlock(t, remote, 1005, 1008, high.Header())
+	fresh, _ := newTestChain(t)
+	dl = NewDownloader(client, fresh, acceptingEngine{}, rawdb.NewMemoryDatabase(), insert)
+	if err := dl.Synchronise(peers[0], low.Hash()); !errors.Is(err, ErrInvalidAncestry) {
+		t.Errorf("inverted ancestry: got %v, want %v", err, ErrInvalidAncestry)
+	}
+}
+
+func TestDownloaderSync(t *testing.T) {
+	remote, genesis := newTestChain(t)
+	headers := []*hexcore.HexHeader{genesis}
+	for i := uint64(1); i <= 20; i++ {
+		headers = append(headers, writeTestBlock(t, remote, i, i, headers[i-1]).Header())
+	}
+	server := NewHexMeshProtocol(nil)
+	server.SetChain(remote)
+
+	local, _ := newTestChain(t)
+	client := NewHexMeshProtocol(nil)
+	client.SetChain(local)
+	client.EnableSync(acceptingEngine{}, rawdb.NewMemoryDatabase(), func(block *hexcore.HexBlock) error {
+		statedb, err := local.GetState(block.Header().PrimaryParent())
+		if err != nil {
+			return err
+		}
+		return local.WriteBlock(block, nil, statedb)
+	})
+	if err := client.Start(); err != nil {
+		t.Fatalf("failed to start client: %v", err)
+	}
+	defer client.Stop()
+
+	waitSynced := func(hash common.Hash) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !local.HasHexBlock(hash); {
+			if time.Now().After(deadline) {
+				t.Fatalf("block %x not synchronised", hash)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// Connecting syncs to the head of the peer
+	connectPeers(t, server, client, enode.ID{1})
+	waitSynced(headers[20].Hash())
+
+	// A head advertised later is synced as well
+	tip := writeTestBlock(t, remote, 21, 21, headers[20])
+	server.sendHeartbeats()
+	waitSynced(tip.Hash())
+
+	if head := local.CurrentHexHeader().Hash(); head != tip.Hash() {
+		t.Errorf("head after sync: got %x, want %x", head, tip.Hash())
+	}
+}
@@ -0,0 +1,240 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+const (
+	arriveTimeout      = 500 * time.Millisecond // Time an announced block is given to be pushed before it is fetched
+	maxQueuedAnnounces = 4096                   // Announced blocks awaiting retrieval
+)
+
+// HexAnnouncement announces a block by its hash and mesh position
+type HexAnnouncement struct {
+	Hash     common.Hash
+	Position hexcore.HexCoordinate
+}
+
+// KnownBlock reports whether the peer is known to have a block
+func (p *HexPeer) KnownBlock(hash common.Hash) bool {
+	return p.knownBlocks.Contains(hash)
+}
+
+// KnownHeader reports whether the peer is known to have a header
+func (p *HexPeer) KnownHeader(hash common.Hash) bool {
+	return p.knownHeaders.Contains(hash)
+}
+
+// markBlock records that the peer has a block
+func (p *HexPeer) markBlock(hash common.Hash) {
+	p.knownBlocks.Add(hash, struct{}{})
+}
+
+// markHeader records that the peer has a header
+func (p *HexPeer) markHeader(hash common.Hash) {
+	p.knownHeaders.Add(hash, struct{}{})
+}
+
+// handleAnnounce records the blocks a peer announced and schedules the
+// retrieval of those unknown locally
+func (hmp *HexMeshProtocol) handleAnnounce(peer *HexPeer, msg p2p.Msg) error {
+	var announces []HexAnnouncement
+	if err := msg.Decode(&announces); err != nil {
+		return err
+	}
+	if len(announces) > MaxBlocksServe {
+		return fmt.Errorf("too many blocks announced: %d > max %d", len(announces), MaxBlocksServe)
+	}
+	peer.lastSeen = time.Now()
+
+	var unknown []common.Hash
+	for _, announce := range announces {
+		log.Trace("Received block announcement", "peer", peer.id.String()[:8], "hash", announce.Hash, "position", announce.Position)
+
+		peer.markBlock(announce.Hash)
+		if !hmp.hasBlock(announce.Hash) {
+			unknown = append(unknown, announce.Hash)
+		}
+	}
+	hmp.fetcher.notify(peer, unknown)
+	return nil
+}
+
+// hasBlock reports whether the local chain holds a block, assuming it does
+// not when no chain reader is set
+func (hmp *HexMeshProtocol) hasBlock(hash common.Hash) bool {
+	return hmp.reader != nil && hmp.reader.HasHexBlock(hash)
+}
+
+// blockFetcher retrieves announced blocks that are not pushed in time, asking
+// each announcer in turn until one delivers
+type blockFetcher struct {
+	hmp *HexMeshProtocol
+
+	announced map[common.Hash][]*HexPeer // Remaining sources of the blocks awaiting retrieval
+	fetching  map[common.Hash]bool       // Blocks being retrieved
+	lock      sync.Mutex
+}
+
+// newBlockFetcher creates a fetcher delivering blocks to the protocol
+func newBlockFetcher(hmp *HexMeshProtocol) *blockFetcher {
+	return &blockFetcher{
+		hmp:       hmp,
+		announced: make(map[common.Hash][]*HexPeer),
+		fetching:  make(map[common.Hash]bool),
+	}
+}
+
+// notify queues blocks announced by a peer, retrieving the new ones once they
+// had time to arrive by push
+func (f *blockFetcher) notify(peer *HexPeer, hashes []common.Hash) {
+	if queued := f.queue(peer, hashes); len(queued) > 0 {
+		time.AfterFunc(arriveTimeout, func() { f.fetch(queued) })
+	}
+}
+
+// request queues blocks a peer is known to have, retrieving the new ones at once
+func (f *blockFetcher) request(peer *HexPeer, hashes []common.Hash) {
+	if queued := f.queue(peer, hashes); len(queued) > 0 {
+		f.fetch(queued)
+	}
+}
+
+// queue records a peer as a source of blocks, returning those newly queued
+func (f *blockFetcher) queue(peer *HexPeer, hashes []common.Hash) []common.Hash {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var queued []common.Hash
+	for _, hash := range hashes {
+		if f.fetching[hash] {
+			continue
+		}
+		if peers, ok := f.announced[hash]; ok {
+			if !containsPeer(peers, peer) {
+				f.announced[hash] = append(peers, peer)
+			}
+			continue
+		}
+		if len(f.announced) >= maxQueuedAnnounces {
+			log.Debug("Fetch queue full, dropping block", "peer", peer.id.String()[:8], "hash", hash)
+			continue
+		}
+		f.announced[hash] = []*HexPeer{peer}
+		queued = append(queued, hash)
+	}
+	return queued
+}
+
+// forget drops the announcements of a block that arrived by push
+func (f *blockFetcher) forget(hash common.Hash) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.announced, hash)
+}
+
+// dropPeer forgets a disconnected peer as a source of blocks, dropping the
+// blocks it was the last remaining source of
+func (f *blockFetcher) dropPeer(peer *HexPeer) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for hash, peers := range f.announced {
+		for i, p := range peers {
+			if p == peer {
+				peers = append(peers[:i:i], peers[i+1:]...)
+				break
+			}
+		}
+		if len(peers) == 0 && !f.fetching[hash] {
+			delete(f.announced, hash)
+		} else {
+			f.announced[hash] = peers
+		}
+	}
+}
+
+// fetch requests the announced blocks still missing, each from its next
+// announcer
+func (f *blockFetcher) fetch(hashes []common.Hash) {
+	f.lock.Lock()
+	requests := make(map[*HexPeer][]common.Hash)
+	for _, hash := range hashes {
+		peers, ok := f.announced[hash]
+		if !ok || f.fetching[hash] {
+			continue
+		}
+		if len(peers) == 0 || f.hmp.hasBlock(hash) {
+			delete(f.announced, hash)
+			continue
+		}
+		f.announced[hash] = peers[1:]
+		f.fetching[hash] = true
+		requests[peers[0]] = append(requests[peers[0]], hash)
+	}
+	f.lock.Unlock()
+
+	for peer, hashes := range requests {
+		for start := 0; start < len(hashes); start += MaxBlocksServe {
+			go f.retrieve(peer, hashes[start:min(start+MaxBlocksServe, len(hashes))])
+		}
+	}
+}
+
+// retrieve requests blocks from a peer, processing the delivered ones and
+// retrying the rest from their other announcers
+func (f *blockFetcher) retrieve(peer *HexPeer, hashes []common.Hash) {
+	blocks, err := peer.RequestBlocks(hashes)
+	if err != nil {
+		log.Debug("Failed to fetch announced blocks", "peer", peer.id.String()[:8], "err", err)
+	}
+	delivered := make(map[common.Hash]bool, len(blocks))
+	for _, block := range blocks {
+		delivered[block.Hash()] = true
+	}
+
+	f.lock.Lock()
+	var retry []common.Hash
+	for _, hash := range hashes {
+		delete(f.fetching, hash)
+		if delivered[hash] {
+			delete(f.announced, hash)
+		} else {
+			retry = append(retry, hash)
+		}
+	}
+	f.lock.Unlock()
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Number().Cmp(blocks[j].Number()) < 0
+	})
+	for _, block := range blocks {
+		peer.markBlock(block.Hash())
+		if err := f.hmp.processBlock(block); err != nil {
+			log.Debug("Failed to process announced block", "hash", block.Hash(), "err", err)
+		}
+	}
+	if len(retry) > 0 {
+		f.fetch(retry)
+	}
+}
+
+// containsPeer reports whether a peer is in a list
+func containsPeer(peers []*HexPeer, peer *HexPeer) bool {
+	for _, p := range peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
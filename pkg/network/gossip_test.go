@@ -0,0 +1,192 @@
+package network
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+func TestBroadcastHexBlock(t *testing.T) {
+	chain, genesis := newTestChain(t)
+	block := writeTestBlock(t, chain, 1, 1, genesis)
+
+	// Nine peers at increasing distances
+	hmp := NewHexMeshProtocol(nil)
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	var remotes []*bufferedRW
+	for i := 0; i < 9; i++ {
+		local, remote := bufferedPipe()
+		t.Cleanup(local.Close)
+
+		peer := newHexPeer(p2p.NewPeer(enode.ID{byte(i + 1)}, "peer", nil), local)
+		peer.distance = int64(i + 1)
+		hmp.peers[peer.id] = peer
+		remotes = append(remotes, remote)
+		go hmp.sendLoop(peer, done)
+	}
+
+	// The closest square root of the peers get the block, the rest its hash
+	hmp.BroadcastHexBlock(block)
+	for i, remote := range remotes {
+		msg, err := remote.ReadMsg()
+		if err != nil {
+			t.Fatalf("peer %d: failed to read message: %v", i, err)
+		}
+		want := uint64(HexAnnounceMsg)
+		if i < 3 {
+			want = HexBlockMsg
+		}
+		if msg.Code != want {
+			t.Errorf("peer %d: got message %#x, want %#x", i, msg.Code, want)
+		}
+		if msg.Code == HexAnnounceMsg {
+			var announces []HexAnnouncement
+			if err := msg.Decode(&announces); err != nil {
+				t.Fatalf("peer %d: failed to decode announcement: %v", i, err)
+			}
+			if len(announces) != 1 || announces[0].Hash != block.Hash() {
+				t.Errorf("peer %d: announced %v, want %x", i, announces, block.Hash())
+			}
+		}
+	}
+	// Peers knowing the block or header are skipped, the heartbeat following
+	// the broadcasts in the send queue marks their end
+	hmp.BroadcastHexBlock(block)
+	hmp.BroadcastHexHeader(block.Header())
+	hmp.BroadcastHexHeader(block.Header())
+	hmp.sendHeartbeats()
+	for i, remote := range remotes {
+		for _, want := range []uint64{HexHeaderMsg, HexNeighborMsg} {
+			msg, err := remote.ReadMsg()
+			if err != nil {
+				t.Fatalf("peer %d: failed to read message: %v", i, err)
+			}
+			if msg.Code != want {
+				t.Errorf("peer %d: got message %#x, want %#x", i, msg.Code, want)
+			}
+		}
+	}
+}
+
+func TestBroadcastSlowPeer(t *testing.T) {
+	chain, genesis := newTestChain(t)
+	block := writeTestBlock(t, chain, 1, 1, genesis)
+
+	// A peer that never reads does not hold up the broadcast
+	hmp := NewHexMeshProtocol(nil)
+	local, _ := p2p.MsgPipe()
+	t.Cleanup(func() { local.Close() })
+
+	peer := newHexPeer(p2p.NewPeer(enode.ID{1}, "peer", nil), local)
+	hmp.peers[peer.id] = peer
+	done := make(chan struct{})
+	defer close(done)
+	go hmp.sendLoop(peer, done)
+
+	broadcast := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*MaxQueuedMessages; i++ {
+			hmp.BroadcastHexHeader(testHeader(uint64(i+1), uint64(i+1), genesis))
+		}
+		hmp.BroadcastHexBlock(block)
+		close(broadcast)
+	}()
+	select {
+	case <-broadcast:
+	case <-time.After(5 * time.Second):
+		t.Fatal("broadcast blocked on a slow peer")
+	}
+	if !peer.KnownBlock(block.Hash()) {
+		t.Error("block not marked known to the peer")
+	}
+}
+
+func TestFetcherDropPeer(t *testing.T) {
+	hmp := NewHexMeshProtocol(nil)
+	var peers []*HexPeer
+	for i := 0; i < 2; i++ {
+		local, _ := bufferedPipe()
+		t.Cleanup(local.Close)
+
+		peer := newHexPeer(p2p.NewPeer(enode.ID{byte(i + 1)}, "peer", nil), local)
+		hmp.peers[peer.id] = peer
+		peers = append(peers, peer)
+	}
+	shared, single := common.HexToHash("0x01"), common.HexToHash("0x02")
+	hmp.fetcher.queue(peers[0], []common.Hash{shared, single})
+	hmp.fetcher.queue(peers[1], []common.Hash{shared})
+
+	// Blocks keep their other sources, those left without any are dropped
+	hmp.RemovePeer(peers[0].id)
+	if sources := hmp.fetcher.announced[shared]; len(sources) != 1 || sources[0] != peers[1] {
+		t.Errorf("shared block sources: got %v, want the remaining peer", sources)
+	}
+	if _, ok := hmp.fetcher.announced[single]; ok {
+		t.Error("block without sources still queued")
+	}
+}
+
+func TestAnnounceFetch(t *testing.T) {
+	chain, genesis := newTestChain(t)
+	fetched := writeTestBlock(t, chain, 1, 1, genesis)
+	pushed := writeTestBlock(t, chain, 1, 2, genesis)
+
+	var served atomic.Int64
+	server, client := NewHexMeshProtocol(nil), NewHexMeshProtocol(nil)
+	server.SetChainReader(countingReader{chain, &served})
+
+	received := make(chan common.Hash, 16)
+	client.SetBlockHandler(func(block *hexcore.HexBlock) error {
+		received <- block.Hash()
+		return nil
+	})
+	peer := connectPeers(t, server, client, enode.ID{1})
+	remote := server.GetPeers()[0]
+
+	// Repeated announcements are fetched once, pushed blocks not at all
+	announce := func(block *hexcore.HexBlock) {
+		announces := []HexAnnouncement{{Hash: block.Hash(), Position: block.Header().HexPosition}}
+		if err := p2p.Send(remote.rw, HexAnnounceMsg, announces); err != nil {
+			t.Fatalf("failed to announce block: %v", err)
+		}
+	}
+	announce(fetched)
+	announce(fetched)
+	announce(pushed)
+	if err := p2p.Send(remote.rw, HexBlockMsg, pushed); err != nil {
+		t.Fatalf("failed to push block: %v", err)
+	}
+
+	want := map[common.Hash]bool{fetched.Hash(): true, pushed.Hash(): true}
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case hash := <-received:
+			if !want[hash] {
+				t.Fatalf("block %x delivered twice", hash)
+			}
+			delete(want, hash)
+		case <-timeout:
+			t.Fatalf("missing blocks: %v", want)
+		}
+	}
+	select {
+	case hash := <-received:
+		t.Errorf("block %x delivered twice", hash)
+	case <-time.After(2 * arriveTimeout):
+	}
+	if n := served.Load(); n != 1 {
+		t.Errorf("blocks served: got %d, want 1", n)
+	}
+	if !peer.KnownBlock(fetched.Hash()) || !peer.KnownBlock(pushed.Hash()) {
+		t.Error("announced blocks not marked known for the peer")
+	}
+}
@@ -2,14 +2,20 @@
 package network
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	lru "github.com/hashicorp/golang-lru"
 
 	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
 )
@@ -17,24 +23,40 @@ import (
 const (
 	// Protocol constants
 	HexMeshProtocolName    = "hexmesh"
-	HexMeshProtocolVersion = 1
-	HexMeshProtocolLength  = 20
+	HexMeshProtocolVersion = 2
+	HexMeshProtocolLength  = 0x1b
 
 	// Message codes
-	HexBlockMsg         = 0x10
-	HexHeaderMsg        = 0x11
-	HexBlockRequestMsg  = 0x12
-	HexHeaderRequestMsg = 0x13
-	HexProofMsg         = 0x14
-	HexStatusMsg        = 0x15
-	HexNeighborMsg      = 0x16
-	HexMeshStateMsg     = 0x17
+	HexBlockMsg          = 0x10
+	HexHeaderMsg         = 0x11
+	HexBlockRequestMsg   = 0x12
+	HexHeaderRequestMsg  = 0x13
+	HexProofMsg          = 0x14
+	HexStatusMsg         = 0x15
+	HexNeighborMsg       = 0x16
+	HexMeshStateMsg      = 0x17
+	HexBlockResponseMsg  = 0x18
+	HexHeaderResponseMsg = 0x19
+	HexAnnounceMsg       = 0x1a
 
 	// Network constants
-	MaxNeighborPeers      = 6   // Maximum neighbors in hex topology
-	MaxConcurrentRequests = 100 // Maximum concurrent requests
-	RequestTimeout        = 30  // Seconds
-	HeartbeatInterval     = 15  // Seconds
+	MaxNeighborPeers      = 6    // Maximum neighbors in hex topology
+	MaxConcurrentRequests = 100  // Maximum concurrent requests
+	MaxBlocksServe        = 128  // Maximum blocks served per request
+	MaxHeadersServe       = 1024 // Maximum headers served per request
+	RequestTimeout        = 30   // Seconds
+	HeartbeatInterval     = 15   // Seconds
+	MaxKnownBlocks        = 1024 // Block hashes remembered per peer
+	MaxKnownHeaders       = 1024 // Header hashes remembered per peer
+	MaxQueuedMessages     = 128  // Messages queued for sending per peer
+)
+
+var (
+	ErrProtocolVersionMismatch = errors.New("protocol version mismatch")
+	ErrNetworkIDMismatch       = errors.New("network ID mismatch")
+	ErrGenesisMismatch         = errors.New("genesis mismatch")
+	ErrForkIDRejected          = errors.New("fork ID rejected")
+	ErrNoStatusMsg             = errors.New("first message is not a status message")
 )
 
 // HexMeshProtocol implements the hexagonal mesh networking protocol
@@ -47,6 +69,14 @@ type HexMeshProtocol struct {
 	localPosition hexcore.HexCoordinate
 	networkID     uint64
 	currentHead   common.Hash
+	chain         *hexcore.HexChain // Chain providing the genesis, fork schedule and head, if set
+	forkFilter    forkid.Filter     // Filter of remote fork IDs, set along with the chain
+	reader        ChainReader       // Source of the blocks and headers served to peers
+	index         MeshIndex         // Source of the blocks reconciled with peers
+	mesh          *meshSnapshot     // Snapshot of the index answering reconciliation rounds
+	meshMu        sync.Mutex        // Protects mesh
+	fetcher       *blockFetcher     // Retriever of announced blocks
+	downloader    *Downloader       // Synchroniser of the heads of peers, if enabled
 
 	// Communication channels
 	blockCh  chan *hexcore.HexBlock
@@ -57,6 +87,7 @@ type HexMeshProtocol struct {
 	// Event handlers
 	blockHandler  func(*hexcore.HexBlock) error
 	headerHandler func(*hexcore.HexHeader) error
+	peerHandler   func(*HexPeer)
 }
 
 // HexMeshConfig contains configuration for the hex mesh protocol
@@ -94,11 +125,26 @@ type HexPeer struct {
 	isNeighbor bool
 	distance   int64
 	lastSeen   time.Time
+	stateMu    sync.RWMutex // Protects the position, head, distance and neighbor flag
+
+	// Gossip deduplication
+	knownBlocks  *lru.Cache // Blocks the peer is known to have
+	knownHeaders *lru.Cache // Headers the peer is known to have
+
+	// Messages to the peer, sent off the message loop and the broadcasters
+	queue chan queuedMsg
 
 	// Request tracking
 	requests map[uint64]*PendingRequest
 	reqMu    sync.RWMutex
 	reqID    uint64
+	dropped  bool // Whether the peer left the mesh, failing its requests
+}
+
+// queuedMsg is a message waiting to be sent to a peer
+type queuedMsg struct {
+	code uint64
+	data interface{}
 }
 
 // PendingRequest tracks outgoing requests
@@ -116,16 +162,33 @@ type HexStatus struct {
 	NetworkID       uint64                `json:"networkId"`
 	Head            common.Hash           `json:"head"`
 	Genesis         common.Hash           `json:"genesis"`
+	ForkID          forkid.ID             `json:"forkId"`
 	Position        hexcore.HexCoordinate `json:"position"`
 }
 
+// forkChain adapts a hex chain to the fork ID calculation, which identifies
+// the genesis by the hash of its Ethereum encoding
+type forkChain struct {
+	*hexcore.HexChain
+}
+
+// Genesis returns the genesis block in Ethereum encoding
+func (c forkChain) Genesis() *types.Block {
+	return types.NewBlockWithHeader(c.HexChain.Genesis().ToEthHeader())
+}
+
 // NewHexMeshProtocol creates a new hex mesh protocol instance
 func NewHexMeshProtocol(config *HexMeshConfig) *HexMeshProtocol {
 	if config == nil {
 		config = DefaultHexMeshConfig()
 	}
+	if config.HandshakeTimeout == 0 {
+		cfg := *config
+		cfg.HandshakeTimeout = DefaultHexMeshConfig().HandshakeTimeout
+		config = &cfg
+	}
 
-	return &HexMeshProtocol{
+	hmp := &HexMeshProtocol{
 		config:        config,
 		peers:         make(map[enode.ID]*HexPeer),
 		networkID:     config.NetworkID,
@@ -135,6 +198,78 @@ func NewHexMeshProtocol(config *HexMeshConfig) *HexMeshProtocol {
 		statusCh:      make(chan *HexStatus, 10),
 		quitCh:        make(chan struct{}),
 	}
+	hmp.fetcher = newBlockFetcher(hmp)
+	return hmp
+}
+
+// newHexPeer creates the mesh state of a connected peer
+func newHexPeer(peer *p2p.Peer, rw p2p.MsgReadWriter) *HexPeer {
+	knownBlocks, _ := lru.New(MaxKnownBlocks)
+	knownHeaders, _ := lru.New(MaxKnownHeaders)
+
+	return &HexPeer{
+		id:           peer.ID(),
+		conn:         peer,
+		rw:           rw,
+		knownBlocks:  knownBlocks,
+		knownHeaders: knownHeaders,
+		queue:        make(chan queuedMsg, MaxQueuedMessages),
+		requests:     make(map[uint64]*PendingRequest),
+		lastSeen:     time.Now(),
+	}
+}
+
+// Head returns the head last advertised by the peer
+func (p *HexPeer) Head() common.Hash {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
+
+	return p.head
+}
+
+// Position returns the mesh position last advertised by the peer
+func (p *HexPeer) Position() hexcore.HexCoordinate {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
+
+	return p.position
+}
+
+// Distance returns the mesh distance between the peer and the local node
+func (p *HexPeer) Distance() int64 {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
+
+	return p.distance
+}
+
+// IsNeighbor reports whether the peer is adjacent to the local node
+func (p *HexPeer) IsNeighbor() bool {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
+
+	return p.isNeighbor
+}
+
+// setState records the position and head advertised by the peer, measuring
+// its distance from the local position
+func (p *HexPeer) setState(position hexcore.HexCoordinate, head common.Hash, local hexcore.HexCoordinate) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	p.position = position
+	p.head = head
+	p.distance = local.Distance(position)
+	p.isNeighbor = p.distance == 1
+}
+
+// setLocal measures the distance of the peer from a new local position
+func (p *HexPeer) setLocal(local hexcore.HexCoordinate) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	p.distance = local.Distance(p.position)
+	p.isNeighbor = p.distance == 1
 }
 
 // Start starts the hex mesh protocol
@@ -144,6 +279,9 @@ func (hmp *HexMeshProtocol) Start() error {
 	// Start background goroutines
 	go hmp.heartbeatLoop()
 	go hmp.messageHandler()
+	if hmp.downloader != nil {
+		hmp.downloader.Start()
+	}
 
 	return nil
 }
@@ -151,6 +289,9 @@ func (hmp *HexMeshProtocol) Start() error {
 // Stop stops the hex mesh protocol
 func (hmp *HexMeshProtocol) Stop() {
 	close(hmp.quitCh)
+	if hmp.downloader != nil {
+		hmp.downloader.Stop()
+	}
 
 	// Disconnect all peers
 	hmp.peersMu.Lock()
@@ -162,17 +303,12 @@ func (hmp *HexMeshProtocol) Stop() {
 
 // AddPeer adds a new peer to the mesh
 func (hmp *HexMeshProtocol) AddPeer(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
-	hexPeer := &HexPeer{
-		id:       peer.ID(),
-		conn:     peer,
-		rw:       rw,
-		requests: make(map[uint64]*PendingRequest),
-		lastSeen: time.Now(),
-	}
+	hexPeer := newHexPeer(peer, rw)
 
 	// Perform handshake
 	if err := hmp.handshake(hexPeer); err != nil {
-		return fmt.Errorf("handshake failed: %v", err)
+		peer.Disconnect(handshakeDiscReason(err))
+		return fmt.Errorf("handshake failed: %w", err)
 	}
 
 	// Add to peers map
@@ -180,39 +316,83 @@ func (hmp *HexMeshProtocol) AddPeer(peer *p2p.Peer, rw p2p.MsgReadWriter) error
 	hmp.peers[peer.ID()] = hexPeer
 	hmp.peersMu.Unlock()
 
-	log.Info("Added hex mesh peer", "id", peer.ID().String()[:8], "position", hexPeer.position)
+	log.Info("Added hex mesh peer", "id", peer.ID().String()[:8], "position", hexPeer.Position())
 
 	// Start peer handler
 	go hmp.handlePeer(hexPeer)
 
+	if hmp.peerHandler != nil {
+		hmp.peerHandler(hexPeer)
+	}
+	if hmp.downloader != nil {
+		hmp.downloader.NewPeer(hexPeer)
+	}
+
 	return nil
 }
 
 // RemovePeer removes a peer from the mesh
 func (hmp *HexMeshProtocol) RemovePeer(peerID enode.ID) {
 	hmp.peersMu.Lock()
+	peer, ok := hmp.peers[peerID]
 	delete(hmp.peers, peerID)
 	hmp.peersMu.Unlock()
 
+	if ok {
+		hmp.fetcher.dropPeer(peer)
+		peer.failRequests()
+	}
 	log.Info("Removed hex mesh peer", "id", peerID.String()[:8])
 }
 
-// handshake performs the initial handshake with a peer
+// handshake exchanges status messages with a peer, rejecting peers on another
+// network, genesis or incompatible fork within the handshake timeout
 func (hmp *HexMeshProtocol) handshake(peer *HexPeer) error {
-	// Send our status
 	status := &HexStatus{
 		ProtocolVersion: HexMeshProtocolVersion,
 		NetworkID:       hmp.networkID,
-		Head:            hmp.currentHead,
-		Genesis:         common.Hash{}, // TODO: Get actual genesis hash
+		Head:            hmp.head(),
 		Position:        hmp.localPosition,
 	}
+	if hmp.chain != nil {
+		status.Genesis = hmp.chain.Genesis().Hash()
+		status.ForkID = forkid.NewIDWithChain(forkChain{hmp.chain})
+	}
 
-	if err := p2p.Send(peer.rw, HexStatusMsg, status); err != nil {
-		return err
+	// Send and receive concurrently so neither side waits on the other
+	var (
+		peerStatus HexStatus
+		errc       = make(chan error, 2)
+	)
+	go func() {
+		errc <- p2p.Send(peer.rw, HexStatusMsg, status)
+	}()
+	go func() {
+		errc <- hmp.readStatus(peer, status, &peerStatus)
+	}()
+	timeout := time.NewTimer(hmp.config.HandshakeTimeout)
+	defer timeout.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return err
+			}
+		case <-timeout.C:
+			return p2p.DiscReadTimeout
+		}
 	}
 
-	// Receive peer status
+	// Update peer information
+	peer.setState(peerStatus.Position, peerStatus.Head, hmp.localPosition)
+
+	return nil
+}
+
+// readStatus reads the status message of a peer and validates it against the
+// local status
+func (hmp *HexMeshProtocol) readStatus(peer *HexPeer, local *HexStatus, status *HexStatus) error {
 	msg, err := peer.rw.ReadMsg()
 	if err != nil {
 		return err
@@ -220,34 +400,52 @@ func (hmp *HexMeshProtocol) handshake(peer *HexPeer) error {
 	defer msg.Discard()
 
 	if msg.Code != HexStatusMsg {
-		return fmt.Errorf("expected status message, got %d", msg.Code)
+		return fmt.Errorf("%w: got code %d", ErrNoStatusMsg, msg.Code)
 	}
-
-	var peerStatus HexStatus
-	if err := msg.Decode(&peerStatus); err != nil {
+	if err := msg.Decode(status); err != nil {
 		return err
 	}
 
-	// Validate peer status
-	if peerStatus.NetworkID != hmp.networkID {
-		return fmt.Errorf("network ID mismatch: got %d, want %d", peerStatus.NetworkID, hmp.networkID)
+	if status.ProtocolVersion != local.ProtocolVersion {
+		return fmt.Errorf("%w: got %d, want %d", ErrProtocolVersionMismatch, status.ProtocolVersion, local.ProtocolVersion)
+	}
+	if status.NetworkID != local.NetworkID {
+		return fmt.Errorf("%w: got %d, want %d", ErrNetworkIDMismatch, status.NetworkID, local.NetworkID)
+	}
+	if status.Genesis != local.Genesis {
+		return fmt.Errorf("%w: got %x, want %x", ErrGenesisMismatch, status.Genesis, local.Genesis)
+	}
+	if hmp.forkFilter != nil {
+		if err := hmp.forkFilter(status.ForkID); err != nil {
+			return fmt.Errorf("%w: %v", ErrForkIDRejected, err)
+		}
 	}
-
-	// Update peer information
-	peer.position = peerStatus.Position
-	peer.head = peerStatus.Head
-	peer.distance = hmp.localPosition.Distance(peerStatus.Position)
-	peer.isNeighbor = peer.distance == 1
-
 	return nil
 }
 
+// handshakeDiscReason returns the reason a peer failing the handshake is
+// disconnected with
+func handshakeDiscReason(err error) p2p.DiscReason {
+	var reason p2p.DiscReason
+	switch {
+	case errors.As(err, &reason):
+		return reason
+	case errors.Is(err, ErrProtocolVersionMismatch), errors.Is(err, ErrNetworkIDMismatch), errors.Is(err, ErrGenesisMismatch), errors.Is(err, ErrForkIDRejected):
+		return p2p.DiscUselessPeer
+	default:
+		return p2p.DiscProtocolError
+	}
+}
+
 // handlePeer handles messages from a specific peer
 func (hmp *HexMeshProtocol) handlePeer(peer *HexPeer) {
+	done := make(chan struct{})
 	defer func() {
+		close(done)
 		hmp.RemovePeer(peer.id)
 		peer.conn.Disconnect(p2p.DiscSubprotocolError)
 	}()
+	go hmp.sendLoop(peer, done)
 
 	for {
 		msg, err := peer.rw.ReadMsg()
@@ -264,6 +462,31 @@ func (hmp *HexMeshProtocol) handlePeer(peer *HexPeer) {
 	}
 }
 
+// send queues a message to the peer. Sending from the message loop would block
+// it until the peer reads, while the peer may itself be blocked sending to this
+// side, and a broadcast would wait on its slowest peer.
+func (p *HexPeer) send(code uint64, data interface{}) {
+	select {
+	case p.queue <- queuedMsg{code: code, data: data}:
+	default:
+		log.Debug("Send queue backlogged, dropping message", "peer", p.id.String()[:8], "code", code)
+	}
+}
+
+// sendLoop sends the messages queued for a peer until its message loop ends
+func (hmp *HexMeshProtocol) sendLoop(peer *HexPeer, done chan struct{}) {
+	for {
+		select {
+		case msg := <-peer.queue:
+			if err := p2p.Send(peer.rw, msg.code, msg.data); err != nil {
+				log.Debug("Failed to send message", "peer", peer.id.String()[:8], "code", msg.code, "err", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
 // handleMessage handles a specific message from a peer
 func (hmp *HexMeshProtocol) handleMessage(peer *HexPeer, msg p2p.Msg) error {
 	defer msg.Discard()
@@ -283,6 +506,12 @@ func (hmp *HexMeshProtocol) handleMessage(peer *HexPeer, msg p2p.Msg) error {
 		return hmp.handleNeighborUpdate(peer, msg)
 	case HexMeshStateMsg:
 		return hmp.handleMeshState(peer, msg)
+	case HexBlockResponseMsg:
+		return hmp.handleBlockResponse(peer, msg)
+	case HexHeaderResponseMsg:
+		return hmp.handleHeaderResponse(peer, msg)
+	case HexAnnounceMsg:
+		return hmp.handleAnnounce(peer, msg)
 	default:
 		return fmt.Errorf("unknown message code: %d", msg.Code)
 	}
@@ -296,17 +525,24 @@ func (hmp *HexMeshProtocol) handleHexBlock(peer *HexPeer, msg p2p.Msg) error {
 	}
 
 	peer.lastSeen = time.Now()
+	peer.markBlock(block.Hash())
+	hmp.fetcher.forget(block.Hash())
 
+	return hmp.processBlock(&block)
+}
+
+// processBlock hands a block received from a peer to the block consumers
+func (hmp *HexMeshProtocol) processBlock(block *hexcore.HexBlock) error {
 	// Send to block channel for processing
 	select {
-	case hmp.blockCh <- &block:
+	case hmp.blockCh <- block:
 	default:
 		log.Warn("Block channel full, dropping block", "hash", block.Hash().Hex()[:8])
 	}
 
 	// Call block handler if set
 	if hmp.blockHandler != nil {
-		return hmp.blockHandler(&block)
+		return hmp.blockHandler(block)
 	}
 
 	return nil
@@ -320,6 +556,7 @@ func (hmp *HexMeshProtocol) handleHexHeader(peer *HexPeer, msg p2p.Msg) error {
 	}
 
 	peer.lastSeen = time.Now()
+	peer.markHeader(header.Hash())
 
 	// Send to header channel for processing
 	select {
@@ -336,40 +573,6 @@ func (hmp *HexMeshProtocol) handleHexHeader(peer *HexPeer, msg p2p.Msg) error {
 	return nil
 }
 
-// handleBlockRequest handles requests for specific blocks
-func (hmp *HexMeshProtocol) handleBlockRequest(peer *HexPeer, msg p2p.Msg) error {
-	var request struct {
-		RequestID uint64      `json:"requestId"`
-		Hash      common.Hash `json:"hash"`
-	}
-
-	if err := msg.Decode(&request); err != nil {
-		return err
-	}
-
-	// TODO: Look up block and send response
-	log.Debug("Received block request", "peer", peer.id.String()[:8], "hash", request.Hash.Hex()[:8])
-
-	return nil
-}
-
-// handleHeaderRequest handles requests for specific headers
-func (hmp *HexMeshProtocol) handleHeaderRequest(peer *HexPeer, msg p2p.Msg) error {
-	var request struct {
-		RequestID uint64      `json:"requestId"`
-		Hash      common.Hash `json:"hash"`
-	}
-
-	if err := msg.Decode(&request); err != nil {
-		return err
-	}
-
-	// TODO: Look up header and send response
-	log.Debug("Received header request", "peer", peer.id.String()[:8], "hash", request.Hash.Hex()[:8])
-
-	return nil
-}
-
 // handleHexProof handles hexagonal consensus proofs
 func (hmp *HexMeshProtocol) handleHexProof(peer *HexPeer, msg p2p.Msg) error {
 	var proof hexcore.HexaProof
@@ -395,58 +598,66 @@ func (hmp *HexMeshProtocol) handleNeighborUpdate(peer *HexPeer, msg p2p.Msg) err
 	}
 
 	// Update peer information
-	peer.position = update.Position
-	peer.head = update.Head
-	peer.distance = hmp.localPosition.Distance(update.Position)
-	peer.isNeighbor = peer.distance == 1
+	peer.setState(update.Position, update.Head, hmp.localPosition)
 	peer.lastSeen = time.Now()
 
-	log.Debug("Updated peer position", "peer", peer.id.String()[:8], "position", update.Position)
-
-	return nil
-}
-
-// handleMeshState handles mesh state synchronization
-func (hmp *HexMeshProtocol) handleMeshState(peer *HexPeer, msg p2p.Msg) error {
-	var state struct {
-		KnownBlocks  []common.Hash `json:"knownBlocks"`
-		KnownHeaders []common.Hash `json:"knownHeaders"`
+	// Catch up with heads missing locally
+	if hmp.downloader != nil && update.Head != (common.Hash{}) && !hmp.chain.HasHexBlock(update.Head) {
+		hmp.downloader.NewPeer(peer)
 	}
 
-	if err := msg.Decode(&state); err != nil {
-		return err
-	}
-
-	log.Debug("Received mesh state", "peer", peer.id.String()[:8], "blocks", len(state.KnownBlocks))
+	log.Debug("Updated peer position", "peer", peer.id.String()[:8], "position", update.Position)
 
-	// TODO: Process mesh state synchronization
 	return nil
 }
 
-// BroadcastHexBlock broadcasts a hex block to relevant peers
+// BroadcastHexBlock propagates a hex block to the peers not known to have it:
+// the full block is pushed to the square root of them, closest first, and
+// announced to the rest, which fetch it if no push reaches them
 func (hmp *HexMeshProtocol) BroadcastHexBlock(block *hexcore.HexBlock) {
-	hmp.peersMu.RLock()
-	defer hmp.peersMu.RUnlock()
+	hash := block.Hash()
 
+	hmp.peersMu.RLock()
+	var peers []*HexPeer
 	for _, peer := range hmp.peers {
-		// Send to neighbors and close peers
-		if peer.isNeighbor || peer.distance <= 3 {
-			if err := p2p.Send(peer.rw, HexBlockMsg, block); err != nil {
-				log.Debug("Failed to send block to peer", "peer", peer.id.String()[:8], "err", err)
-			}
+		if !peer.KnownBlock(hash) {
+			peers = append(peers, peer)
+		}
+	}
+	hmp.peersMu.RUnlock()
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Distance() < peers[j].Distance()
+	})
+	push := int(math.Sqrt(float64(len(peers))))
+	if push == 0 && len(peers) > 0 {
+		push = 1
+	}
+	announce := []HexAnnouncement{{Hash: hash, Position: block.Header().HexPosition}}
+
+	for i, peer := range peers {
+		peer.markBlock(hash)
+		if i < push {
+			peer.send(HexBlockMsg, block)
+		} else {
+			peer.send(HexAnnounceMsg, announce)
 		}
 	}
 }
 
-// BroadcastHexHeader broadcasts a hex header to relevant peers
+// BroadcastHexHeader broadcasts a hex header to the peers not known to have it
 func (hmp *HexMeshProtocol) BroadcastHexHeader(header *hexcore.HexHeader) {
+	hash := header.Hash()
+
 	hmp.peersMu.RLock()
 	defer hmp.peersMu.RUnlock()
 
 	for _, peer := range hmp.peers {
-		if err := p2p.Send(peer.rw, HexHeaderMsg, header); err != nil {
-			log.Debug("Failed to send header to peer", "peer", peer.id.String()[:8], "err", err)
+		if peer.KnownHeader(hash) {
+			continue
 		}
+		peer.markHeader(hash)
+		peer.send(HexHeaderMsg, header)
 	}
 }
 
@@ -457,12 +668,56 @@ func (hmp *HexMeshProtocol) SetLocalPosition(pos hexcore.HexCoordinate) {
 	// Update neighbor relationships
 	hmp.peersMu.Lock()
 	for _, peer := range hmp.peers {
-		peer.distance = hmp.localPosition.Distance(peer.position)
-		peer.isNeighbor = peer.distance == 1
+		peer.setLocal(pos)
 	}
 	hmp.peersMu.Unlock()
 }
 
+// SetChain sets the chain whose genesis and fork schedule peers must share,
+// which also serves the requests of peers, is reconciled with them and whose
+// fork choice head is advertised
+func (hmp *HexMeshProtocol) SetChain(chain *hexcore.HexChain) {
+	hmp.chain = chain
+	hmp.forkFilter = forkid.NewFilter(forkChain{chain})
+	hmp.reader = chain
+	hmp.SetMeshIndex(chain)
+}
+
+// SetMeshIndex sets the source of the blocks reconciled with peers
+func (hmp *HexMeshProtocol) SetMeshIndex(index MeshIndex) {
+	hmp.meshMu.Lock()
+	defer hmp.meshMu.Unlock()
+
+	hmp.index = index
+	hmp.mesh = nil
+}
+
+// SetChainReader sets the source of the blocks and headers served to peers
+func (hmp *HexMeshProtocol) SetChainReader(reader ChainReader) {
+	hmp.reader = reader
+}
+
+// head returns the current head of the local mesh, the fork choice head of the
+// chain if one is set
+func (hmp *HexMeshProtocol) head() common.Hash {
+	if hmp.chain != nil {
+		return hmp.chain.ForkChoice().Head()
+	}
+	return hmp.currentHead
+}
+
+// GetPeers returns all connected peers
+func (hmp *HexMeshProtocol) GetPeers() []*HexPeer {
+	hmp.peersMu.RLock()
+	defer hmp.peersMu.RUnlock()
+
+	peers := make([]*HexPeer, 0, len(hmp.peers))
+	for _, peer := range hmp.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
 // GetNeighborPeers returns peers that are direct neighbors
 func (hmp *HexMeshProtocol) GetNeighborPeers() []*HexPeer {
 	hmp.peersMu.RLock()
@@ -470,7 +725,7 @@ func (hmp *HexMeshProtocol) GetNeighborPeers() []*HexPeer {
 
 	var neighbors []*HexPeer
 	for _, peer := range hmp.peers {
-		if peer.isNeighbor {
+		if peer.IsNeighbor() {
 			neighbors = append(neighbors, peer)
 		}
 	}
@@ -501,16 +756,14 @@ func (hmp *HexMeshProtocol) sendHeartbeats() {
 		Head     common.Hash           `json:"head"`
 	}{
 		Position: hmp.localPosition,
-		Head:     hmp.currentHead,
+		Head:     hmp.head(),
 	}
 
 	hmp.peersMu.RLock()
 	defer hmp.peersMu.RUnlock()
 
 	for _, peer := range hmp.peers {
-		if err := p2p.Send(peer.rw, HexNeighborMsg, update); err != nil {
-			log.Debug("Failed to send heartbeat", "peer", peer.id.String()[:8], "err", err)
-		}
+		peer.send(HexNeighborMsg, update)
 	}
 }
 
@@ -563,6 +816,11 @@ func (hmp *HexMeshProtocol) SetHeaderHandler(handler func(*hexcore.HexHeader) er
 	hmp.headerHandler = handler
 }
 
+// SetPeerHandler sets the handler notified of peers completing the handshake
+func (hmp *HexMeshProtocol) SetPeerHandler(handler func(*HexPeer)) {
+	hmp.peerHandler = handler
+}
+
 // GetProtocolSpec returns the P2P protocol specification
 func (hmp *HexMeshProtocol) GetProtocolSpec() p2p.Protocol {
 	return p2p.Protocol{
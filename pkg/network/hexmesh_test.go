@@ -0,0 +1,187 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/params"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+// newTestChain creates an in-memory hex chain holding an empty genesis
+func newTestChain(t *testing.T) (*hexcore.HexChain, *hexcore.HexHeader) {
+	genesis := &hexcore.HexHeader{
+		Number:     common.Big0,
+		Difficulty: common.Big1,
+		Root:       types.EmptyRootHash,
+		TxHash:     types.EmptyTxsHash,
+	}
+	chain, err := hexcore.NewHexChain(rawdb.NewMemoryDatabase(), params.TestChainConfig, hexcore.NewHexBlock(genesis, nil, nil), nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	return chain, genesis
+}
+
+// writeTestBlock writes an empty block referencing the parents in slot order
+func writeTestBlock(t *testing.T, chain *hexcore.HexChain, number, time uint64, parents ...*hexcore.HexHeader) *hexcore.HexBlock {
+	return writeTestHeader(t, chain, testHeader(number, time, parents...))
+}
+
+// testHeader creates a header referencing the parents in slot order
+func testHeader(number, time uint64, parents ...*hexcore.HexHeader) *hexcore.HexHeader {
+	header := &hexcore.HexHeader{
+		Number:     new(big.Int).SetUint64(number),
+		Difficulty: common.Big1,
+		Time:       time,
+		TxHash:     types.EmptyTxsHash,
+	}
+	for i, parent := range parents {
+		header.ParentHashes[i] = parent.Hash()
+		header.NeighborCount++
+	}
+	return header
+}
+
+// writeTestHeader writes an empty block with the given header
+func writeTestHeader(t *testing.T, chain *hexcore.HexChain, header *hexcore.HexHeader) *hexcore.HexBlock {
+	statedb, err := chain.GetState(header.PrimaryParent())
+	if err != nil {
+		t.Fatalf("failed to open parent state: %v", err)
+	}
+	header.Root = statedb.IntermediateRoot(true)
+	block := hexcore.NewHexBlock(header, nil, nil)
+	if err := chain.WriteBlock(block, nil, statedb); err != nil {
+		t.Fatalf("failed to write block %d: %v", header.Number, err)
+	}
+	return block
+}
+
+// bufferedRW is one end of a message pipe whose writes do not wait for the
+// reader, like a network connection with ample buffers
+type bufferedRW struct {
+	in, out chan p2p.Msg
+	closed  chan struct{}
+	once    *sync.Once
+}
+
+// bufferedPipe creates a buffered message pipe
+func bufferedPipe() (*bufferedRW, *bufferedRW) {
+	var (
+		a2b, b2a = make(chan p2p.Msg, 1024), make(chan p2p.Msg, 1024)
+		closed   = make(chan struct{})
+		once     = new(sync.Once)
+	)
+	return &bufferedRW{b2a, a2b, closed, once}, &bufferedRW{a2b, b2a, closed, once}
+}
+
+func (rw *bufferedRW) WriteMsg(msg p2p.Msg) error {
+	data, err := io.ReadAll(msg.Payload)
+	if err != nil {
+		return err
+	}
+	msg.Payload = bytes.NewReader(data)
+	select {
+	case rw.out <- msg:
+		return nil
+	case <-rw.closed:
+		return p2p.ErrPipeClosed
+	}
+}
+
+func (rw *bufferedRW) ReadMsg() (p2p.Msg, error) {
+	select {
+	case msg := <-rw.in:
+		return msg, nil
+	case <-rw.closed:
+		return p2p.Msg{}, p2p.ErrPipeClosed
+	}
+}
+
+func (rw *bufferedRW) Close() {
+	rw.once.Do(func() { close(rw.closed) })
+}
+
+func TestHandshake(t *testing.T) {
+	chain, genesis := newTestChain(t)
+	config := DefaultHexMeshConfig()
+	config.HandshakeTimeout = 100 * time.Millisecond
+	hmp := NewHexMeshProtocol(config)
+	hmp.SetChain(chain)
+	defer close(hmp.quitCh)
+
+	head := writeTestBlock(t, chain, 1, 1, genesis)
+	forkID := forkid.NewIDWithChain(forkChain{chain})
+	tests := []struct {
+		name   string
+		status *HexStatus // Remote status, nil to stay silent
+		err    error
+		reason p2p.DiscReason
+	}{
+		{"matching", &HexStatus{NetworkID: config.NetworkID, Genesis: genesis.Hash(), ForkID: forkID}, nil, 0},
+		{"other version", &HexStatus{ProtocolVersion: 1, NetworkID: config.NetworkID, Genesis: genesis.Hash(), ForkID: forkID}, ErrProtocolVersionMismatch, p2p.DiscUselessPeer},
+		{"other network", &HexStatus{NetworkID: 1, Genesis: genesis.Hash(), ForkID: forkID}, ErrNetworkIDMismatch, p2p.DiscUselessPeer},
+		{"other genesis", &HexStatus{NetworkID: config.NetworkID, Genesis: common.HexToHash("0x01"), ForkID: forkID}, ErrGenesisMismatch, p2p.DiscUselessPeer},
+		{"other fork", &HexStatus{NetworkID: config.NetworkID, Genesis: genesis.Hash(), ForkID: forkid.ID{Hash: [4]byte{1, 2, 3, 4}}}, ErrForkIDRejected, p2p.DiscUselessPeer},
+		{"silent", nil, p2p.DiscReadTimeout, p2p.DiscReadTimeout},
+	}
+	for i, tt := range tests {
+		local, remote := p2p.MsgPipe()
+
+		errc := make(chan error, 1)
+		go func() {
+			errc <- hmp.AddPeer(p2p.NewPeer(enode.ID{byte(i + 1)}, tt.name, nil), local)
+		}()
+
+		// The local status always carries the genesis, fork ID and chain head
+		msg, err := remote.ReadMsg()
+		if err != nil {
+			t.Fatalf("%s: failed to read status: %v", tt.name, err)
+		}
+		var status HexStatus
+		if err := msg.Decode(&status); err != nil {
+			t.Fatalf("%s: failed to decode status: %v", tt.name, err)
+		}
+		if status.Genesis != genesis.Hash() || status.ForkID != forkID {
+			t.Errorf("%s: local status genesis %x fork %v, want %x %v", tt.name, status.Genesis, status.ForkID, genesis.Hash(), forkID)
+		}
+		if status.Head != head.Hash() {
+			t.Errorf("%s: local status head %x, want %x", tt.name, status.Head, head.Hash())
+		}
+		if tt.status != nil {
+			if tt.status.ProtocolVersion == 0 {
+				tt.status.ProtocolVersion = HexMeshProtocolVersion
+			}
+			tt.status.Position = hexcore.NewHexCoordinate(1, 0)
+			if err := p2p.Send(remote, HexStatusMsg, tt.status); err != nil {
+				t.Fatalf("%s: failed to send status: %v", tt.name, err)
+			}
+		}
+
+		err = <-errc
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+		if err != nil {
+			if reason := handshakeDiscReason(err); reason != tt.reason {
+				t.Errorf("%s: disconnect reason %v, want %v", tt.name, reason, tt.reason)
+			}
+		} else if peers := hmp.GetNeighborPeers(); len(peers) != 1 {
+			t.Errorf("%s: neighbor peers: got %d, want 1", tt.name, len(peers))
+		}
+		local.Close()
+		remote.Close()
+	}
+}
@@ -0,0 +1,289 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+const (
+	reconcileBranches  = 16  // Subranges a differing range is split into
+	reconcileListLimit = 32  // Ranges holding at most this many blocks are sent as lists
+	maxReconcileRanges = 512 // Ranges accepted per mesh state message
+
+	meshSnapshotAge = 10 * time.Second // Time a mesh snapshot answers rounds before it is retaken
+
+	meshPositionLength = 16 + common.HashLength // Encoded coordinate and block hash
+)
+
+// Modes of a mesh range
+const (
+	rangeSkip        uint8 = iota // Range needs no further work
+	rangeFingerprint              // Fingerprint of the sender's blocks in the range
+	rangeList                     // Sender's blocks in the range, answered if the receiver has others
+	rangeListReply                // Sender's blocks in the range, never answered
+)
+
+var ErrInvalidMeshState = errors.New("invalid mesh state")
+
+// MeshIndex orders the blocks of the local mesh for reconciliation
+type MeshIndex interface {
+	MeshIterator(start []byte) *hexcore.HashIterator
+}
+
+// MeshRange is a range of mesh positions in a reconciliation message. The
+// ranges of a message are contiguous: each starts at the upper bound of the
+// previous one, or the start of the index, and ends before its own upper
+// bound, an empty bound extending to the end of the index.
+type MeshRange struct {
+	Upper       []byte
+	Mode        uint8
+	Count       uint64      // Number of blocks in the range, for fingerprints
+	Fingerprint common.Hash // XOR of the block hashes in the range, for fingerprints
+	Positions   [][]byte    // Positions of the blocks in the range, for lists
+}
+
+// MeshState is a round of range-based set reconciliation. Peers answer the
+// fingerprints that differ from their own with fingerprints of subranges, or
+// with the block lists of small ranges, until each side knows the blocks it
+// misses; those are then fetched through the block request path.
+type MeshState struct {
+	Ranges []MeshRange
+}
+
+// ReconcileMesh starts reconciling the local mesh with that of a peer
+func (hmp *HexMeshProtocol) ReconcileMesh(peer *HexPeer) error {
+	mesh := hmp.meshSnapshot()
+	if mesh == nil {
+		return errors.New("no mesh index to reconcile")
+	}
+
+	state := &MeshState{Ranges: []MeshRange{{Mode: rangeList, Positions: mesh.items}}}
+	if len(mesh.items) > reconcileListLimit {
+		count, fingerprint := mesh.fingerprint(0, len(mesh.items))
+		state.Ranges[0] = MeshRange{Mode: rangeFingerprint, Count: count, Fingerprint: fingerprint}
+	}
+	return p2p.Send(peer.rw, HexMeshStateMsg, state)
+}
+
+// handleMeshState answers a reconciliation round and has the fetcher retrieve
+// the blocks the peer turned out to have in addition to the local ones
+func (hmp *HexMeshProtocol) handleMeshState(peer *HexPeer, msg p2p.Msg) error {
+	var state MeshState
+	if err := msg.Decode(&state); err != nil {
+		return err
+	}
+	peer.lastSeen = time.Now()
+
+	mesh := hmp.meshSnapshot()
+	if mesh == nil {
+		log.Debug("Ignoring mesh state without a mesh index", "peer", peer.id.String()[:8])
+		return nil
+	}
+	reply, missing, err := hmp.reconcile(mesh, &state)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		hmp.fetcher.request(peer, missing)
+	}
+	if reply != nil {
+		peer.send(HexMeshStateMsg, reply)
+	}
+	return nil
+}
+
+// reconcile compares the ranges of a peer with a snapshot of the local mesh,
+// returning the answer to send, if any, and the hashes of the blocks missing
+// locally
+func (hmp *HexMeshProtocol) reconcile(mesh *meshSnapshot, state *MeshState) (*MeshState, []common.Hash, error) {
+	if len(state.Ranges) > maxReconcileRanges {
+		return nil, nil, fmt.Errorf("%w: %d ranges", ErrInvalidMeshState, len(state.Ranges))
+	}
+	var (
+		reply   MeshState
+		missing []common.Hash
+		lower   []byte
+		ended   bool
+	)
+	skip := func(upper []byte) {
+		if n := len(reply.Ranges); n > 0 && reply.Ranges[n-1].Mode == rangeSkip {
+			reply.Ranges[n-1].Upper = upper
+			return
+		}
+		reply.Ranges = append(reply.Ranges, MeshRange{Upper: upper, Mode: rangeSkip})
+	}
+	for _, r := range state.Ranges {
+		if ended || (len(r.Upper) > 0 && lower != nil && bytes.Compare(r.Upper, lower) <= 0) {
+			return nil, nil, fmt.Errorf("%w: ranges out of order", ErrInvalidMeshState)
+		}
+		// Leave the rest to the next round once the answer is full
+		if len(reply.Ranges) >= maxReconcileRanges-reconcileBranches-1 {
+			start, end := mesh.span(lower, nil)
+			count, fingerprint := mesh.fingerprint(start, end)
+			reply.Ranges = append(reply.Ranges, MeshRange{Mode: rangeFingerprint, Count: count, Fingerprint: fingerprint})
+			break
+		}
+		start, end := mesh.span(lower, r.Upper)
+
+		switch r.Mode {
+		case rangeSkip:
+			skip(r.Upper)
+
+		case rangeFingerprint:
+			if count, fingerprint := mesh.fingerprint(start, end); count == r.Count && fingerprint == r.Fingerprint {
+				skip(r.Upper)
+			} else {
+				reply.Ranges = append(reply.Ranges, mesh.split(r.Upper, start, end)...)
+			}
+
+		case rangeList, rangeListReply:
+			if len(r.Positions) > reconcileListLimit {
+				return nil, nil, fmt.Errorf("%w: list of %d blocks", ErrInvalidMeshState, len(r.Positions))
+			}
+			// The local blocks are searched, the peer's list being the short one
+			var (
+				theirs = make(map[string]bool, len(r.Positions))
+				shared int
+			)
+			for _, pos := range r.Positions {
+				if len(pos) != meshPositionLength || (lower != nil && bytes.Compare(pos, lower) < 0) || (len(r.Upper) > 0 && bytes.Compare(pos, r.Upper) >= 0) {
+					return nil, nil, fmt.Errorf("%w: position %x outside its range", ErrInvalidMeshState, pos)
+				}
+				if theirs[string(pos)] {
+					continue
+				}
+				theirs[string(pos)] = true
+				if mesh.contains(pos, start, end) {
+					shared++
+				} else {
+					missing = append(missing, common.BytesToHash(pos[16:]))
+				}
+			}
+			switch {
+			case r.Mode == rangeListReply || shared == end-start:
+				skip(r.Upper)
+			case end-start <= reconcileListLimit:
+				reply.Ranges = append(reply.Ranges, MeshRange{Upper: r.Upper, Mode: rangeListReply, Positions: mesh.items[start:end]})
+			default:
+				reply.Ranges = append(reply.Ranges, mesh.split(r.Upper, start, end)...)
+			}
+
+		default:
+			return nil, nil, fmt.Errorf("%w: unknown range mode %d", ErrInvalidMeshState, r.Mode)
+		}
+		lower, ended = r.Upper, len(r.Upper) == 0
+	}
+	// Nothing left to settle once every range is skipped
+	if len(reply.Ranges) == 0 || (len(reply.Ranges) == 1 && reply.Ranges[0].Mode == rangeSkip) {
+		return nil, missing, nil
+	}
+	return &reply, missing, nil
+}
+
+// meshSnapshot is a sorted copy of the mesh index along with the running
+// fingerprints of its positions, which answers the ranges of reconciliation
+// rounds by binary search instead of walking the index
+type meshSnapshot struct {
+	items [][]byte
+	xors  []common.Hash // Fingerprints of the items before each index
+	taken time.Time
+}
+
+// newMeshSnapshot copies the positions of a mesh index
+func newMeshSnapshot(index MeshIndex) *meshSnapshot {
+	it := index.MeshIterator(nil)
+	defer it.Release()
+
+	snap := &meshSnapshot{xors: []common.Hash{{}}, taken: time.Now()}
+	for it.Next() {
+		pos := it.Position()
+		xor := snap.xors[len(snap.xors)-1]
+		for i, b := range pos[len(pos)-common.HashLength:] {
+			xor[i] ^= b
+		}
+		snap.items = append(snap.items, pos)
+		snap.xors = append(snap.xors, xor)
+	}
+	return snap
+}
+
+// meshSnapshot returns the snapshot of the local mesh, or nil without a mesh
+// index, retaking it once stale so that rounds are not each answered from a
+// fresh walk of the index
+func (hmp *HexMeshProtocol) meshSnapshot() *meshSnapshot {
+	hmp.meshMu.Lock()
+	defer hmp.meshMu.Unlock()
+
+	if hmp.index == nil {
+		return nil
+	}
+	if hmp.mesh == nil || time.Since(hmp.mesh.taken) > meshSnapshotAge {
+		hmp.mesh = newMeshSnapshot(hmp.index)
+	}
+	return hmp.mesh
+}
+
+// span returns the bounds of the items from lower up to upper, an empty upper
+// bound extending to the end of the snapshot
+func (s *meshSnapshot) span(lower, upper []byte) (int, int) {
+	start := sort.Search(len(s.items), func(i int) bool {
+		return bytes.Compare(s.items[i], lower) >= 0
+	})
+	end := len(s.items)
+	if len(upper) > 0 {
+		end = sort.Search(len(s.items), func(i int) bool {
+			return bytes.Compare(s.items[i], upper) >= 0
+		})
+	}
+	return start, max(start, end)
+}
+
+// contains reports whether a position is among the items from start to end
+func (s *meshSnapshot) contains(pos []byte, start, end int) bool {
+	i := start + sort.Search(end-start, func(i int) bool {
+		return bytes.Compare(s.items[start+i], pos) >= 0
+	})
+	return i < end && bytes.Equal(s.items[i], pos)
+}
+
+// fingerprint summarizes the items from start to end by their number and the
+// XOR of their block hashes
+func (s *meshSnapshot) fingerprint(start, end int) (uint64, common.Hash) {
+	var fingerprint common.Hash
+	for i := range fingerprint {
+		fingerprint[i] = s.xors[start][i] ^ s.xors[end][i]
+	}
+	return uint64(end - start), fingerprint
+}
+
+// split describes the items from start to end of a range ending at upper, as a
+// list if there are few, or as fingerprints of up to reconcileBranches subranges
+func (s *meshSnapshot) split(upper []byte, start, end int) []MeshRange {
+	if end-start <= reconcileListLimit {
+		return []MeshRange{{Upper: upper, Mode: rangeList, Positions: s.items[start:end]}}
+	}
+	var (
+		ranges []MeshRange
+		size   = (end - start + reconcileBranches - 1) / reconcileBranches
+	)
+	for from := start; from < end; from += size {
+		to := min(from+size, end)
+
+		bound := upper
+		if to < end {
+			bound = s.items[to]
+		}
+		count, fingerprint := s.fingerprint(from, to)
+		ranges = append(ranges, MeshRange{Upper: bound, Mode: rangeFingerprint, Count: count, Fingerprint: fingerprint})
+	}
+	return ranges
+}
@@ -0,0 +1,120 @@
+package network
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+// countingIndex counts the walks of a mesh index
+type countingIndex struct {
+	MeshIndex
+	walks *atomic.Int64
+}
+
+func (i countingIndex) MeshIterator(start []byte) *hexcore.HashIterator {
+	i.walks.Add(1)
+	return i.MeshIndex.MeshIterator(start)
+}
+
+func TestReconcileMesh(t *testing.T) {
+	local, genesis := newTestChain(t)
+	remote, _ := newTestChain(t)
+
+	// A shared mesh spread over many cells, then a branch on each side
+	place := func(header *hexcore.HexHeader, i uint64) *hexcore.HexHeader {
+		header.HexPosition = hexcore.NewHexCoordinate(int64(i%7)-3, int64(i%5)-2)
+		return header
+	}
+	shared := genesis
+	for i := uint64(1); i <= 200; i++ {
+		header := place(testHeader(i, i, shared), i)
+		writeTestHeader(t, local, header)
+		writeTestHeader(t, remote, header)
+		shared = header
+	}
+	grow := func(chain *hexcore.HexChain, n int, time uint64) map[common.Hash]bool {
+		hashes := make(map[common.Hash]bool)
+		parent := shared
+		for i := 0; i < n; i++ {
+			number := parent.Number.Uint64() + 1
+			parent = writeTestHeader(t, chain, place(testHeader(number, time+number, parent), number)).Header()
+			hashes[parent.Hash()] = true
+		}
+		return hashes
+	}
+	localOnly, remoteOnly := grow(local, 40, 1000), grow(remote, 70, 2000)
+
+	// Each side collects the blocks it fetches
+	received := func(hmp *HexMeshProtocol) chan common.Hash {
+		ch := make(chan common.Hash, 128)
+		hmp.SetBlockHandler(func(block *hexcore.HexBlock) error {
+			ch <- block.Hash()
+			return nil
+		})
+		return ch
+	}
+	client, server := NewHexMeshProtocol(nil), NewHexMeshProtocol(nil)
+	client.SetChain(local)
+	server.SetChain(remote)
+	clientBlocks, serverBlocks := received(client), received(server)
+
+	// Both sides start at once, answering each other over unbuffered pipes
+	peer := connectPeers(t, server, client, enode.ID{1})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ReconcileMesh(server.GetPeers()[0])
+	}()
+	if err := client.ReconcileMesh(peer); err != nil {
+		t.Fatalf("failed to start reconciliation: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to start reconciliation: %v", err)
+	}
+	collect := func(name string, ch chan common.Hash, want map[common.Hash]bool) {
+		timeout := time.After(5 * time.Second)
+		for got := make(map[common.Hash]bool); len(got) < len(want); {
+			select {
+			case hash := <-ch:
+				if !want[hash] {
+					t.Errorf("%s fetched unexpected block %x", name, hash)
+				}
+				got[hash] = true
+			case <-timeout:
+				t.Fatalf("%s fetched %d of %d missing blocks", name, len(got), len(want))
+			}
+		}
+	}
+	collect("client", clientBlocks, remoteOnly)
+	collect("server", serverBlocks, localOnly)
+
+	// Identical meshes settle after a single round
+	reply, missing, err := client.reconcile(client.meshSnapshot(), &MeshState{Ranges: []MeshRange{fingerprintRange(client)}})
+	if err != nil || reply != nil || len(missing) != 0 {
+		t.Errorf("identical mesh: reply %v, missing %d, err %v", reply, len(missing), err)
+	}
+
+	// Rounds are answered from a snapshot rather than walks of the index
+	var walks atomic.Int64
+	client.SetMeshIndex(countingIndex{local, &walks})
+	for i := 0; i < 3; i++ {
+		if _, _, err := client.reconcile(client.meshSnapshot(), &MeshState{Ranges: []MeshRange{{Mode: rangeFingerprint}}}); err != nil {
+			t.Fatalf("round %d failed: %v", i, err)
+		}
+	}
+	if n := walks.Load(); n != 1 {
+		t.Errorf("index walks: got %d, want 1", n)
+	}
+}
+
+// fingerprintRange describes the whole local mesh with a single fingerprint
+func fingerprintRange(hmp *HexMeshProtocol) MeshRange {
+	mesh := hmp.meshSnapshot()
+	count, fingerprint := mesh.fingerprint(0, len(mesh.items))
+	return MeshRange{Mode: rangeFingerprint, Count: count, Fingerprint: fingerprint}
+}
@@ -0,0 +1,337 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/trie"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+var (
+	ErrTooManyRequests     = errors.New("too many concurrent requests")
+	ErrRequestTimeout      = errors.New("request timed out")
+	ErrUnrequestedResponse = errors.New("response does not match a request")
+	ErrInvalidResponse     = errors.New("response contains unrequested data")
+	ErrPeerDisconnected    = errors.New("peer disconnected")
+)
+
+// ChainReader serves the blocks and headers requested by peers
+type ChainReader interface {
+	GetHexHeader(hash common.Hash) *hexcore.HexHeader
+	GetHexBlock(hash common.Hash) *hexcore.HexBlock
+	HasHexBlock(hash common.Hash) bool
+	BlocksAtNumber(number uint64) []common.Hash
+}
+
+// HexBlockRequest asks a peer for blocks by hash
+type HexBlockRequest struct {
+	RequestID uint64
+	Hashes    []common.Hash
+}
+
+// HexHeaderRequest asks a peer for headers, either by hash or, when no hashes
+// are given, for all headers numbered from Origin to Origin+Amount-1
+type HexHeaderRequest struct {
+	RequestID uint64
+	Hashes    []common.Hash
+	Origin    uint64
+	Amount    uint64
+}
+
+// HexBlockResponse answers a block request with the blocks the peer knows
+type HexBlockResponse struct {
+	RequestID uint64
+	Blocks    []*hexcore.HexBlock
+}
+
+// HexHeaderResponse answers a header request with the headers the peer knows
+type HexHeaderResponse struct {
+	RequestID uint64
+	Headers   []*hexcore.HexHeader
+}
+
+// RequestBlocks fetches blocks by hash, returning the ones the peer knows
+func (p *HexPeer) RequestBlocks(hashes []common.Hash) ([]*hexcore.HexBlock, error) {
+	if len(hashes) > MaxBlocksServe {
+		return nil, fmt.Errorf("too many blocks requested: %d > max %d", len(hashes), MaxBlocksServe)
+	}
+	res, err := p.request(HexBlockRequestMsg, func(id uint64) interface{} {
+		return &HexBlockRequest{RequestID: id, Hashes: hashes}
+	})
+	if err != nil {
+		return nil, err
+	}
+	blocks := res.(*HexBlockResponse).Blocks
+
+	wanted := make(map[common.Hash]bool, len(hashes))
+	for _, hash := range hashes {
+		wanted[hash] = true
+	}
+	for _, block := range blocks {
+		if !wanted[block.Hash()] && !wanted[block.Header().ToEthHeader().Hash()] {
+			return nil, fmt.Errorf("%w: block %x", ErrInvalidResponse, block.Hash())
+		}
+		if err := verifyBody(block); err != nil {
+			return nil, fmt.Errorf("%w: block %x: %v", ErrInvalidResponse, block.Hash(), err)
+		}
+	}
+	return blocks, nil
+}
+
+// verifyBody checks that the body of a block matches the roots its header
+// commits to, as the block hash only covers the header
+func verifyBody(block *hexcore.HexBlock) error {
+	header := block.Header()
+	if hash := types.DeriveSha(types.Transactions(block.Transactions()), trie.NewStackTrie(nil)); hash != header.TxHash {
+		return fmt.Errorf("transaction root %x, header has %x", hash, header.TxHash)
+	}
+	if header.WithdrawalsHash == nil {
+		if len(block.Withdrawals()) > 0 {
+			return errors.New("withdrawals without withdrawals root")
+		}
+		return nil
+	}
+	if hash := types.DeriveSha(types.Withdrawals(block.Withdrawals()), trie.NewStackTrie(nil)); hash != *header.WithdrawalsHash {
+		return fmt.Errorf("withdrawals root %x, header has %x", hash, *header.WithdrawalsHash)
+	}
+	return nil
+}
+
+// RequestHeaders fetches headers by hash, returning the ones the peer knows
+func (p *HexPeer) RequestHeaders(hashes []common.Hash) ([]*hexcore.HexHeader, error) {
+	if len(hashes) > MaxHeadersServe {
+		return nil, fmt.Errorf("too many headers requested: %d > max %d", len(hashes), MaxHeadersServe)
+	}
+	headers, err := p.requestHeaders(&HexHeaderRequest{Hashes: hashes})
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[common.Hash]bool, len(hashes))
+	for _, hash := range hashes {
+		wanted[hash] = true
+	}
+	for _, header := range headers {
+		if !wanted[header.Hash()] && !wanted[header.ToEthHeader().Hash()] {
+			return nil, fmt.Errorf("%w: header %x", ErrInvalidResponse, header.Hash())
+		}
+	}
+	return headers, nil
+}
+
+// RequestHeaderRange fetches the headers of all blocks numbered from origin to
+// origin+amount-1 the peer knows
+func (p *HexPeer) RequestHeaderRange(origin, amount uint64) ([]*hexcore.HexHeader, error) {
+	headers, err := p.requestHeaders(&HexHeaderRequest{Origin: origin, Amount: amount})
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range headers {
+		if number := header.Number.Uint64(); number < origin || number-origin >= amount {
+			return nil, fmt.Errorf("%w: header %x numbered %d outside [%d, %d)", ErrInvalidResponse, header.Hash(), number, origin, origin+amount)
+		}
+	}
+	return headers, nil
+}
+
+// requestHeaders sends a header query, filling in its request ID
+func (p *HexPeer) requestHeaders(query *HexHeaderRequest) ([]*hexcore.HexHeader, error) {
+	res, err := p.request(HexHeaderRequestMsg, func(id uint64) interface{} {
+		query.RequestID = id
+		return query
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*HexHeaderResponse).Headers, nil
+}
+
+// request registers a pending request, sends the query built for its ID and
+// waits for the matching response until RequestTimeout
+func (p *HexPeer) request(code uint64, build func(id uint64) interface{}) (interface{}, error) {
+	p.reqMu.Lock()
+	if p.dropped {
+		p.reqMu.Unlock()
+		return nil, ErrPeerDisconnected
+	}
+	if len(p.requests) >= MaxConcurrentRequests {
+		p.reqMu.Unlock()
+		return nil, ErrTooManyRequests
+	}
+	p.reqID++
+	req := &PendingRequest{
+		ID:        p.reqID,
+		Type:      uint8(code),
+		Data:      build(p.reqID),
+		Timestamp: time.Now(),
+		Response:  make(chan interface{}, 1),
+	}
+	p.requests[req.ID] = req
+	p.reqMu.Unlock()
+
+	if err := p2p.Send(p.rw, code, req.Data); err != nil {
+		p.takeRequest(req.ID)
+		return nil, err
+	}
+	timeout := time.NewTimer(time.Duration(RequestTimeout) * time.Second)
+	defer timeout.Stop()
+
+	select {
+	case res, ok := <-req.Response:
+		if !ok {
+			return nil, p.failure() // Expired by the stale request cleanup or the peer dropped
+		}
+		return res, nil
+	case <-timeout.C:
+		if p.takeRequest(req.ID) != nil {
+			return nil, ErrRequestTimeout
+		}
+		// The response won the race against the timeout
+		if res, ok := <-req.Response; ok {
+			return res, nil
+		}
+		return nil, p.failure()
+	}
+}
+
+// failure returns the error of a request whose response channel was closed
+func (p *HexPeer) failure() error {
+	p.reqMu.RLock()
+	defer p.reqMu.RUnlock()
+
+	if p.dropped {
+		return ErrPeerDisconnected
+	}
+	return ErrRequestTimeout
+}
+
+// failRequests fails the pending requests of a peer leaving the mesh and
+// refuses new ones, so callers do not wait for the timeout
+func (p *HexPeer) failRequests() {
+	p.reqMu.Lock()
+	defer p.reqMu.Unlock()
+
+	p.dropped = true
+	for id, req := range p.requests {
+		close(req.Response)
+		delete(p.requests, id)
+	}
+}
+
+// takeRequest removes a pending request, returning nil if it is not pending.
+// Whoever takes a request owns its response channel.
+func (p *HexPeer) takeRequest(id uint64) *PendingRequest {
+	p.reqMu.Lock()
+	defer p.reqMu.Unlock()
+
+	req := p.requests[id]
+	delete(p.requests, id)
+	return req
+}
+
+// deliver hands a response to the pending request it answers. Responses to
+// expired requests are dropped, responses of the wrong kind are an error.
+func (p *HexPeer) deliver(id uint64, code uint64, res interface{}) error {
+	p.reqMu.Lock()
+	req, ok := p.requests[id]
+	if ok && uint64(req.Type) != code {
+		p.reqMu.Unlock()
+		return fmt.Errorf("%w: id %d answers code %d, not %d", ErrUnrequestedResponse, id, req.Type, code)
+	}
+	delete(p.requests, id)
+	p.reqMu.Unlock()
+
+	if !ok {
+		log.Debug("Dropping response to unknown request", "peer", p.id.String()[:8], "id", id)
+		return nil
+	}
+	req.Response <- res
+	return nil
+}
+
+// handleBlockRequest serves the requested blocks the local chain knows
+func (hmp *HexMeshProtocol) handleBlockRequest(peer *HexPeer, msg p2p.Msg) error {
+	var request HexBlockRequest
+	if err := msg.Decode(&request); err != nil {
+		return err
+	}
+	peer.lastSeen = time.Now()
+
+	response := &HexBlockResponse{RequestID: request.RequestID}
+	if hmp.reader != nil {
+		for i, hash := range request.Hashes {
+			if i >= MaxBlocksServe {
+				break
+			}
+			if block := hmp.reader.GetHexBlock(hash); block != nil {
+				response.Blocks = append(response.Blocks, block)
+			}
+		}
+	}
+	peer.send(HexBlockResponseMsg, response)
+	return nil
+}
+
+// handleHeaderRequest serves the requested headers the local chain knows
+func (hmp *HexMeshProtocol) handleHeaderRequest(peer *HexPeer, msg p2p.Msg) error {
+	var request HexHeaderRequest
+	if err := msg.Decode(&request); err != nil {
+		return err
+	}
+	peer.lastSeen = time.Now()
+
+	response := &HexHeaderResponse{RequestID: request.RequestID}
+	if hmp.reader != nil {
+		if len(request.Hashes) > 0 {
+			for i, hash := range request.Hashes {
+				if i >= MaxHeadersServe {
+					break
+				}
+				if header := hmp.reader.GetHexHeader(hash); header != nil {
+					response.Headers = append(response.Headers, header)
+				}
+			}
+		} else {
+			// Serve whole numbers only, several blocks can share a number
+			for n := uint64(0); n < request.Amount && n < MaxHeadersServe; n++ {
+				hashes := hmp.reader.BlocksAtNumber(request.Origin + n)
+				if len(response.Headers)+len(hashes) > MaxHeadersServe {
+					break
+				}
+				for _, hash := range hashes {
+					if header := hmp.reader.GetHexHeader(hash); header != nil {
+						response.Headers = append(response.Headers, header)
+					}
+				}
+			}
+		}
+	}
+	peer.send(HexHeaderResponseMsg, response)
+	return nil
+}
+
+// handleBlockResponse matches a block response to its request
+func (hmp *HexMeshProtocol) handleBlockResponse(peer *HexPeer, msg p2p.Msg) error {
+	var response HexBlockResponse
+	if err := msg.Decode(&response); err != nil {
+		return err
+	}
+	peer.lastSeen = time.Now()
+	return peer.deliver(response.RequestID, HexBlockRequestMsg, &response)
+}
+
+// handleHeaderResponse matches a header response to its request
+func (hmp *HexMeshProtocol) handleHeaderResponse(peer *HexPeer, msg p2p.Msg) error {
+	var response HexHeaderResponse
+	if err := msg.Decode(&response); err != nil {
+		return err
+	}
+	peer.lastSeen = time.Now()
+	return peer.deliver(response.RequestID, HexHeaderRequestMsg, &response)
+}
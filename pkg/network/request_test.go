@@ -0,0 +1,178 @@
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	hexcore "github.com/hexagonal-chain/hexchain/pkg/core"
+)
+
+// lyingReader serves the genesis for every block requested
+type lyingReader struct {
+	*hexcore.HexChain
+}
+
+func (r lyingReader) GetHexBlock(hash common.Hash) *hexcore.HexBlock {
+	return r.HexChain.GetHexBlock(r.HexChain.Genesis().Hash())
+}
+
+// stuffingReader serves blocks with a transaction their header does not commit to
+type stuffingReader struct {
+	*hexcore.HexChain
+}
+
+func (r stuffingReader) GetHexBlock(hash common.Hash) *hexcore.HexBlock {
+	block := r.HexChain.GetHexBlock(hash)
+	if block == nil {
+		return nil
+	}
+	tx := types.NewTx(&types.LegacyTx{Nonce: 1, Gas: 21000})
+	return hexcore.NewHexBlockWithBody(block.Header(), &hexcore.HexBody{Transactions: []*types.Transaction{tx}})
+}
+
+// stallingReader holds every block request until released
+type stallingReader struct {
+	*hexcore.HexChain
+	release chan struct{}
+}
+
+func (r stallingReader) GetHexBlock(hash common.Hash) *hexcore.HexBlock {
+	<-r.release
+	return r.HexChain.GetHexBlock(hash)
+}
+
+// connectPeers runs the handshake between two protocols, returning the peer
+// the client sees the server as
+func connectPeers(t *testing.T, server, client *HexMeshProtocol, serverID enode.ID) *HexPeer {
+	serverRW, clientRW := p2p.MsgPipe()
+	t.Cleanup(func() {
+		serverRW.Close()
+		clientRW.Close()
+	})
+	clientID := enode.ID{0xff}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.AddPeer(p2p.NewPeer(clientID, "client", nil), serverRW)
+	}()
+	if err := client.AddPeer(p2p.NewPeer(serverID, "server", nil), clientRW); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	client.peersMu.RLock()
+	defer client.peersMu.RUnlock()
+	return client.peers[serverID]
+}
+
+func TestRequests(t *testing.T) {
+	chain, genesis := newTestChain(t)
+	east := writeTestBlock(t, chain, 1, 1, genesis)
+	west := writeTestBlock(t, chain, 1, 2, genesis)
+	merge := writeTestBlock(t, chain, 2, 3, east.Header(), west.Header())
+
+	server, client := NewHexMeshProtocol(nil), NewHexMeshProtocol(nil)
+	server.SetChainReader(chain)
+	peer := connectPeers(t, server, client, enode.ID{1})
+
+	// Blocks by hash, skipping unknown ones
+	blocks, err := peer.RequestBlocks([]common.Hash{merge.Hash(), common.HexToHash("0xdead")})
+	if err != nil {
+		t.Fatalf("block request failed: %v", err)
+	}
+	if len(blocks) != 1 || blocks[0].Hash() != merge.Hash() {
+		t.Errorf("blocks: got %d, want merge block", len(blocks))
+	}
+	// Headers by Ethereum hash
+	ethHash := east.Header().ToEthHeader().Hash()
+	headers, err := peer.RequestHeaders([]common.Hash{ethHash})
+	if err != nil {
+		t.Fatalf("header request failed: %v", err)
+	}
+	if len(headers) != 1 || headers[0].Hash() != east.Hash() {
+		t.Errorf("headers by hash: got %d, want east block", len(headers))
+	}
+	// Headers by number range, holding every block of each number
+	headers, err = peer.RequestHeaderRange(1, 2)
+	if err != nil {
+		t.Fatalf("range request failed: %v", err)
+	}
+	if len(headers) != 3 {
+		t.Errorf("headers in range: got %d, want 3", len(headers))
+	}
+
+	// Responses with unrequested blocks are rejected
+	server.SetChainReader(lyingReader{chain})
+	if _, err := peer.RequestBlocks([]common.Hash{merge.Hash()}); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("lying response: got %v, want %v", err, ErrInvalidResponse)
+	}
+	// Bodies must match the roots of their headers
+	server.SetChainReader(stuffingReader{chain})
+	if _, err := peer.RequestBlocks([]common.Hash{merge.Hash()}); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("stuffed body: got %v, want %v", err, ErrInvalidResponse)
+	}
+	// Responses of the wrong kind are rejected
+	peer.requests[1000] = &PendingRequest{ID: 1000, Type: HexBlockRequestMsg, Response: make(chan interface{}, 1)}
+	if err := peer.deliver(1000, HexHeaderRequestMsg, &HexHeaderResponse{}); !errors.Is(err, ErrUnrequestedResponse) {
+		t.Errorf("mismatched response: got %v, want %v", err, ErrUnrequestedResponse)
+	}
+	// Requests beyond the concurrency limit are refused
+	for id := uint64(2000); len(peer.requests) < MaxConcurrentRequests; id++ {
+		peer.requests[id] = &PendingRequest{ID: id, Response: make(chan interface{}, 1)}
+	}
+	if _, err := peer.RequestHeaders(nil); !errors.Is(err, ErrTooManyRequests) {
+		t.Errorf("request over limit: got %v, want %v", err, ErrTooManyRequests)
+	}
+	if pending := len(peer.requests); pending != MaxConcurrentRequests {
+		t.Errorf("pending requests: got %d, want %d", pending, MaxConcurrentRequests)
+	}
+}
+
+func TestRequestsDroppedPeer(t *testing.T) {
+	chain, genesis := newTestChain(t)
+	release := make(chan struct{})
+	defer close(release)
+
+	server, client := NewHexMeshProtocol(nil), NewHexMeshProtocol(nil)
+	server.SetChainReader(stallingReader{chain, release})
+	peer := connectPeers(t, server, client, enode.ID{1})
+
+	// A request pending when the peer leaves fails at once
+	errc := make(chan error, 1)
+	go func() {
+		_, err := peer.RequestBlocks([]common.Hash{genesis.Hash()})
+		errc <- err
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		peer.reqMu.RLock()
+		pending := len(peer.requests)
+		peer.reqMu.RUnlock()
+		if pending > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("request not sent")
+		}
+	}
+	client.RemovePeer(enode.ID{1})
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrPeerDisconnected) {
+			t.Errorf("pending request: got %v, want %v", err, ErrPeerDisconnected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending request not failed")
+	}
+	// Later requests are refused
+	if _, err := peer.RequestHeaders(nil); !errors.Is(err, ErrPeerDisconnected) {
+		t.Errorf("request after removal: got %v, want %v", err, ErrPeerDisconnected)
+	}
+}